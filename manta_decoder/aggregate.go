@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+// aggregateLaneMinutes is the end of the laning phase used for lane CS
+// averages.
+const aggregateLaneMinutes = 10
+
+// aggregateRecord holds the fields aggregate reads from decoder output; every
+// other record kind and field is ignored.
+type aggregateRecord struct {
+	Kind       string  `json:"kind"`
+	PlayerID   int32   `json:"player_id"`
+	Team       uint32  `json:"team"`
+	Hero       string  `json:"hero"`
+	SteamID    uint64  `json:"steam_id"`
+	PlayerName string  `json:"player_name"`
+	Winner     int32   `json:"winner"`
+	Minute     int     `json:"minute"`
+	LastHits   int     `json:"last_hits"`
+	Denies     int     `json:"denies"`
+	Upgrade    string  `json:"upgrade"`
+	Time       float32 `json:"time"`
+}
+
+type matchHero struct {
+	hero     string
+	team     uint32
+	steamID  uint64
+	name     string
+	lastHits int
+	denies   int
+	timings  map[string]float32
+}
+
+// matchSummary is what one replay contributes to the aggregate.
+type matchSummary struct {
+	winner int32
+	hasCS  bool
+	heroes map[string]*matchHero
+}
+
+func (m *matchSummary) hero(name string) *matchHero {
+	h, ok := m.heroes[name]
+	if !ok {
+		h = &matchHero{hero: name, timings: map[string]float32{}}
+		m.heroes[name] = h
+	}
+	return h
+}
+
+func readMatchSummary(r io.Reader) (*matchSummary, error) {
+	m := &matchSummary{heroes: map[string]*matchHero{}}
+	dec := json.NewDecoder(r)
+	for {
+		var rec aggregateRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch rec.Kind {
+		case "roster":
+			if rec.Hero == "" {
+				continue
+			}
+			h := m.hero(rec.Hero)
+			h.team = rec.Team
+			h.steamID = rec.SteamID
+			h.name = rec.PlayerName
+		case "winprob_label":
+			m.winner = rec.Winner
+		case "cs":
+			m.hasCS = true
+			if rec.Minute < aggregateLaneMinutes {
+				h := m.hero(rec.Hero)
+				h.lastHits += rec.LastHits
+				h.denies += rec.Denies
+			}
+		case "aghs":
+			h := m.hero(rec.Hero)
+			if _, ok := h.timings[rec.Upgrade]; !ok {
+				h.timings[rec.Upgrade] = rec.Time
+			}
+		}
+	}
+	return m, nil
+}
+
+// decodeMatchSummary runs the analyzers aggregate needs over a replay and
+// reads their records back, so .dem and .jsonl inputs share one path.
+func decodeMatchSummary(demPath string) (*matchSummary, error) {
+	in, err := os.Open(demPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	parser, err := manta.NewStreamParser(in)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCombatLog(parser)
+	var buf bytes.Buffer
+	out := newOutputState(json.NewEncoder(&buf), false)
+	wrote := 0
+	registerAghs(parser, out, &wrote)
+	finalizers := []func() error{
+		registerLastHits(parser, out, &wrote),
+		registerRoster(parser, out, &wrote),
+		// Only the final label is needed; the interval just keeps feature
+		// vectors out of the way.
+		registerWinProb(parser, out, ^uint32(0), &wrote),
+	}
+	if err := parser.Start(); err != nil {
+		return nil, err
+	}
+	for _, f := range finalizers {
+		if err := f(); err != nil {
+			return nil, err
+		}
+	}
+	return readMatchSummary(&buf)
+}
+
+func loadMatchSummary(path string) (*matchSummary, error) {
+	if strings.EqualFold(filepath.Ext(path), ".dem") {
+		return decodeMatchSummary(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readMatchSummary(f)
+}
+
+type aggregateStats struct {
+	games     int
+	decided   int
+	wins      int
+	laneGames int
+	lastHits  int
+	denies    int
+	timings   map[string][]float32
+	heroes    map[string]int
+	name      string
+}
+
+func newAggregateStats() *aggregateStats {
+	return &aggregateStats{timings: map[string][]float32{}, heroes: map[string]int{}}
+}
+
+func (s *aggregateStats) add(m *matchSummary, h *matchHero) {
+	s.games++
+	s.heroes[h.hero]++
+	if h.name != "" {
+		s.name = h.name
+	}
+	if m.winner == teamRadiant || m.winner == teamDire {
+		s.decided++
+		if uint32(m.winner) == h.team {
+			s.wins++
+		}
+	}
+	if m.hasCS {
+		s.laneGames++
+		s.lastHits += h.lastHits
+		s.denies += h.denies
+	}
+	for upgrade, t := range h.timings {
+		s.timings[upgrade] = append(s.timings[upgrade], t)
+	}
+}
+
+func (s *aggregateStats) record() map[string]any {
+	rec := map[string]any{
+		"games":      s.games,
+		"wins":       s.wins,
+		"lane_games": s.laneGames,
+	}
+	if s.decided > 0 {
+		rec["winrate"] = float64(s.wins) / float64(s.decided)
+	}
+	if s.laneGames > 0 {
+		rec["avg_last_hits_10"] = float64(s.lastHits) / float64(s.laneGames)
+		rec["avg_denies_10"] = float64(s.denies) / float64(s.laneGames)
+	}
+	timings := map[string]any{}
+	for upgrade, ts := range s.timings {
+		var sum float64
+		for _, t := range ts {
+			sum += float64(t)
+		}
+		timings[upgrade] = map[string]any{"games": len(ts), "avg_time": sum / float64(len(ts))}
+	}
+	rec["item_timings"] = timings
+	return rec
+}
+
+// runAggregate implements `aggregate [flags] input...`, merging per-match
+// stats from decoder output (.jsonl written with -roster -winprob -cs -aghs)
+// or replays into one per-hero and per-player summary.
+func runAggregate(args []string) {
+	fs := flag.NewFlagSet("aggregate", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	outPath := fs.String("out", "-", "output path (.json), or '-' for stdout")
+	inputs := global.parseArgs(fs, args, nil)
+
+	if len(inputs) == 0 {
+		fatal(exitUsage, "at least one input is required")
+	}
+
+	heroes := map[string]*aggregateStats{}
+	players := map[uint64]*aggregateStats{}
+	matches := 0
+	for _, path := range inputs {
+		logger.Debug("loading input", "input", path)
+		m, err := loadMatchSummary(path)
+		if err != nil {
+			fatal(exitInvalidReplay, "load input", "input", path, "err", err)
+		}
+		if len(m.heroes) == 0 {
+			warn("no roster records, skipping", "input", path)
+			continue
+		}
+		matches++
+		for _, h := range m.heroes {
+			if h.team != teamRadiant && h.team != teamDire {
+				continue
+			}
+			if heroes[h.hero] == nil {
+				heroes[h.hero] = newAggregateStats()
+			}
+			heroes[h.hero].add(m, h)
+			if h.steamID != 0 {
+				if players[h.steamID] == nil {
+					players[h.steamID] = newAggregateStats()
+				}
+				players[h.steamID].add(m, h)
+			}
+		}
+	}
+
+	heroNames := make([]string, 0, len(heroes))
+	for name := range heroes {
+		heroNames = append(heroNames, name)
+	}
+	sort.Strings(heroNames)
+	heroRecs := make([]map[string]any, 0, len(heroNames))
+	for _, name := range heroNames {
+		rec := heroes[name].record()
+		rec["hero"] = name
+		nameMapping.annotate(rec)
+		heroRecs = append(heroRecs, rec)
+	}
+
+	steamIDs := make([]uint64, 0, len(players))
+	for id := range players {
+		steamIDs = append(steamIDs, id)
+	}
+	sort.Slice(steamIDs, func(i, j int) bool { return steamIDs[i] < steamIDs[j] })
+	playerRecs := make([]map[string]any, 0, len(steamIDs))
+	for _, id := range steamIDs {
+		s := players[id]
+		rec := s.record()
+		rec["steam_id"] = id
+		if s.name != "" {
+			rec["player_name"] = s.name
+		}
+		rec["heroes"] = s.heroes
+		playerRecs = append(playerRecs, rec)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fatal(exitIO, "create output", "err", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]any{
+		"matches": matches,
+		"heroes":  heroRecs,
+		"players": playerRecs,
+	}); err != nil {
+		fatal(exitIO, "write output", "err", err)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+const (
+	upgradeScepter = "scepter"
+	upgradeShard   = "shard"
+)
+
+// aghsSource is how a hero gets an upgrade. A consumed upgrade is the
+// hero's for good; otherwise it lasts while the item is held.
+type aghsSource struct {
+	upgrade  string
+	source   string
+	consumed bool
+}
+
+// aghsPurchases are the items that give the upgrade while held, by raw name.
+var aghsPurchases = map[string]aghsSource{
+	"item_ultimate_scepter": {upgradeScepter, "purchase", false},
+	"item_aghanims_shard":   {upgradeShard, "purchase", false},
+}
+
+// aghsItemUses are consumables whose use grants the upgrade.
+var aghsItemUses = map[string]aghsSource{
+	"item_ultimate_scepter_roshan": {upgradeScepter, "roshan", true},
+	"item_aghanims_shard_roshan":   {upgradeShard, "roshan", true},
+	"item_ultimate_scepter_2":      {upgradeScepter, "consumed", true},
+}
+
+// aghsModifiers are the modifiers an upgrade applies. The shard is consumed
+// as its modifier is applied.
+var aghsModifiers = map[string]aghsSource{
+	"modifier_item_ultimate_scepter_consumed_alchemist": {upgradeScepter, "alchemist", true},
+	"modifier_item_ultimate_scepter_consumed":           {upgradeScepter, "consumed", true},
+	"modifier_item_ultimate_scepter":                    {upgradeScepter, "item", false},
+	"modifier_item_aghanims_shard":                      {upgradeShard, "item", true},
+}
+
+const (
+	// aghsHeldSlots are the m_hItems slots whose items count as the hero's:
+	// inventory and backpack. Stash, teleport and neutral slots do not.
+	aghsHeldSlots = 9
+	// aghsLossSettleTicks is how long (one second) an item that left the
+	// hero's slots may take to show up as consumed before it counts as lost.
+	aghsLossSettleTicks = 30
+)
+
+// aghsLoss is a held upgrade item that left the hero's slots.
+type aghsLoss struct {
+	tick    uint32
+	time    float32
+	hero    string
+	upgrade string
+	item    heldItem
+}
+
+// aghsTracker follows each hero's upgrades. Bought items count once they
+// reach the inventory or backpack, and an item that leaves those slots
+// without being consumed takes its upgrade with it.
+type aghsTracker struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	units  *unitClassifier
+	// acquired, bought, consumed and gave are by hero and upgrade: the
+	// upgrades a hero has now, items bought but not yet held, upgrades that
+	// are permanent, and items an Alchemist gave away.
+	acquired map[string]map[string]bool
+	bought   map[string]map[string]bool
+	consumed map[string]map[string]bool
+	gave     map[string]map[string]bool
+	// held is the upgrade item each hero entity holds in aghsHeldSlots.
+	held   map[int32]map[string]heldItem
+	losses []aghsLoss
+}
+
+// markUpgrade sets set[hero][upgrade] to on.
+func markUpgrade(set map[string]map[string]bool, hero, upgrade string, on bool) {
+	if set[hero] == nil {
+		set[hero] = map[string]bool{}
+	}
+	set[hero][upgrade] = on
+}
+
+func (t *aghsTracker) has(hero, upgrade string) bool {
+	return t.acquired[hero][upgrade]
+}
+
+func (t *aghsTracker) acquire(clock float32, hero string, src aghsSource, via string) error {
+	if hero == "" {
+		return nil
+	}
+	if src.consumed {
+		markUpgrade(t.consumed, hero, src.upgrade, true)
+	}
+	if t.has(hero, src.upgrade) {
+		return nil
+	}
+	markUpgrade(t.acquired, hero, src.upgrade, true)
+	if src.source == "item" && t.bought[hero][src.upgrade] {
+		src.source = "purchase"
+	}
+	markUpgrade(t.bought, hero, src.upgrade, false)
+	rec := map[string]any{
+		"kind":    "aghs",
+		"tick":    t.parser.Tick,
+		"time":    clock,
+		"hero":    hero,
+		"upgrade": src.upgrade,
+		"source":  src.source,
+	}
+	if via != "" && via != hero {
+		rec["from"] = via
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func (t *aghsTracker) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	name := func(idx uint32) string { return lookupCombatLogName(t.parser, idx) }
+	clock := t.rules.clock(m.GetTimestamp())
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_PURCHASE:
+		// A bought item may go to the stash; it counts once it is held.
+		if src, ok := aghsPurchases[lookupRawCombatLogName(t.parser, m.GetValue())]; ok {
+			if hero := name(m.GetTargetName()); hero != "" {
+				markUpgrade(t.bought, hero, src.upgrade, true)
+			}
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ITEM:
+		// Roshan's drops alias to the bought items, so match the raw name.
+		if src, ok := aghsItemUses[lookupRawCombatLogName(t.parser, m.GetInflictorName())]; ok {
+			return t.acquire(clock, name(m.GetAttackerName()), src, "")
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD:
+		if !m.GetIsTargetHero() || m.GetIsTargetIllusion() {
+			return nil
+		}
+		if src, ok := aghsModifiers[name(m.GetInflictorName())]; ok {
+			hero, via := name(m.GetTargetName()), name(m.GetAttackerName())
+			if src.consumed && via != "" && via != hero {
+				// The giver's item was used up, not lost.
+				markUpgrade(t.gave, via, src.upgrade, true)
+			}
+			return t.acquire(clock, hero, src, via)
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ABILITY:
+		if !m.GetIsAttackerHero() || m.GetIsAttackerIllusion() {
+			return nil
+		}
+		hero := name(m.GetAttackerName())
+		rec := map[string]any{
+			"kind":    "ability_cast",
+			"tick":    t.parser.Tick,
+			"time":    t.rules.clock(m.GetTimestamp()),
+			"hero":    hero,
+			"ability": name(m.GetInflictorName()),
+			"level":   m.GetAbilityLevel(),
+			"scepter": m.GetAttackerHasScepter() || t.has(hero, upgradeScepter),
+			"shard":   t.has(hero, upgradeShard),
+		}
+		if target := name(m.GetTargetName()); target != "" && target != hero {
+			rec["target"] = target
+		}
+		(*t.wrote)++
+		return t.out.add(t.parser.Tick, rec, isLunaEclipseCast(t.parser, m))
+	}
+	return nil
+}
+
+// heldUpgrades returns the upgrade items in e's aghsHeldSlots.
+func (t *aghsTracker) heldUpgrades(e *manta.Entity) map[string]heldItem {
+	held := map[string]heldItem{}
+	for _, field := range itemSlotFields[:aghsHeldSlots] {
+		h, ok := e.GetUint32(field)
+		if !ok {
+			break
+		}
+		if h == invalidHandle {
+			continue
+		}
+		item := t.parser.FindEntityByHandle(uint64(h))
+		if item == nil {
+			continue
+		}
+		name := rawEntityUnitName(t.parser, item)
+		if src, ok := aghsPurchases[name]; ok {
+			held[src.upgrade] = heldItem{handle: h, name: canonicalName(name)}
+		}
+	}
+	return held
+}
+
+// stashed reports whether hero's entity holds handle outside aghsHeldSlots.
+func (t *aghsTracker) stashed(hero string, handle uint32) bool {
+	for _, e := range realHeroes(t.parser) {
+		if unit, _, _ := t.units.classify(e); unit != unitHero || entityUnitName(t.parser, e) != hero {
+			continue
+		}
+		for _, field := range itemSlotFields[aghsHeldSlots:] {
+			if h, ok := e.GetUint32(field); ok && h == handle {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *aghsTracker) onEntity(e *manta.Entity, op manta.EntityOp) error {
+	if err := t.settle(false); err != nil {
+		return err
+	}
+	if !isHeroEntity(e) {
+		return nil
+	}
+	idx := e.GetIndex()
+	if op.Flag(manta.EntityOpDeleted) {
+		delete(t.held, idx)
+		return nil
+	}
+	unit, hero, ok := t.units.classify(e)
+	if !ok || unit != unitHero || hero == "" {
+		return nil
+	}
+	prev, cur := t.held[idx], t.heldUpgrades(e)
+	t.held[idx] = cur
+	for _, upgrade := range []string{upgradeScepter, upgradeShard} {
+		_, held := cur[upgrade]
+		before, was := prev[upgrade]
+		switch {
+		case held && !was:
+			if err := t.acquire(t.rules.now(), hero, aghsSource{upgrade: upgrade, source: "item"}, ""); err != nil {
+				return err
+			}
+		case was && !held:
+			t.losses = append(t.losses, aghsLoss{tick: t.parser.Tick, time: t.rules.now(), hero: hero, upgrade: upgrade, item: before})
+		}
+	}
+	return nil
+}
+
+// settle writes the losses older than aghsLossSettleTicks, or all of them
+// at the end of the replay. An item that was consumed, given away or came
+// back meanwhile was not lost. A lost item whose entity is gone was sold, one
+// in the stash was stashed, and any other was dropped or passed on.
+func (t *aghsTracker) settle(final bool) error {
+	if len(t.losses) == 0 {
+		return nil
+	}
+	kept := t.losses[:0]
+	for _, l := range t.losses {
+		if !final && t.parser.Tick < l.tick+aghsLossSettleTicks {
+			kept = append(kept, l)
+			continue
+		}
+		if t.gave[l.hero][l.upgrade] {
+			markUpgrade(t.gave, l.hero, l.upgrade, false)
+			markUpgrade(t.acquired, l.hero, l.upgrade, false)
+			continue
+		}
+		if t.consumed[l.hero][l.upgrade] || t.holding(l.hero, l.upgrade) {
+			continue
+		}
+		reason := "dropped"
+		switch {
+		case t.parser.FindEntityByHandle(uint64(l.item.handle)) == nil:
+			reason = "sold"
+		case t.stashed(l.hero, l.item.handle):
+			reason = "stashed"
+		}
+		markUpgrade(t.acquired, l.hero, l.upgrade, false)
+		rec := map[string]any{
+			"kind":    "aghs_lost",
+			"tick":    t.parser.Tick,
+			"time":    l.time,
+			"hero":    l.hero,
+			"upgrade": l.upgrade,
+			"item":    l.item.name,
+			"reason":  reason,
+		}
+		(*t.wrote)++
+		if err := t.out.add(t.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	t.losses = kept
+	return nil
+}
+
+// holding reports whether hero's entity holds an upgrade item again.
+func (t *aghsTracker) holding(hero, upgrade string) bool {
+	for idx, held := range t.held {
+		if _, ok := held[upgrade]; !ok {
+			continue
+		}
+		if e := t.parser.FindEntity(idx); e != nil && entityUnitName(t.parser, e) == hero {
+			return true
+		}
+	}
+	return false
+}
+
+func registerAghs(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	t := &aghsTracker{
+		parser:   parser,
+		rules:    newGameRulesRef(parser),
+		out:      out,
+		wrote:    wrote,
+		units:    newUnitClassifier(parser),
+		acquired: map[string]map[string]bool{},
+		bought:   map[string]map[string]bool{},
+		consumed: map[string]map[string]bool{},
+		gave:     map[string]map[string]bool{},
+		held:     map[int32]map[string]heldItem{},
+	}
+	subscribeCombatLog(parser, t.onCombatLog)
+	parser.OnEntity(t.onEntity)
+	return func() error {
+		return t.settle(true)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// nameAliases maps unit, ability, item and modifier names that vary with
+// cosmetics, personas or drop source to the canonical name, so records join
+// across matches. -aliases adds to it.
+var nameAliases = map[string]string{
+	// Roshan's drops are consumed like the bought items.
+	"item_ultimate_scepter_roshan": "item_ultimate_scepter",
+	"item_aghanims_shard_roshan":   "item_aghanims_shard",
+}
+
+// variantSuffixes mark persona and cosmetic variants of a name; they may be
+// followed by a number, e.g. npc_dota_hero_invoker_persona1.
+var variantSuffixes = []string{"_persona", "_arcana"}
+
+// namePrefixes are the kind prefixes of names; a variant suffix right after
+// one is the name itself, as in npc_dota_hero_persona.
+var namePrefixes = []string{"npc_dota_hero", "npc_dota", "item", "modifier"}
+
+// stripVariantSuffix removes a trailing persona or arcana marker.
+func stripVariantSuffix(name string) string {
+	for _, suffix := range variantSuffixes {
+		i := strings.LastIndex(name, suffix)
+		if i <= 0 || slices.Contains(namePrefixes, name[:i]) {
+			continue
+		}
+		rest := strings.TrimLeft(name[i+len(suffix):], "_")
+		if strings.Trim(rest, "0123456789") == "" {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+// canonicalName returns the canonical identifier for a unit, ability, item
+// or modifier name as resolved from the string tables.
+func canonicalName(name string) string {
+	if name == "" {
+		return ""
+	}
+	if v, ok := nameAliases[name]; ok {
+		return v
+	}
+	if base, ok := strings.CutPrefix(name, "modifier_"); ok {
+		if v, ok := nameAliases[base]; ok {
+			return "modifier_" + v
+		}
+	}
+	return stripVariantSuffix(name)
+}
+
+// canonicalParticle canonicalizes each directory and file name of a particle
+// resource path, so hero_invoker_persona1/ reads as hero_invoker/.
+func canonicalParticle(path string) string {
+	if v, ok := nameAliases[path]; ok {
+		return v
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		base, ext, _ := strings.Cut(part, ".")
+		if v, ok := nameAliases[base]; ok {
+			base = v
+		} else {
+			base = stripVariantSuffix(base)
+		}
+		if ext != "" {
+			base += "." + ext
+		}
+		parts[i] = base
+	}
+	return strings.Join(parts, "/")
+}
+
+// canonicalString canonicalizes s if it looks like a unit, item, modifier
+// or particle name or has an alias, and returns other strings unchanged.
+func canonicalString(s string) string {
+	switch {
+	case strings.HasPrefix(s, "particles/"):
+		return canonicalParticle(s)
+	case strings.HasPrefix(s, "npc_dota_"), strings.HasPrefix(s, "item_"), strings.HasPrefix(s, "modifier_"):
+		return canonicalName(s)
+	}
+	if v, ok := nameAliases[s]; ok {
+		return v
+	}
+	return s
+}
+
+// canonicalPayload returns m with every name-like string field
+// canonicalized. m is shared with the other callbacks, so a copy is
+// changed, and only when a field needs it.
+func canonicalPayload(m proto.Message) proto.Message {
+	if !rewriteStrings(m.ProtoReflect(), false) {
+		return m
+	}
+	c := proto.Clone(m)
+	rewriteStrings(c.ProtoReflect(), true)
+	return c
+}
+
+// rewriteStrings reports whether m holds a string that canonicalString
+// changes, and with write set replaces those strings. Writes wait until
+// Range is done, since it must not run while the message changes.
+func rewriteStrings(m protoreflect.Message, write bool) bool {
+	changed := false
+	var sets []func()
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				switch fd.Kind() {
+				case protoreflect.StringKind:
+					if s := list.Get(i).String(); canonicalString(s) != s {
+						changed = true
+						sets = append(sets, func() { list.Set(i, protoreflect.ValueOfString(canonicalString(s))) })
+					}
+				case protoreflect.MessageKind, protoreflect.GroupKind:
+					changed = rewriteStrings(list.Get(i).Message(), write) || changed
+				}
+			}
+		case fd.Kind() == protoreflect.StringKind:
+			if s := v.String(); canonicalString(s) != s {
+				changed = true
+				sets = append(sets, func() { m.Set(fd, protoreflect.ValueOfString(canonicalString(s))) })
+			}
+		case fd.Kind() == protoreflect.MessageKind, fd.Kind() == protoreflect.GroupKind:
+			changed = rewriteStrings(v.Message(), write) || changed
+		}
+		return write || !changed
+	})
+	if write {
+		for _, set := range sets {
+			set()
+		}
+	}
+	return changed
+}
+
+// loadNameAliases adds the aliases in a JSON object of variant to canonical
+// names.
+func loadNameAliases(path string) error {
+	var aliases map[string]string
+	if err := readJSONFile(path, &aliases); err != nil {
+		return err
+	}
+	for variant, canonical := range aliases {
+		if variant == "" || canonical == "" {
+			return fmt.Errorf("%s: empty name in %q: %q", path, variant, canonical)
+		}
+		nameAliases[variant] = canonical
+	}
+	return nil
+}
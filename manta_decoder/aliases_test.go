@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dotabuff/manta/dota"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCanonicalName(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"", ""},
+		{"npc_dota_hero_invoker", "npc_dota_hero_invoker"},
+		{"npc_dota_hero_invoker_persona1", "npc_dota_hero_invoker"},
+		{"npc_dota_hero_invoker_persona_1", "npc_dota_hero_invoker"},
+		{"npc_dota_hero_antimage_persona", "npc_dota_hero_antimage"},
+		{"npc_dota_hero_phantom_assassin_arcana", "npc_dota_hero_phantom_assassin"},
+		{"modifier_phantom_assassin_arcana", "modifier_phantom_assassin"},
+		{"npc_dota_hero_persona", "npc_dota_hero_persona"},
+		{"item_arcana", "item_arcana"},
+		{"persona_attack", "persona_attack"},
+		{"invoker_persona_sun_strike", "invoker_persona_sun_strike"},
+		{"item_ultimate_scepter_roshan", "item_ultimate_scepter"},
+		{"item_aghanims_shard_roshan", "item_aghanims_shard"},
+		{"modifier_item_ultimate_scepter_roshan", "modifier_item_ultimate_scepter"},
+		{"item_ultimate_scepter", "item_ultimate_scepter"},
+	}
+	for _, tt := range tests {
+		if got := canonicalName(tt.name); got != tt.want {
+			t.Errorf("canonicalName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"particles/units/heroes/hero_invoker_persona1/sun_strike.vpcf", "particles/units/heroes/hero_invoker/sun_strike.vpcf"},
+		{"particles/units/heroes/hero_invoker/invoker_persona_sun_strike.vpcf", "particles/units/heroes/hero_invoker/invoker_persona_sun_strike.vpcf"},
+		{"item_aghanims_shard_roshan", "item_aghanims_shard"},
+		{"npc_dota_hero_invoker_persona1", "npc_dota_hero_invoker"},
+		{"player_persona", "player_persona"},
+		{"gg", "gg"},
+	}
+	for _, tt := range tests {
+		if got := canonicalString(tt.in); got != tt.want {
+			t.Errorf("canonicalString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalPayload(t *testing.T) {
+	in := &dota.CMsgSource1LegacyGameEvent{
+		EventName: proto.String("dota_player_pick_hero"),
+		Keys: []*dota.CMsgSource1LegacyGameEventKeyT{
+			{ValString: proto.String("npc_dota_hero_invoker_persona1")},
+			{ValString: proto.String("kept")},
+		},
+	}
+	out := canonicalPayload(in).(*dota.CMsgSource1LegacyGameEvent)
+	if got := out.GetKeys()[0].GetValString(); got != "npc_dota_hero_invoker" {
+		t.Errorf("rewritten key = %q", got)
+	}
+	if got := out.GetKeys()[1].GetValString(); got != "kept" {
+		t.Errorf("other key = %q", got)
+	}
+	if got := in.GetKeys()[0].GetValString(); got != "npc_dota_hero_invoker_persona1" {
+		t.Errorf("original changed to %q", got)
+	}
+	same := &dota.CMsgSource1LegacyGameEvent{EventName: proto.String("dota_player_pick_hero")}
+	if canonicalPayload(same) != proto.Message(same) {
+		t.Error("unchanged payload was copied")
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// maxItemSlots bounds the m_hItems array on hero entities: inventory,
+// backpack, stash, teleport and neutral slots.
+const maxItemSlots = 19
+
+// heroItems returns item names by inventory slot; empty slots are omitted.
+func heroItems(parser *manta.Parser, hero *manta.Entity) map[int]string {
+	items := map[int]string{}
+	for i := 0; i < maxItemSlots; i++ {
+		h, ok := hero.GetUint32(fmt.Sprintf("m_hItems.%04d", i))
+		if !ok {
+			break
+		}
+		if h == invalidHandle {
+			continue
+		}
+		if item := parser.FindEntityByHandle(uint64(h)); item != nil {
+			if name := entityUnitName(parser, item); name != "" {
+				items[i] = name
+			}
+		}
+	}
+	return items
+}
+
+type destroyedBuilding struct {
+	Name string  `json:"name"`
+	Type string  `json:"type"`
+	Time float32 `json:"time"`
+}
+
+// stateAt captures the game state once the clock reaches target.
+type stateAt struct {
+	parser    *manta.Parser
+	rules     *gameRulesRef
+	target    float32
+	destroyed []destroyedBuilding
+	state     map[string]any
+}
+
+func (s *stateAt) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH {
+		return nil
+	}
+	name := lookupCombatLogName(s.parser, m.GetTargetName())
+	if label, ok := buildingLabel(name); ok {
+		s.destroyed = append(s.destroyed, destroyedBuilding{Name: name, Type: label, Time: s.rules.clock(m.GetTimestamp())})
+	}
+	return nil
+}
+
+func (s *stateAt) onEntity(_ *manta.Entity, _ manta.EntityOp) error {
+	if s.state != nil {
+		return nil
+	}
+	rules := s.rules.get()
+	if rules == nil {
+		return nil
+	}
+	if start, _ := rules.GetFloat32("m_pGameRules.m_flGameStartTime"); start <= 0 {
+		return nil
+	}
+	clock := s.rules.now()
+	if clock < s.target {
+		return nil
+	}
+	s.state = s.capture(clock)
+	s.parser.Stop()
+	return nil
+}
+
+func (s *stateAt) capture(clock float32) map[string]any {
+	heroes := []map[string]any{}
+	for _, p := range listPlayers(s.parser) {
+		if p.Hero == nil {
+			continue
+		}
+		h := p.Hero
+		level, _ := h.GetInt32("m_iCurrentLevel")
+		hp, _ := h.GetInt32("m_iHealth")
+		maxHP, _ := h.GetInt32("m_iMaxHealth")
+		mana, _ := h.GetFloat32("m_flMana")
+		maxMana, _ := h.GetFloat32("m_flMaxMana")
+		entry := map[string]any{
+			"player_id":  p.ID,
+			"slot":       p.Slot(),
+			"team":       p.Team,
+			"hero":       p.HeroName,
+			"level":      level,
+			"alive":      isAlive(h),
+			"health":     hp,
+			"max_health": maxHP,
+			"mana":       mana,
+			"max_mana":   maxMana,
+			"items":      heroItems(s.parser, h),
+		}
+		if pos, ok := entityWorldPosition(h); ok {
+			entry["world"] = pos
+			entry["minimap"] = pos.Minimap()
+		}
+		if d := teamDataEntity(s.parser, p.Team); d != nil {
+			reliable, _ := d.GetInt32(teamDataField(p.TeamSlot, "m_iReliableGold"))
+			unreliable, _ := d.GetInt32(teamDataField(p.TeamSlot, "m_iUnreliableGold"))
+			entry["gold"] = reliable + unreliable
+			entry["net_worth"], _ = d.GetInt32(teamDataField(p.TeamSlot, "m_iNetWorth"))
+		}
+		nameMapping.annotate(entry)
+		heroes = append(heroes, entry)
+	}
+
+	buildings := []map[string]any{}
+	for _, e := range s.parser.FilterEntity(func(e *manta.Entity) bool {
+		return strings.HasPrefix(e.GetClassName(), "CDOTA_BaseNPC_")
+	}) {
+		name := entityUnitName(s.parser, e)
+		label, ok := buildingLabel(name)
+		if !ok || !isAlive(e) {
+			continue
+		}
+		hp, _ := e.GetInt32("m_iHealth")
+		maxHP, _ := e.GetInt32("m_iMaxHealth")
+		buildings = append(buildings, map[string]any{
+			"name":       name,
+			"type":       label,
+			"team":       entityTeam(e),
+			"health":     hp,
+			"max_health": maxHP,
+		})
+	}
+	sort.Slice(buildings, func(i, j int) bool {
+		return buildings[i]["name"].(string) < buildings[j]["name"].(string)
+	})
+
+	destroyed := s.destroyed
+	if destroyed == nil {
+		destroyed = []destroyedBuilding{}
+	}
+	return map[string]any{
+		"tick":      s.parser.Tick,
+		"time":      clock,
+		"clock":     formatGameClock(clock),
+		"heroes":    heroes,
+		"buildings": buildings,
+		"destroyed": destroyed,
+	}
+}
+
+// runAt implements `at -time mm:ss replay.dem`, printing the game state at
+// that clock time as one JSON document. It is a linear scan from the first
+// tick, stopping once the time is reached: manta drops the string tables in
+// demo full packets, so it cannot start decoding from one.
+func runAt(args []string) {
+	fs := flag.NewFlagSet("at", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	timeFlag := fs.String("time", "", "game clock time to inspect, as seconds or [hh:]mm:ss since the horn")
+	outPath := fs.String("out", "-", "output path (.json), or '-' for stdout")
+	replays := global.parseArgs(fs, args, nil)
+
+	if len(replays) != 1 {
+		fatal(exitUsage, "exactly one replay is required")
+	}
+	if *timeFlag == "" {
+		fatal(exitUsage, "-time is required")
+	}
+	target, err := parseVODOffset(*timeFlag)
+	if err != nil {
+		fatal(exitUsage, "parse -time", "err", err)
+	}
+	logger = logger.With("replay", replays[0])
+
+	in, err := os.Open(replays[0])
+	if err != nil {
+		fatal(exitIO, "open replay", "err", err)
+	}
+	defer in.Close()
+	parser, err := manta.NewStreamParser(in)
+	if err != nil {
+		fatal(exitInvalidReplay, "create parser", "err", err)
+	}
+	s := &stateAt{parser: parser, rules: newGameRulesRef(parser), target: float32(target)}
+	subscribeCombatLog(parser, s.onCombatLog)
+	parser.OnEntity(s.onEntity)
+	if err := parser.Start(); err != nil {
+		fatal(parseExitCode(err), "parse replay", "err", err)
+	}
+	if s.state == nil {
+		fatal(exitUsage, "replay ends before -time", "time", *timeFlag, "last_clock", formatGameClock(s.rules.now()))
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fatal(exitIO, "create output", "err", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.state); err != nil {
+		fatal(exitIO, "write output", "err", err)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+const (
+	// teamSpectator is the m_iPlayerTeam of spectator, caster and coach
+	// slots in CDOTA_PlayerResource.
+	teamSpectator = 1
+	// replayTickRate converts tick counts to seconds.
+	replayTickRate = 30
+	// broadcastSampleTicks is how often spectator slots and draft reserve
+	// time are checked.
+	broadcastSampleTicks = 30
+)
+
+// pauseInterval is a pause as a span of raw game time.
+type pauseInterval struct {
+	start, end float32
+}
+
+// pauseLedger follows m_bGamePaused on the game rules and remembers how much
+// raw game time ran while the game was paused, so the game clock can leave
+// it out.
+type pauseLedger struct {
+	parser    *manta.Parser
+	intervals []pauseInterval
+	paused    bool
+	since     float32
+	sinceTick uint32
+	team      int32
+}
+
+// pauseClock, when set by -exclude-pauses, is subtracted from every game
+// clock the decoder derives.
+var pauseClock *pauseLedger
+
+func registerPauseLedger(parser *manta.Parser) *pauseLedger {
+	l := &pauseLedger{parser: parser}
+	parser.OnEntity(l.onEntity)
+	return l
+}
+
+func (l *pauseLedger) onEntity(e *manta.Entity, _ manta.EntityOp) error {
+	if e.GetClassName() != "CDOTAGamerulesProxy" {
+		return nil
+	}
+	paused, _ := e.GetBool("m_pGameRules.m_bGamePaused")
+	if paused == l.paused {
+		return nil
+	}
+	now, _ := e.GetFloat32("m_pGameRules.m_fGameTime")
+	l.paused = paused
+	if paused {
+		l.since, l.sinceTick = now, l.parser.Tick
+		l.team, _ = e.GetInt32("m_pGameRules.m_iPauseTeam")
+		return nil
+	}
+	l.intervals = append(l.intervals, pauseInterval{start: l.since, end: max(now, l.since)})
+	return nil
+}
+
+// excluded is the raw game time spent paused before gameTime.
+func (l *pauseLedger) excluded(gameTime float32) float32 {
+	var total float32
+	spans := l.intervals
+	if l.paused {
+		spans = append(spans[:len(spans):len(spans)], pauseInterval{start: l.since, end: max(gameTime, l.since)})
+	}
+	for _, p := range spans {
+		if gameTime > p.start {
+			total += min(gameTime, p.end) - p.start
+		}
+	}
+	return total
+}
+
+// spectatorSlot is a spectator seen in CDOTA_PlayerResource.
+type spectatorSlot struct {
+	name    string
+	steamID uint64
+}
+
+// broadcastTracker writes pause, unpause and spectator_join records and, at
+// the end, a broadcast record judging whether the replay is a tournament
+// broadcast: casters among the spectators, a captain's mode draft that ran
+// into reserve time, and pauses.
+type broadcastTracker struct {
+	parser     *manta.Parser
+	rules      *gameRulesRef
+	out        *outputState
+	wrote      *int
+	pauses     *pauseLedger
+	sampler    *tickSampler
+	paused     bool
+	pauser     int32
+	count      int
+	pausedTick uint32
+	spectators map[int32]spectatorSlot
+	casters    []string
+	// reserve holds each team's draft reserve time as first seen, and
+	// reserveUsed how much of it the draft consumed.
+	reserve     map[uint32]float32
+	reserveUsed map[uint32]float32
+	gameMode    int32
+}
+
+func (t *broadcastTracker) emit(kind string, fields map[string]any) error {
+	rec := map[string]any{
+		"kind": kind,
+		"tick": t.parser.Tick,
+		"time": t.rules.now(),
+	}
+	for k, v := range fields {
+		rec[k] = v
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+// onChatEvent remembers who asked for the pause, which the game rules do
+// not carry beyond the team.
+func (t *broadcastTracker) onChatEvent(m *dota.CDOTAUserMsg_ChatEvent) error {
+	if m.GetType() == dota.DOTA_CHAT_MESSAGE_CHAT_MESSAGE_PAUSED {
+		t.pauser = m.GetPlayerid_1()
+	}
+	return nil
+}
+
+func (t *broadcastTracker) onEntity(_ *manta.Entity, _ manta.EntityOp) error {
+	if t.pauses.paused != t.paused {
+		if err := t.onPauseChange(); err != nil {
+			return err
+		}
+	}
+	if !t.sampler.due(t.parser.Tick) {
+		return nil
+	}
+	t.sampleDraft()
+	return t.sampleSpectators()
+}
+
+func (t *broadcastTracker) onPauseChange() error {
+	t.paused = t.pauses.paused
+	if t.paused {
+		t.count++
+		fields := map[string]any{}
+		if t.pauses.team == teamRadiant || t.pauses.team == teamDire {
+			fields["team"] = t.pauses.team
+		}
+		if t.pauser >= 0 {
+			fields["player"] = t.pauser
+			if hero := heroNamesByPlayerID(t.parser)[t.pauser]; hero != "" {
+				fields["hero"] = hero
+			}
+		}
+		return t.emit("pause", fields)
+	}
+	t.pauser = -1
+	ticks := t.parser.Tick - t.pauses.sinceTick
+	t.pausedTick += ticks
+	last := t.pauses.intervals[len(t.pauses.intervals)-1]
+	return t.emit("unpause", map[string]any{
+		"duration":      float32(ticks) / replayTickRate,
+		"clock_seconds": last.end - last.start,
+	})
+}
+
+// sampleDraft records how much of each team's reserve time a captain's mode
+// draft used.
+func (t *broadcastTracker) sampleDraft() {
+	rules := t.rules.get()
+	if rules == nil {
+		return
+	}
+	if mode, ok := rules.GetInt32("m_pGameRules.m_iGameMode"); ok {
+		t.gameMode = mode
+	}
+	state, _ := rules.GetInt32("m_pGameRules.m_nGameState")
+	if state != int32(dota.DOTA_GameState_DOTA_GAMERULES_STATE_HERO_SELECTION) {
+		return
+	}
+	for i, team := range []uint32{teamRadiant, teamDire} {
+		left, ok := rules.GetFloat32(fmt.Sprintf("m_pGameRules.m_fExtraTimeRemaining.%04d", i))
+		if !ok {
+			continue
+		}
+		first, seen := t.reserve[team]
+		if !seen {
+			t.reserve[team] = left
+			continue
+		}
+		if used := first - left; used > t.reserveUsed[team] {
+			t.reserveUsed[team] = used
+		}
+	}
+}
+
+func (t *broadcastTracker) sampleSpectators() error {
+	pr := findPlayerResource(t.parser)
+	if pr == nil {
+		return nil
+	}
+	for id := int32(0); id < maxPlayers; id++ {
+		team, ok := pr.GetInt32(playerDataField(id, "m_iPlayerTeam"))
+		if !ok || team != teamSpectator {
+			continue
+		}
+		name, _ := pr.GetString(playerDataField(id, "m_iszPlayerName"))
+		steamID, _ := pr.GetUint64(playerDataField(id, "m_iPlayerSteamID"))
+		slot := spectatorSlot{name: name, steamID: steamID}
+		if prev, ok := t.spectators[id]; ok && prev == slot {
+			continue
+		}
+		t.spectators[id] = slot
+		caster, _ := pr.GetBool(playerDataField(id, "m_bIsBroadcaster"))
+		fields := map[string]any{
+			"player": id,
+			"name":   name,
+			"caster": caster,
+		}
+		if steamID != 0 {
+			fields["steam_id"] = steamID
+		}
+		if caster {
+			t.casters = append(t.casters, name)
+			if channel, ok := pr.GetInt32(playerDataField(id, "m_iBroadcasterChannel")); ok {
+				fields["channel"] = channel
+			}
+			if channelSlot, ok := pr.GetInt32(playerDataField(id, "m_iBroadcasterChannelSlot")); ok {
+				fields["channel_slot"] = channelSlot
+			}
+		}
+		if err := t.emit("spectator_join", fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalize writes the broadcast record. A replay counts as a broadcast
+// when casters watched it, or when a captain's mode draft ran into reserve
+// time and the game was paused.
+func (t *broadcastTracker) finalize() error {
+	evidence := []string{}
+	if len(t.casters) > 0 {
+		evidence = append(evidence, "casters")
+	}
+	reserve := false
+	for _, used := range t.reserveUsed {
+		reserve = reserve || used > 0
+	}
+	if reserve {
+		evidence = append(evidence, "draft_reserve_time")
+	}
+	if t.count > 0 {
+		evidence = append(evidence, "pauses")
+	}
+	pausedTicks := t.pausedTick
+	if t.paused {
+		pausedTicks += t.parser.Tick - t.pauses.sinceTick
+	}
+	fields := map[string]any{
+		"broadcast":      len(t.casters) > 0 || (reserve && t.count > 0),
+		"evidence":       evidence,
+		"game_mode":      t.gameMode,
+		"spectators":     len(t.spectators),
+		"casters":        t.casters,
+		"pauses":         t.count,
+		"paused_seconds": float32(pausedTicks) / replayTickRate,
+	}
+	if len(t.reserveUsed) > 0 {
+		fields["reserve_time_used"] = map[string]float32{
+			"radiant": t.reserveUsed[teamRadiant],
+			"dire":    t.reserveUsed[teamDire],
+		}
+	}
+	return t.emit("broadcast", fields)
+}
+
+func registerBroadcast(parser *manta.Parser, out *outputState, pauses *pauseLedger, wrote *int) func() error {
+	t := &broadcastTracker{
+		parser:      parser,
+		rules:       newGameRulesRef(parser),
+		out:         out,
+		wrote:       wrote,
+		pauses:      pauses,
+		sampler:     newTickSampler(broadcastSampleTicks),
+		pauser:      -1,
+		spectators:  map[int32]spectatorSlot{},
+		casters:     []string{},
+		reserve:     map[uint32]float32{},
+		reserveUsed: map[uint32]float32{},
+	}
+	parser.Callbacks.OnCDOTAUserMsg_ChatEvent(t.onChatEvent)
+	parser.OnEntity(t.onEntity)
+	return t.finalize
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// backdoorModifier is on a building while backdoor protection is engaged,
+// i.e. no enemy creeps are nearby and it regenerates and takes less damage.
+const backdoorModifier = "modifier_backdoor_protection_active"
+
+// buildingTracker samples tower, barracks and ancient health and follows
+// backdoor protection through the combat log.
+type buildingTracker struct {
+	parser   *manta.Parser
+	rules    *gameRulesRef
+	out      *outputState
+	wrote    *int
+	sampler  *tickSampler
+	backdoor map[string]bool
+}
+
+func isBuildingEntity(e *manta.Entity) bool {
+	class := e.GetClassName()
+	return strings.HasPrefix(class, "CDOTA_BaseNPC_Tower") ||
+		strings.HasPrefix(class, "CDOTA_BaseNPC_Barracks") ||
+		strings.HasPrefix(class, "CDOTA_BaseNPC_Fort")
+}
+
+func (t *buildingTracker) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	typ := m.GetType()
+	if typ != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD &&
+		typ != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REMOVE {
+		return nil
+	}
+	if lookupCombatLogName(t.parser, m.GetInflictorName()) != backdoorModifier {
+		return nil
+	}
+	building := lookupCombatLogName(t.parser, m.GetTargetName())
+	label, ok := buildingLabel(building)
+	if !ok {
+		return nil
+	}
+	active := typ == dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD
+	if t.backdoor[building] == active {
+		return nil
+	}
+	t.backdoor[building] = active
+	rec := map[string]any{
+		"kind":     "backdoor_protection",
+		"tick":     t.parser.Tick,
+		"time":     t.rules.clock(m.GetTimestamp()),
+		"building": building,
+		"type":     label,
+		"active":   active,
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func (t *buildingTracker) onEntity(_ *manta.Entity, _ manta.EntityOp) error {
+	if !t.sampler.due(t.parser.Tick) {
+		return nil
+	}
+	buildings := t.parser.FilterEntity(func(e *manta.Entity) bool {
+		return isBuildingEntity(e) && isAlive(e)
+	})
+	sort.Slice(buildings, func(i, j int) bool { return buildings[i].GetIndex() < buildings[j].GetIndex() })
+	clock := t.rules.now()
+	for _, e := range buildings {
+		name := entityUnitName(t.parser, e)
+		label, ok := buildingLabel(name)
+		if !ok {
+			continue
+		}
+		hp, _ := e.GetInt32("m_iHealth")
+		maxHP, _ := e.GetInt32("m_iMaxHealth")
+		rec := map[string]any{
+			"kind":                "building_hp",
+			"tick":                t.parser.Tick,
+			"time":                clock,
+			"building":            name,
+			"type":                label,
+			"team":                entityTeam(e),
+			"health":              hp,
+			"max_health":          maxHP,
+			"backdoor_protection": t.backdoor[name],
+		}
+		if maxHP > 0 {
+			rec["health_share"] = float32(hp) / float32(maxHP)
+		}
+		(*t.wrote)++
+		if err := t.out.add(t.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerBuildingHP(parser *manta.Parser, out *outputState, interval uint32, wrote *int) {
+	t := &buildingTracker{
+		parser:   parser,
+		rules:    newGameRulesRef(parser),
+		out:      out,
+		wrote:    wrote,
+		sampler:  newTickSampler(interval),
+		backdoor: map[string]bool{},
+	}
+	subscribeCombatLog(parser, t.onCombatLog)
+	parser.OnEntity(t.onEntity)
+}
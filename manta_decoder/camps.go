@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+const (
+	// pullWindow groups lane creep deaths to neutrals at the same camp into
+	// one pull (game seconds).
+	pullWindow = 30
+	// pullCampRadius is how close successive deaths must be to the first
+	// one to belong to the same pull, and how close the puller must stand.
+	pullCampRadius = 1200
+)
+
+var neutralCampTypes = map[uint32]string{
+	0: "small",
+	1: "medium",
+	2: "large",
+	3: "ancient",
+}
+
+func neutralCampType(t uint32) string {
+	if name, ok := neutralCampTypes[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+type creepPull struct {
+	tick   uint32
+	start  float32
+	last   float32
+	team   uint32
+	hero   string
+	where  mapcoord.World
+	killed int
+}
+
+type campCounts struct {
+	Stacks int
+	Pulls  int
+}
+
+type campTracker struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	pulls  []*creepPull
+	counts map[string]*campCounts
+}
+
+func (t *campTracker) count(hero string) *campCounts {
+	c, ok := t.counts[hero]
+	if !ok {
+		c = &campCounts{}
+		t.counts[hero] = c
+	}
+	return c
+}
+
+func (t *campTracker) write(rec map[string]any) error {
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func (t *campTracker) onStack(m *dota.CMsgDOTACombatLogEntry, clock float32) error {
+	hero := lookupCombatLogName(t.parser, m.GetAttackerName())
+	if hero != "" {
+		t.count(hero).Stacks++
+	}
+	pos := mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())}
+	return t.write(map[string]any{
+		"kind":      "stack",
+		"tick":      t.parser.Tick,
+		"time":      clock,
+		"hero":      hero,
+		"camp_type": neutralCampType(m.GetNeutralCampType()),
+		"camp_team": m.GetNeutralCampTeam(),
+		"stacks":    m.GetValue(),
+		"world":     pos,
+		"minimap":   pos.Minimap(),
+	})
+}
+
+// puller finds the hero of team standing closest to a pulled camp.
+func (t *campTracker) puller(team uint32, where mapcoord.World) string {
+	best, bestDist := "", float64(pullCampRadius)
+	for _, h := range realHeroes(t.parser) {
+		if entityTeam(h) != team || !isAlive(h) {
+			continue
+		}
+		pos, ok := entityWorldPosition(h)
+		if !ok {
+			continue
+		}
+		if d := mapcoord.Distance2D(pos, where); d <= bestDist {
+			best, bestDist = entityUnitName(t.parser, h), d
+		}
+	}
+	return best
+}
+
+func (t *campTracker) onLaneCreepDeath(m *dota.CMsgDOTACombatLogEntry, clock float32) {
+	where := mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())}
+	team := m.GetTargetTeam()
+	for _, p := range t.pulls {
+		if p.team == team && mapcoord.Distance2D(p.where, where) <= pullCampRadius {
+			p.killed++
+			p.last = clock
+			return
+		}
+	}
+	t.pulls = append(t.pulls, &creepPull{
+		tick:   t.parser.Tick,
+		start:  clock,
+		last:   clock,
+		team:   team,
+		hero:   t.puller(team, where),
+		where:  where,
+		killed: 1,
+	})
+}
+
+func (t *campTracker) closePulls(clock float32, all bool) error {
+	kept := t.pulls[:0]
+	for _, p := range t.pulls {
+		if !all && clock-p.start <= pullWindow {
+			kept = append(kept, p)
+			continue
+		}
+		if p.hero != "" {
+			t.count(p.hero).Pulls++
+		}
+		if err := t.write(map[string]any{
+			"kind":        "pull",
+			"tick":        t.parser.Tick,
+			"start_tick":  p.tick,
+			"time":        p.start,
+			"hero":        p.hero,
+			"team":        p.team,
+			"creeps_lost": p.killed,
+			"world":       p.where,
+			"minimap":     p.where.Minimap(),
+		}); err != nil {
+			return err
+		}
+	}
+	t.pulls = kept
+	return nil
+}
+
+func (t *campTracker) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	clock := t.rules.clock(m.GetTimestamp())
+	if err := t.closePulls(clock, false); err != nil {
+		return err
+	}
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_NEUTRAL_CAMP_STACK:
+		return t.onStack(m, clock)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		target := lookupCombatLogName(t.parser, m.GetTargetName())
+		attacker := lookupCombatLogName(t.parser, m.GetAttackerName())
+		if creepType(target) == "lane" && creepType(attacker) == "neutral" {
+			t.onLaneCreepDeath(m, clock)
+		}
+	}
+	return nil
+}
+
+func (t *campTracker) finalize() error {
+	if err := t.closePulls(0, true); err != nil {
+		return err
+	}
+	heroes := make([]string, 0, len(t.counts))
+	for h := range t.counts {
+		heroes = append(heroes, h)
+	}
+	sort.Strings(heroes)
+	for _, hero := range heroes {
+		c := t.counts[hero]
+		if err := t.write(map[string]any{
+			"kind":   "camps",
+			"tick":   t.parser.Tick,
+			"hero":   hero,
+			"stacks": c.Stacks,
+			"pulls":  c.Pulls,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerCamps(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	t := &campTracker{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		counts: map[string]*campCounts{},
+	}
+	subscribeCombatLog(parser, t.onCombatLog)
+	return t.finalize
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+func ccType(modifier string, m *dota.CMsgDOTACombatLogEntry) string {
+	switch {
+	case m.GetRootModifier():
+		return "root"
+	case m.GetSilenceModifier():
+		return "silence"
+	case strings.Contains(modifier, "hex"), strings.Contains(modifier, "voodoo"), strings.Contains(modifier, "sheepstick"):
+		return "hex"
+	case strings.Contains(modifier, "stun"), strings.Contains(modifier, "bash"):
+		return "stun"
+	}
+	return ""
+}
+
+type ccKey struct {
+	attacker, target, modifier uint32
+}
+
+type ccApplied struct {
+	kind         string
+	attacker     string
+	target       string
+	modifier     string
+	attackerHero bool
+	targetHero   bool
+	start        float32
+	startTick    uint32
+	duration     float32
+	fight        *fight
+}
+
+type ccTotals struct {
+	Dealt    map[string]float32 `json:"dealt"`
+	Received map[string]float32 `json:"received"`
+}
+
+func newCCTotals() *ccTotals {
+	return &ccTotals{Dealt: map[string]float32{}, Received: map[string]float32{}}
+}
+
+type ccTotalsByHero map[string]*ccTotals
+
+func (t ccTotalsByHero) add(c *ccApplied, seconds float32) {
+	if c.attackerHero {
+		if t[c.attacker] == nil {
+			t[c.attacker] = newCCTotals()
+		}
+		t[c.attacker].Dealt[c.kind] += seconds
+	}
+	if c.targetHero {
+		if t[c.target] == nil {
+			t[c.target] = newCCTotals()
+		}
+		t[c.target].Received[c.kind] += seconds
+	}
+}
+
+func (t ccTotalsByHero) heroes() []string {
+	names := make([]string, 0, len(t))
+	for h := range t {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type ccStats struct {
+	parser  *manta.Parser
+	rules   *gameRulesRef
+	fights  *fightDetector
+	out     *outputState
+	wrote   *int
+	active  map[ccKey]*ccApplied
+	game    ccTotalsByHero
+	byFight map[int]ccTotalsByHero
+}
+
+func (s *ccStats) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	t := m.GetType()
+	if t != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD &&
+		t != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REMOVE {
+		return nil
+	}
+	key := ccKey{m.GetAttackerName(), m.GetTargetName(), m.GetInflictorName()}
+	clock := s.rules.clock(m.GetTimestamp())
+
+	if t == dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REMOVE {
+		c, ok := s.active[key]
+		if !ok {
+			return nil
+		}
+		delete(s.active, key)
+		return s.finish(c, clock-c.start)
+	}
+
+	attackerHero := m.GetIsAttackerHero() && !m.GetIsAttackerIllusion()
+	targetHero := m.GetIsTargetHero() && !m.GetIsTargetIllusion()
+	if !attackerHero && !targetHero {
+		return nil
+	}
+	modifier := lookupCombatLogName(s.parser, m.GetInflictorName())
+	kind := ccType(modifier, m)
+	if kind == "" {
+		return nil
+	}
+	if _, ok := s.active[key]; ok {
+		// Refreshed before expiring; keep the original start.
+		return nil
+	}
+	s.active[key] = &ccApplied{
+		kind:         kind,
+		attacker:     lookupCombatLogName(s.parser, m.GetAttackerName()),
+		target:       lookupCombatLogName(s.parser, m.GetTargetName()),
+		modifier:     modifier,
+		attackerHero: attackerHero,
+		targetHero:   targetHero,
+		start:        clock,
+		startTick:    s.parser.Tick,
+		duration:     m.GetModifierDuration(),
+		fight:        s.fights.current(),
+	}
+	return nil
+}
+
+func (s *ccStats) finish(c *ccApplied, seconds float32) error {
+	if seconds < 0 {
+		seconds = 0
+	}
+	s.game.add(c, seconds)
+	record := map[string]any{
+		"kind":       "cc",
+		"tick":       s.parser.Tick,
+		"start_tick": c.startTick,
+		"time":       c.start,
+		"attacker":   c.attacker,
+		"target":     c.target,
+		"modifier":   c.modifier,
+		"cc_type":    c.kind,
+		"duration":   seconds,
+	}
+	if c.fight != nil {
+		if s.byFight[c.fight.ID] == nil {
+			s.byFight[c.fight.ID] = ccTotalsByHero{}
+		}
+		s.byFight[c.fight.ID].add(c, seconds)
+		record["fight_id"] = c.fight.ID
+	}
+	(*s.wrote)++
+	return s.out.add(s.parser.Tick, record, false)
+}
+
+func (s *ccStats) onFightClose(f *fight, record map[string]any) error {
+	totals := s.byFight[f.ID]
+	delete(s.byFight, f.ID)
+	if record == nil {
+		return nil
+	}
+	for _, hero := range totals.heroes() {
+		rec := map[string]any{
+			"kind":     "cc_fight",
+			"tick":     s.parser.Tick,
+			"fight_id": f.ID,
+			"hero":     hero,
+			"dealt":    totals[hero].Dealt,
+			"received": totals[hero].Received,
+		}
+		(*s.wrote)++
+		if err := s.out.add(s.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeActive closes modifiers still applied at the end of the replay,
+// using their logged duration when present. It runs before the fight
+// detector closes the last fight, so that fight's totals include them.
+func (s *ccStats) closeActive() error {
+	for key, c := range s.active {
+		delete(s.active, key)
+		seconds := c.duration
+		if seconds <= 0 {
+			seconds = s.rules.now() - c.start
+		}
+		if err := s.finish(c, seconds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalize emits whole-game totals.
+func (s *ccStats) finalize() error {
+	for _, hero := range s.game.heroes() {
+		record := map[string]any{
+			"kind":     "cc_game",
+			"tick":     s.parser.Tick,
+			"hero":     hero,
+			"dealt":    s.game[hero].Dealt,
+			"received": s.game[hero].Received,
+		}
+		(*s.wrote)++
+		if err := s.out.add(s.parser.Tick, record, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerCC(parser *manta.Parser, out *outputState, fights *fightDetector, wrote *int) func() error {
+	stats := &ccStats{
+		parser:  parser,
+		rules:   newGameRulesRef(parser),
+		fights:  fights,
+		out:     out,
+		wrote:   wrote,
+		active:  map[ccKey]*ccApplied{},
+		game:    ccTotalsByHero{},
+		byFight: map[int]ccTotalsByHero{},
+	}
+	subscribeCombatLog(parser, stats.onCombatLog)
+	fights.subscribe(stats.onFightClose)
+	fights.beforeFinalize(stats.closeActive)
+	return stats.finalize
+}
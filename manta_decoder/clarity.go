@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// clarityHandleIndexBits matches the Source 2 entity handle layout clarity
+// exposes: low bits are the entity index, the rest is the serial.
+const clarityHandleIndexBits = 14
+
+// clarityWriter emits records whose field names follow the getters of the
+// clarity toolkit (CombatLogEntry, Entity) in lower camel case, so existing
+// clarity post-processing can read them with only a key rename per getter.
+type clarityWriter struct {
+	parser *manta.Parser
+	out    *outputState
+	wrote  *int
+}
+
+func (w *clarityWriter) write(rec map[string]any, matches bool) error {
+	(*w.wrote)++
+	return w.out.add(w.parser.Tick, rec, matches)
+}
+
+func (w *clarityWriter) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	name := func(idx uint32) string { return lookupCombatLogName(w.parser, idx) }
+	return w.write(map[string]any{
+		"event":            "combatlog",
+		"tick":             w.parser.Tick,
+		"type":             dota.DOTA_COMBATLOG_TYPES_name[int32(m.GetType())],
+		"timestamp":        m.GetTimestamp(),
+		"attackerName":     name(m.GetAttackerName()),
+		"targetName":       name(m.GetTargetName()),
+		"targetSourceName": name(m.GetTargetSourceName()),
+		"damageSourceName": name(m.GetDamageSourceName()),
+		"inflictorName":    name(m.GetInflictorName()),
+		"attackerIllusion": m.GetIsAttackerIllusion(),
+		"attackerHero":     m.GetIsAttackerHero(),
+		"targetIllusion":   m.GetIsTargetIllusion(),
+		"targetHero":       m.GetIsTargetHero(),
+		"visibleRadiant":   m.GetIsVisibleRadiant(),
+		"visibleDire":      m.GetIsVisibleDire(),
+		"value":            m.GetValue(),
+		"health":           m.GetHealth(),
+		"stunDuration":     m.GetStunDuration(),
+		"slowDuration":     m.GetSlowDuration(),
+		"abilityToggleOn":  m.GetIsAbilityToggleOn(),
+		"abilityToggleOff": m.GetIsAbilityToggleOff(),
+		"abilityLevel":     m.GetAbilityLevel(),
+		"goldReason":       m.GetGoldReason(),
+		"xpReason":         m.GetXpReason(),
+		"attackerTeam":     m.GetAttackerTeam(),
+		"targetTeam":       m.GetTargetTeam(),
+		"modifierDuration": m.GetModifierDuration(),
+		"lastHits":         m.GetLastHits(),
+		"ultimateAbility":  m.GetIsUltimateAbility(),
+	}, isLunaEclipseCast(w.parser, m))
+}
+
+// onEntity mirrors clarity's @OnEntityCreated / @OnEntityDeleted. Updates
+// are not emitted; created records carry the full property map.
+func (w *clarityWriter) onEntity(e *manta.Entity, op manta.EntityOp) error {
+	var event string
+	switch {
+	case op.Flag(manta.EntityOpCreated):
+		event = "entityCreated"
+	case op.Flag(manta.EntityOpDeleted):
+		event = "entityDeleted"
+	default:
+		return nil
+	}
+	rec := map[string]any{
+		"event":   event,
+		"tick":    w.parser.Tick,
+		"index":   e.GetIndex(),
+		"serial":  e.GetSerial(),
+		"handle":  int64(e.GetIndex()) | int64(e.GetSerial())<<clarityHandleIndexBits,
+		"dtClass": e.GetClassName(),
+	}
+	if event == "entityCreated" {
+		rec["properties"] = e.Map()
+	}
+	return w.write(rec, false)
+}
+
+func registerClarity(parser *manta.Parser, out *outputState, wrote *int) {
+	w := &clarityWriter{parser: parser, out: out, wrote: wrote}
+	subscribeCombatLog(parser, w.onCombatLog)
+	parser.OnEntity(w.onEntity)
+}
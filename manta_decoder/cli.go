@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dotabuff/manta"
+)
+
+type subcommand struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// subcommands lists every entry point of the binary. A bare flag list runs
+// events, as the decoder did before subcommands existed.
+var subcommands = []subcommand{
+	{"events", "decode a replay into JSONL records (raw callbacks plus any enabled extractors)", func(args []string) {
+		runEvents("events", args, nil)
+	}},
+	{"combatlog", "print the combat log as text lines (events with -format=text)", func(args []string) {
+		runEvents("combatlog", args, map[string]string{"format": "text"})
+	}},
+	{"entities", "emit hero positions every 30 ticks without raw callbacks", func(args []string) {
+		runEvents("entities", args, map[string]string{"raw": "false", "positions": "30"})
+	}},
+	{"summary", "emit end-of-replay roster, damage, gold, CS, participation and Aghanim's records", func(args []string) {
+		runEvents("summary", args, map[string]string{
+			"raw": "false", "roster": "true", "damage": "true", "gold": "true",
+			"cs": "true", "participation": "true", "aghs": "true",
+		})
+	}},
+	{"at", "print the game state at a clock time as one JSON document (a linear scan up to that time)", runAt},
+	{"aggregate", "merge per-match stats from many outputs or replays", runAggregate},
+	{"dataset", "convert replays into .npz training shards", runDataset},
+	{"chapters", "write VOD chapter markers for a replay", runChapters},
+	{"fetch", "download dotaconstants display-name files (alias: refresh-names)", runRefreshNames},
+	{"refresh-names", "", runRefreshNames},
+	{"grep", "list the replays (and first tick) where event counts satisfy a -filter", runGrep},
+	{"dict", "train a compression dictionary from records sampled across outputs or replays", runDict},
+	{"bench", "measure parse throughput for replays", runBench},
+	{"serve", "serve events output over HTTP for replays in a directory or uploaded", runServe},
+}
+
+func findSubcommand(name string) *subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [args]\n\ncommands:\n", os.Args[0])
+	for _, c := range subcommands {
+		if c.summary != "" {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nglobal flags, accepted by every command: -config, -names, -aliases, -v, -log-format, -strict\nrun '%s <command> -h' for command flags\n", os.Args[0])
+}
+
+// globalFlags are shared by every subcommand.
+type globalFlags struct {
+	config    *string
+	names     *string
+	aliases   *string
+	verbose   *bool
+	logFormat *string
+	strict    *bool
+}
+
+func addGlobalFlags(fs *flag.FlagSet) *globalFlags {
+	return &globalFlags{
+		config:    fs.String("config", "", "YAML/TOML file of flag settings (inputs, filters, extractors, sinks); command-line flags override it"),
+		names:     fs.String("names", "", "directory of dotaconstants heroes/abilities/items JSON (see fetch) used to add *_display names"),
+		aliases:   fs.String("aliases", "", "JSON object mapping persona, cosmetic or renamed unit/ability/item names to the canonical names used in every output"),
+		verbose:   fs.Bool("v", false, "log debug messages"),
+		logFormat: fs.String("log-format", "text", "log format on stderr: text or json"),
+		strict:    fs.Bool("strict", false, "fail on the first recoverable warning (replays newer than the bundled manta, unnamed game event descriptors, unusable inputs)"),
+	}
+}
+
+// parseArgs parses flags that may be interleaved with positional arguments
+// (`events replay.dem -cs`), then fills unset flags from -config and finally
+// from presets, sets up logging and loads -names and -aliases. It returns the positional
+// arguments.
+func (g *globalFlags) parseArgs(fs *flag.FlagSet, args []string, presets map[string]string) []string {
+	// Flag errors exit with exitUsage rather than the flag package's 2,
+	// which is taken by exitWarnings.
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	var positional []string
+	for {
+		if err := fs.Parse(args); err == flag.ErrHelp {
+			os.Exit(exitOK)
+		} else if err != nil {
+			os.Exit(exitUsage)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if *g.config != "" {
+		if err := applyConfig(fs, *g.config); err != nil {
+			fatal(exitUsage, "config", "command", fs.Name(), "err", err)
+		}
+	}
+	if err := setupLogging(*g.verbose, *g.logFormat); err != nil {
+		fatal(exitUsage, "logging", "command", fs.Name(), "err", err)
+	}
+	logger = logger.With("command", fs.Name())
+	strictMode = *g.strict
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	keys := make([]string, 0, len(presets))
+	for k := range presets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !set[k] {
+			if err := fs.Set(k, presets[k]); err != nil {
+				fatal(exitUsage, "preset", "flag", k, "err", err)
+			}
+		}
+	}
+	if *g.names != "" {
+		names, err := loadDisplayNames(*g.names)
+		if err != nil {
+			fatal(exitIO, "load names", "err", err)
+		}
+		nameMapping = names
+	}
+	if *g.aliases != "" {
+		if err := loadNameAliases(*g.aliases); err != nil {
+			fatal(exitIO, "load aliases", "err", err)
+		}
+	}
+	return positional
+}
+
+// runBench implements `bench [flags] replay.dem...`, parsing each replay
+// with no extractors and reporting ticks and bytes per second.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	runs := fs.Int("runs", 1, "parse each replay this many times")
+	replays := global.parseArgs(fs, args, nil)
+
+	if len(replays) == 0 {
+		fatal(exitUsage, "at least one replay is required")
+	}
+	if *runs < 1 {
+		fatal(exitUsage, "-runs must be positive")
+	}
+	for _, dem := range replays {
+		info, err := os.Stat(dem)
+		if err != nil {
+			fatal(exitIO, "stat replay", "err", err)
+		}
+		for i := 0; i < *runs; i++ {
+			in, err := os.Open(dem)
+			if err != nil {
+				fatal(exitIO, "open replay", "err", err)
+			}
+			start := time.Now()
+			parser, err := manta.NewStreamParser(in)
+			if err != nil {
+				in.Close()
+				fatal(exitInvalidReplay, "create parser", "replay", dem, "err", err)
+			}
+			err = parser.Start()
+			elapsed := time.Since(start)
+			in.Close()
+			if err != nil {
+				fatal(exitInvalidReplay, "parse replay", "replay", dem, "err", err)
+			}
+			secs := elapsed.Seconds()
+			fmt.Printf("%s\trun=%d\tticks=%d\telapsed=%s\tticks/s=%.0f\tMB/s=%.1f\n",
+				dem, i+1, parser.Tick, elapsed.Round(time.Millisecond),
+				float64(parser.Tick)/secs, float64(info.Size())/secs/1e6)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// combatLogFeed is the one parser callback for combat log entries. Every
+// extractor subscribes through it, in registration order, so -dedup drops a
+// re-delivered entry once before any of them counts it.
+type combatLogFeed struct {
+	parser   *manta.Parser
+	dedup    *messageDedup
+	handlers []func(*dota.CMsgDOTACombatLogEntry) error
+}
+
+// combatLogFeeds holds the feed of each parser; subcommands that decode
+// several replays, in parallel for grep, get one per parser.
+var (
+	combatLogMu    sync.Mutex
+	combatLogFeeds = map[*manta.Parser]*combatLogFeed{}
+)
+
+func combatLog(parser *manta.Parser) *combatLogFeed {
+	combatLogMu.Lock()
+	defer combatLogMu.Unlock()
+	if f, ok := combatLogFeeds[parser]; ok {
+		return f
+	}
+	f := &combatLogFeed{parser: parser}
+	combatLogFeeds[parser] = f
+	parser.Callbacks.OnCMsgDOTACombatLogEntry(f.dispatch)
+	return f
+}
+
+// releaseCombatLog forgets the parser's feed once its replay is done.
+func releaseCombatLog(parser *manta.Parser) {
+	combatLogMu.Lock()
+	defer combatLogMu.Unlock()
+	delete(combatLogFeeds, parser)
+}
+
+// subscribeCombatLog adds fn to the parser's combat log feed.
+func subscribeCombatLog(parser *manta.Parser, fn func(*dota.CMsgDOTACombatLogEntry) error) {
+	f := combatLog(parser)
+	f.handlers = append(f.handlers, fn)
+}
+
+// dedupCombatLog makes the parser's feed drop entries re-delivered by demo
+// full packets, and returns the dedup counting them.
+func dedupCombatLog(parser *manta.Parser) *messageDedup {
+	f := combatLog(parser)
+	if f.dedup == nil {
+		f.dedup = newMessageDedup(parser)
+	}
+	return f.dedup
+}
+
+func (f *combatLogFeed) dispatch(m *dota.CMsgDOTACombatLogEntry) error {
+	if f.dedup != nil && f.dedup.duplicate(f.parser.Tick, "CMsgDOTACombatLogEntry", m) {
+		return nil
+	}
+	for _, fn := range f.handlers {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configEnableKey lists bool flags to switch on in one value, e.g.
+// `enable = ["cs", "aghs"]`.
+const configEnableKey = "enable"
+
+// unquotedIndex is the byte index of the first rune outside quotes for
+// which match holds, or -1. Inside double quotes a backslash escapes the
+// next rune.
+func unquotedIndex(s string, match func(i int, r rune) bool) int {
+	quote, escaped := rune(0), false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case match(i, r):
+			return i
+		}
+	}
+	return -1
+}
+
+// stripConfigComment drops a trailing # comment that is not inside quotes.
+func stripConfigComment(line string) string {
+	if i := unquotedIndex(line, func(_ int, r rune) bool { return r == '#' }); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// configKeySep finds the = or : ending a key. In a YAML list item only a
+// colon followed by a space or the end of the line does, so `- http://x`
+// stays a scalar.
+func configKeySep(line string, item bool) int {
+	return unquotedIndex(line, func(i int, r rune) bool {
+		if r == ':' && item {
+			return i+1 == len(line) || line[i+1] == ' '
+		}
+		return r == '=' || r == ':'
+	})
+}
+
+// configScalar unquotes a scalar: "..." with backslash escapes, '...'
+// literally (in YAML a doubled quote stands for one), or a bare word as is.
+func configScalar(v string) (string, error) {
+	v = strings.TrimSpace(v)
+	switch {
+	case len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"':
+		s, err := strconv.Unquote(v)
+		if err != nil {
+			return "", fmt.Errorf("bad quoted string %s", v)
+		}
+		return s, nil
+	case len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'':
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'"), nil
+	}
+	return v, nil
+}
+
+// parseConfigValue reads a scalar, or a [a, "b, c"] list split on the
+// commas outside quotes.
+func parseConfigValue(v string) (values []string, list bool, err error) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "[") || !strings.HasSuffix(v, "]") {
+		s, err := configScalar(v)
+		return []string{s}, false, err
+	}
+	rest := v[1 : len(v)-1]
+	for rest != "" {
+		item := rest
+		if i := unquotedIndex(rest, func(_ int, r rune) bool { return r == ',' }); i >= 0 {
+			item, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = ""
+		}
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		s, err := configScalar(item)
+		if err != nil {
+			return nil, true, err
+		}
+		values = append(values, s)
+	}
+	return values, true, nil
+}
+
+type configEntry struct {
+	line   int
+	key    string
+	values []string
+	// list is set for [a, b] values and YAML block sequences, and header
+	// for a `key:` line, which is a section unless list items follow.
+	list   bool
+	header bool
+}
+
+// readConfig reads the subset of YAML and TOML the decoder needs: `key =
+// value` or `key: value` pairs, optionally grouped under `[section]` or
+// `section:` headers (inputs, filters, extractors, sinks). Values are bare
+// or quoted scalars, or lists written inline, as TOML arrays spanning lines,
+// or as YAML `- item` lines under `key:`. Sections only organize the file;
+// every key names a decoder flag.
+func readConfig(path string) ([]configEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []configEntry
+	// block is the entry of the last `key:` line, which YAML list items
+	// extend; open is a TOML array still waiting for its closing bracket.
+	block := -1
+	var open *configEntry
+	var openValue string
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripConfigComment(sc.Text()))
+		if open != nil {
+			openValue += " " + line
+			if !strings.HasSuffix(line, "]") {
+				continue
+			}
+			values, _, err := parseConfigValue(openValue)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, open.line, err)
+			}
+			open.values = values
+			entries = append(entries, *open)
+			open = nil
+			continue
+		}
+		if line == "" || line == "---" {
+			continue
+		}
+		item := line == "-" || strings.HasPrefix(line, "- ")
+		if item {
+			line = strings.TrimSpace(line[1:])
+			if configKeySep(line, true) < 0 {
+				if block < 0 {
+					return nil, fmt.Errorf("%s:%d: list item without a key", path, n)
+				}
+				v, err := configScalar(line)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+				}
+				entries[block].values = append(entries[block].values, v)
+				continue
+			}
+		}
+		block = -1
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") && !strings.Contains(line, "=") {
+			continue
+		}
+		sep := configKeySep(line, item)
+		if sep < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key := strings.TrimSpace(line[:sep])
+		value := strings.TrimSpace(line[sep+1:])
+		if key == "" {
+			return nil, fmt.Errorf("%s:%d: missing key", path, n)
+		}
+		if line[sep] == ':' && value == "" {
+			// A YAML section header, or a key whose list follows.
+			entries = append(entries, configEntry{line: n, key: key, list: true, header: true})
+			block = len(entries) - 1
+			continue
+		}
+		if strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") {
+			open, openValue = &configEntry{line: n, key: key, list: true}, value
+			continue
+		}
+		values, list, err := parseConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		entries = append(entries, configEntry{line: n, key: key, values: values, list: list})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if open != nil {
+		return nil, fmt.Errorf("%s:%d: list is never closed with ]", path, open.line)
+	}
+	// Headers no item followed were sections.
+	kept := entries[:0]
+	for _, e := range entries {
+		if !e.header || e.values != nil {
+			kept = append(kept, e)
+		}
+	}
+	return kept, nil
+}
+
+// applyConfig sets every flag named in the config file that was not given
+// on the command line, so explicit flags always win. A list sets a
+// repeatable flag such as -plugin once per element.
+func applyConfig(fs *flag.FlagSet, path string) error {
+	entries, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	set := func(e configEntry, name, value string) error {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s:%d: unknown flag %q", path, e.line, name)
+		}
+		if explicit[name] {
+			return nil
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, e.line, name, err)
+		}
+		return nil
+	}
+	for _, e := range entries {
+		if e.key == configEnableKey {
+			for _, name := range e.values {
+				if err := set(e, name, "true"); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// Repeatable flags take a list, set once per element.
+		f := fs.Lookup(e.key)
+		if f == nil {
+			return fmt.Errorf("%s:%d: unknown flag %q", path, e.line, e.key)
+		}
+		if _, repeatable := f.Value.(*stringList); !repeatable && len(e.values) != 1 {
+			return fmt.Errorf("%s:%d: %s takes a single value", path, e.line, e.key)
+		}
+		for _, v := range e.values {
+			if err := set(e, e.key, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
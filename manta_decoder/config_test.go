@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []configEntry
+		err     string
+	}{
+		{
+			name: "toml",
+			content: `# decoder settings
+[inputs]
+format = "jsonl"
+[extractors]
+enable = ["cs", 'aghs'] # trailing comment
+positions = 30
+`,
+			want: []configEntry{
+				{line: 3, key: "format", values: []string{"jsonl"}},
+				{line: 5, key: "enable", values: []string{"cs", "aghs"}},
+				{line: 6, key: "positions", values: []string{"30"}},
+			},
+		},
+		{
+			name: "yaml",
+			content: `---
+sinks:
+  - ingest: "http://collector:8080/#x"
+  out: "a#b.jsonl"
+enable: []
+`,
+			want: []configEntry{
+				{line: 3, key: "ingest", values: []string{"http://collector:8080/#x"}},
+				{line: 4, key: "out", values: []string{"a#b.jsonl"}},
+				{line: 5, key: "enable"},
+			},
+		},
+		{
+			name: "yaml block lists",
+			content: `sinks:
+  plugin:
+    - python3 big_fights.py
+    - 'jq -c .'  # a comment
+  ingest-header:
+    - "Authorization: Bearer x"
+    - X-Team: faeton
+extractors:
+  enable:
+    - cs
+    - aghs
+`,
+			want: []configEntry{
+				{line: 2, key: "plugin", values: []string{"python3 big_fights.py", "jq -c ."}},
+				{line: 5, key: "ingest-header", values: []string{"Authorization: Bearer x"}},
+				{line: 7, key: "X-Team", values: []string{"faeton"}},
+				{line: 9, key: "enable", values: []string{"cs", "aghs"}},
+			},
+		},
+		{
+			name: "quoted scalars",
+			content: `plugin = ["sort -t, -k1", 'say "hi, there"', "a\"b", 'it''s']
+out = "C:/out, final.jsonl"
+ingest: 'http://collector:8080/?a=1, b'
+`,
+			want: []configEntry{
+				{line: 1, key: "plugin", values: []string{"sort -t, -k1", `say "hi, there"`, `a"b`, "it's"}},
+				{line: 2, key: "out", values: []string{"C:/out, final.jsonl"}},
+				{line: 3, key: "ingest", values: []string{"http://collector:8080/?a=1, b"}},
+			},
+		},
+		{
+			name: "toml multi-line array",
+			content: `[sinks]
+plugin = [
+  "python3 a.py",  # first
+  "python3 b.py",
+]
+out = "x.jsonl"
+`,
+			want: []configEntry{
+				{line: 2, key: "plugin", values: []string{"python3 a.py", "python3 b.py"}},
+				{line: 6, key: "out", values: []string{"x.jsonl"}},
+			},
+		},
+		{name: "item without key", content: "- cs\n", err: ":1: list item without a key"},
+		{name: "item after scalar", content: "positions: 30\n  - 60\n", err: ":2: list item without a key"},
+		{name: "unclosed list", content: "\nplugin = [\n  \"a\",\n", err: ":2: list is never closed"},
+		{name: "bad escape", content: "out = \"a\\q\"\n", err: ":1: bad quoted string"},
+		{name: "no separator", content: "cs\n", err: ":1: expected key = value"},
+		{name: "missing key", content: "\n= true\n", err: ":2: missing key"},
+	}
+	for _, tt := range tests {
+		got, err := readConfig(writeConfig(t, tt.content))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.EqualFunc(got, tt.want, func(a, b configEntry) bool {
+			return a.line == b.line && a.key == b.key && slices.Equal(a.values, b.values)
+		}) {
+			t.Errorf("%s: entries = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		content string
+		want    map[string]string
+		err     string
+	}{
+		{
+			name:    "fills unset flags",
+			content: "enable = [cs, aghs]\npositions = 30\n",
+			want:    map[string]string{"cs": "true", "aghs": "true", "positions": "30", "out": ""},
+		},
+		{
+			name:    "command line wins",
+			args:    []string{"-positions=5", "-cs=false"},
+			content: "enable = [cs]\npositions = 30\nout = x.jsonl\n",
+			want:    map[string]string{"cs": "false", "positions": "5", "out": "x.jsonl"},
+		},
+		{name: "unknown flag", content: "nope = 1\n", err: `:1: unknown flag "nope"`},
+		{name: "unknown enable", content: "\nenable = [cs, nope]\n", err: `:2: unknown flag "nope"`},
+		{name: "bad value", content: "positions = many\n", err: ":1: positions:"},
+		{name: "list for scalar", content: "out = [a, b]\n", err: ":1: out takes a single value"},
+		{name: "empty list for unknown", content: "nope: []\n", err: `:1: unknown flag "nope"`},
+		{
+			name:    "repeatable lists",
+			content: "plugin:\n  - python3 a.py\n  - 'jq -c .'\nwasm = [\"x.wasm\", \"y, z.wasm\"]\n",
+			want:    map[string]string{"plugin": "python3 a.py, jq -c .", "wasm": "x.wasm, y, z.wasm"},
+		},
+		{
+			name:    "repeatable from command line",
+			args:    []string{"-plugin=cat"},
+			content: "plugin = [a, b]\n",
+			want:    map[string]string{"plugin": "cat"},
+		},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Bool("cs", false, "")
+		fs.Bool("aghs", false, "")
+		fs.Int("positions", 0, "")
+		fs.String("out", "", "")
+		var plugins, wasm stringList
+		fs.Var(&plugins, "plugin", "")
+		fs.Var(&wasm, "wasm", "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		err := applyConfig(fs, writeConfig(t, tt.content))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for name, want := range tt.want {
+			if got := fs.Lookup(name).Value.String(); got != want {
+				t.Errorf("%s: -%s = %q, want %q", tt.name, name, got, want)
+			}
+		}
+	}
+	if err := applyConfig(flag.NewFlagSet("test", flag.ContinueOnError), filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing config file was accepted")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// stateContext snapshots the game around a matched record: heroes near the
+// record's subject with their vitals, ability cooldowns and net worth.
+type stateContext struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	radius float64
+}
+
+func newStateContext(parser *manta.Parser, radius float64) *stateContext {
+	return &stateContext{parser: parser, rules: newGameRulesRef(parser), radius: radius}
+}
+
+// subject names the hero a record is about: the attacker of a combat log
+// entry, or its "hero" field.
+func (c *stateContext) subject(rec map[string]any) string {
+	if m, ok := rec["payload"].(*dota.CMsgDOTACombatLogEntry); ok {
+		return lookupCombatLogName(c.parser, m.GetAttackerName())
+	}
+	hero, _ := rec["hero"].(string)
+	return hero
+}
+
+func (c *stateContext) netWorths() map[string]int32 {
+	worth := map[string]int32{}
+	for _, p := range listPlayers(c.parser) {
+		if p.HeroName == "" {
+			continue
+		}
+		if d := teamDataEntity(c.parser, p.Team); d != nil {
+			worth[p.HeroName], _ = d.GetInt32(teamDataField(p.TeamSlot, "m_iNetWorth"))
+		}
+	}
+	return worth
+}
+
+func (c *stateContext) cooldowns(hero *manta.Entity, now float32) map[string]float32 {
+	cds := map[string]float32{}
+	for _, a := range heroAbilities(c.parser, hero) {
+		name := entityUnitName(c.parser, a)
+		if name == "" || strings.HasPrefix(name, "special_bonus_") {
+			continue
+		}
+		if level, _ := a.GetInt32("m_iLevel"); level == 0 {
+			continue
+		}
+		end, _ := a.GetFloat32("m_fCooldown")
+		remaining := end - now
+		if remaining < 0 {
+			remaining = 0
+		}
+		cds[name] = remaining
+	}
+	return cds
+}
+
+func (c *stateContext) snapshot(rec map[string]any) map[string]any {
+	name := c.subject(rec)
+	center := heroEntityByName(c.parser, name)
+	if center == nil {
+		return nil
+	}
+	pos, ok := entityWorldPosition(center)
+	if !ok {
+		return nil
+	}
+	now := c.rules.gameTime()
+	worth := c.netWorths()
+	near := heroesWithin(c.parser, pos, c.radius)
+	sort.Slice(near, func(i, j int) bool { return near[i].GetIndex() < near[j].GetIndex() })
+	heroes := make([]map[string]any, 0, len(near))
+	for _, h := range near {
+		hero := entityUnitName(c.parser, h)
+		hp, _ := h.GetInt32("m_iHealth")
+		maxHP, _ := h.GetInt32("m_iMaxHealth")
+		mana, _ := h.GetFloat32("m_flMana")
+		maxMana, _ := h.GetFloat32("m_flMaxMana")
+		level, _ := h.GetInt32("m_iCurrentLevel")
+		entry := map[string]any{
+			"hero":       hero,
+			"team":       entityTeam(h),
+			"alive":      isAlive(h),
+			"level":      level,
+			"health":     hp,
+			"max_health": maxHP,
+			"mana":       mana,
+			"max_mana":   maxMana,
+			"net_worth":  worth[hero],
+			"cooldowns":  c.cooldowns(h, now),
+		}
+		if hpos, ok := entityWorldPosition(h); ok {
+			entry["world"] = hpos
+			entry["minimap"] = hpos.Minimap()
+		}
+		heroes = append(heroes, entry)
+	}
+	return map[string]any{
+		"subject": name,
+		"radius":  c.radius,
+		"time":    c.rules.clock(now),
+		"heroes":  heroes,
+	}
+}
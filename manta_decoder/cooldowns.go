@@ -0,0 +1,300 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+const (
+	abilityReady    = "ready"
+	abilityCooldown = "cooldown"
+)
+
+// hiddenAbilityPrefixes are talents and utility abilities every hero has,
+// which are not worth tracking.
+var hiddenAbilityPrefixes = []string{"special_bonus_", "plus_", "ability_", "generic_hidden"}
+
+func isTrackedAbility(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, p := range hiddenAbilityPrefixes {
+		if strings.HasPrefix(name, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// abilityTrack is the cooldown state of one ability or item entity. Times are
+// raw game time, which is what m_fCooldown holds.
+type abilityTrack struct {
+	hero  string
+	name  string
+	item  bool
+	state string
+	since float32
+	// end is when the running cooldown finishes.
+	end float32
+}
+
+type abilityUsage struct {
+	hero        string
+	name        string
+	item        bool
+	casts       int
+	cooldown    float32
+	ready       float32
+	longestIdle float32
+}
+
+// cooldownTracker follows m_fCooldown on hero ability and item entities and
+// splits each one's life into ready and cooldown intervals.
+type cooldownTracker struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	tracks map[int32]*abilityTrack
+	usage  map[string]*abilityUsage
+	order  []string
+	seeded bool
+}
+
+func isAbilityOrItem(e *manta.Entity) bool {
+	class := e.GetClassName()
+	return strings.HasPrefix(class, "CDOTA_Ability_") || strings.HasPrefix(class, "CDOTA_Item_")
+}
+
+// owningHero returns the real hero that owns an ability or item entity.
+func owningHero(parser *manta.Parser, e *manta.Entity) *manta.Entity {
+	h, ok := e.GetUint32("m_hOwnerEntity")
+	if !ok || h == invalidHandle {
+		return nil
+	}
+	owner := parser.FindEntityByHandle(uint64(h))
+	if owner == nil || !isHeroEntity(owner) || isIllusionEntity(owner) {
+		return nil
+	}
+	return owner
+}
+
+func (t *cooldownTracker) stats(tr *abilityTrack) *abilityUsage {
+	key := tr.hero + "/" + tr.name
+	u := t.usage[key]
+	if u == nil {
+		u = &abilityUsage{hero: tr.hero, name: tr.name, item: tr.item}
+		t.usage[key] = u
+		t.order = append(t.order, key)
+	}
+	return u
+}
+
+// close ends tr's current interval at end and writes it out.
+func (t *cooldownTracker) close(tr *abilityTrack, end float32) error {
+	if end < tr.since {
+		end = tr.since
+	}
+	duration := end - tr.since
+	u := t.stats(tr)
+	if tr.state == abilityReady {
+		u.ready += duration
+		if duration > u.longestIdle {
+			u.longestIdle = duration
+		}
+	} else {
+		u.cooldown += duration
+	}
+	rec := map[string]any{
+		"kind":     "ability_state",
+		"tick":     t.parser.Tick,
+		"hero":     tr.hero,
+		"ability":  tr.name,
+		"item":     tr.item,
+		"state":    tr.state,
+		"start":    t.rules.clock(tr.since),
+		"end":      t.rules.clock(end),
+		"duration": duration,
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+// track starts following e as ready from since, if it is a learned ability
+// or an item of a real hero.
+func (t *cooldownTracker) track(e *manta.Entity, since float32) *abilityTrack {
+	if tr := t.tracks[e.GetIndex()]; tr != nil {
+		return tr
+	}
+	item := strings.HasPrefix(e.GetClassName(), "CDOTA_Item_")
+	if level, _ := e.GetInt32("m_iLevel"); level == 0 && !item {
+		return nil
+	}
+	name := entityUnitName(t.parser, e)
+	if !isTrackedAbility(name) {
+		return nil
+	}
+	owner := owningHero(t.parser, e)
+	if owner == nil {
+		return nil
+	}
+	tr := &abilityTrack{
+		hero:  entityUnitName(t.parser, owner),
+		name:  name,
+		item:  item,
+		state: abilityReady,
+		since: since,
+	}
+	t.tracks[e.GetIndex()] = tr
+	return tr
+}
+
+func (t *cooldownTracker) onEntity(e *manta.Entity, op manta.EntityOp) error {
+	if !isAbilityOrItem(e) {
+		return nil
+	}
+	// Only the game proper counts; pre-horn idle time would read as waste.
+	rules := t.rules.get()
+	if rules == nil {
+		return nil
+	}
+	start, _ := rules.GetFloat32("m_pGameRules.m_flGameStartTime")
+	now := t.rules.gameTime()
+	if start <= 0 || now < start {
+		return nil
+	}
+	if !t.seeded {
+		// Abilities learned before the horn may not update again until
+		// cast, so start their ready intervals at the horn.
+		t.seeded = true
+		for _, hero := range realHeroes(t.parser) {
+			for _, a := range heroAbilities(t.parser, hero) {
+				t.track(a, start)
+			}
+		}
+	}
+	tr := t.tracks[e.GetIndex()]
+	if op.Flag(manta.EntityOpDeleted) {
+		if tr != nil {
+			delete(t.tracks, e.GetIndex())
+			return t.close(tr, now)
+		}
+		return nil
+	}
+	if tr == nil {
+		if tr = t.track(e, now); tr == nil {
+			return nil
+		}
+	}
+
+	end, _ := e.GetFloat32("m_fCooldown")
+	switch tr.state {
+	case abilityReady:
+		if end <= now {
+			return nil
+		}
+		if err := t.close(tr, now); err != nil {
+			return err
+		}
+		t.stats(tr).casts++
+		tr.state, tr.since, tr.end = abilityCooldown, now, end
+	case abilityCooldown:
+		switch {
+		case end > tr.end && tr.end <= now:
+			// Cast again on a later update than the one that saw the old
+			// cooldown expire.
+			if err := t.close(tr, tr.end); err != nil {
+				return err
+			}
+			tr.state, tr.since = abilityReady, tr.end
+			if err := t.close(tr, now); err != nil {
+				return err
+			}
+			t.stats(tr).casts++
+			tr.state, tr.since, tr.end = abilityCooldown, now, end
+		case end > now:
+			// Cooldown changed mid-way, e.g. a reduction or a refresh and cast.
+			tr.end = end
+		default:
+			// Finished, or reset early by a refresher.
+			finished := tr.end
+			if finished > now {
+				finished = now
+			}
+			if err := t.close(tr, finished); err != nil {
+				return err
+			}
+			tr.state, tr.since = abilityReady, finished
+		}
+	}
+	return nil
+}
+
+// finish closes every open interval and writes an ability_usage record per
+// hero and ability. Items that were never used are left out.
+func (t *cooldownTracker) finish() error {
+	now := t.rules.gameTime()
+	indexes := make([]int32, 0, len(t.tracks))
+	for idx := range t.tracks {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for _, idx := range indexes {
+		tr := t.tracks[idx]
+		if tr.state == abilityCooldown && tr.end < now {
+			if err := t.close(tr, tr.end); err != nil {
+				return err
+			}
+			tr.state, tr.since = abilityReady, tr.end
+		}
+		if err := t.close(tr, now); err != nil {
+			return err
+		}
+	}
+	for _, key := range t.order {
+		u := t.usage[key]
+		if u.item && u.casts == 0 {
+			continue
+		}
+		rec := map[string]any{
+			"kind":          "ability_usage",
+			"tick":          t.parser.Tick,
+			"hero":          u.hero,
+			"ability":       u.name,
+			"item":          u.item,
+			"casts":         u.casts,
+			"cooldown_time": u.cooldown,
+			"ready_time":    u.ready,
+			"longest_ready": u.longestIdle,
+		}
+		if total := u.cooldown + u.ready; total > 0 {
+			rec["ready_share"] = u.ready / total
+		}
+		(*t.wrote)++
+		if err := t.out.add(t.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerCooldowns(h *extractorHooks, out *outputState, wrote *int) func() error {
+	parser := h.parser()
+	t := &cooldownTracker{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		tracks: map[int32]*abilityTrack{},
+		usage:  map[string]*abilityUsage{},
+	}
+	h.onEntity(t.onEntity)
+	h.onPause(func() {
+		t.tracks = map[int32]*abilityTrack{}
+		t.usage = map[string]*abilityUsage{}
+		t.order = nil
+	})
+	return t.finish
+}
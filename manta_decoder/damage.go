@@ -0,0 +1,165 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// damageAutoAttack is the breakdown key used for damage entries without an
+// inflictor, i.e. right-click attacks.
+const damageAutoAttack = "attack"
+
+type damageTotals struct {
+	Total     uint64            `json:"total"`
+	ByAbility map[string]uint64 `json:"by_ability"`
+	ByItem    map[string]uint64 `json:"by_item"`
+	ByHero    map[string]uint64 `json:"by_hero"`
+	HeroTotal uint64            `json:"hero_total"`
+	// Damage dealt by the hero's illusions and summons, which Total leaves
+	// out; only set on dealt totals.
+	IllusionTotal uint64 `json:"illusion_total,omitempty"`
+	SummonTotal   uint64 `json:"summon_total,omitempty"`
+}
+
+func newDamageTotals() *damageTotals {
+	return &damageTotals{
+		ByAbility: map[string]uint64{},
+		ByItem:    map[string]uint64{},
+		ByHero:    map[string]uint64{},
+	}
+}
+
+func (d *damageTotals) add(inflictor, opponent string, opponentIsHero bool, value uint64) {
+	d.Total += value
+	switch {
+	case inflictor == "":
+		d.ByAbility[damageAutoAttack] += value
+	case strings.HasPrefix(inflictor, "item_"):
+		d.ByItem[inflictor] += value
+	default:
+		d.ByAbility[inflictor] += value
+	}
+	if opponentIsHero {
+		d.HeroTotal += value
+		d.ByHero[opponent] += value
+	}
+}
+
+type damageStats struct {
+	parser   *manta.Parser
+	dealt    map[string]*damageTotals
+	received map[string]*damageTotals
+}
+
+func newDamageStats(parser *manta.Parser) *damageStats {
+	return &damageStats{
+		parser:   parser,
+		dealt:    map[string]*damageTotals{},
+		received: map[string]*damageTotals{},
+	}
+}
+
+func (s *damageStats) totals(m map[string]*damageTotals, hero string) *damageTotals {
+	t, ok := m[hero]
+	if !ok {
+		t = newDamageTotals()
+		m[hero] = t
+	}
+	return t
+}
+
+// summonOwner returns the hero owning a non-hero attacker, such as a summon,
+// Lone Druid's bear or a dominated creep, or "" when there is none. The
+// combat log gives the owning hero as the entry's damage source.
+func summonOwner(parser *manta.Parser, m *dota.CMsgDOTACombatLogEntry, attacker string) string {
+	if owner := lookupCombatLogName(parser, m.GetDamageSourceName()); owner != attacker && strings.HasPrefix(owner, "npc_dota_hero_") {
+		return owner
+	}
+	return ""
+}
+
+// creditedHero returns the hero an entry's attacker counts for: the
+// attacker itself when it is a hero, or else the hero that owns it.
+func creditedHero(parser *manta.Parser, m *dota.CMsgDOTACombatLogEntry) string {
+	attacker := lookupCombatLogName(parser, m.GetAttackerName())
+	if m.GetIsAttackerHero() {
+		return attacker
+	}
+	return summonOwner(parser, m, attacker)
+}
+
+func (s *damageStats) onCombatLog(m *dota.CMsgDOTACombatLogEntry) {
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE {
+		return
+	}
+	attacker := lookupCombatLogName(s.parser, m.GetAttackerName())
+	target := lookupCombatLogName(s.parser, m.GetTargetName())
+	inflictor := lookupCombatLogName(s.parser, m.GetInflictorName())
+	value := uint64(m.GetValue())
+	attackerHero := m.GetIsAttackerHero() && !m.GetIsAttackerIllusion()
+	targetHero := m.GetIsTargetHero() && !m.GetIsTargetIllusion()
+	switch {
+	case attackerHero:
+		s.totals(s.dealt, attacker).add(inflictor, target, targetHero, value)
+	case m.GetIsAttackerIllusion():
+		s.totals(s.dealt, attacker).IllusionTotal += value
+	default:
+		if owner := summonOwner(s.parser, m, attacker); owner != "" {
+			s.totals(s.dealt, owner).SummonTotal += value
+		}
+	}
+	if targetHero {
+		s.totals(s.received, target).add(inflictor, attacker, attackerHero, value)
+	}
+}
+
+func (s *damageStats) emit(out *outputState, final bool, wrote *int) error {
+	heroes := map[string]bool{}
+	for h := range s.dealt {
+		heroes[h] = true
+	}
+	for h := range s.received {
+		heroes[h] = true
+	}
+	names := make([]string, 0, len(heroes))
+	for h := range heroes {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+
+	for _, hero := range names {
+		record := map[string]any{
+			"kind":     "damage",
+			"tick":     s.parser.Tick,
+			"hero":     hero,
+			"final":    final,
+			"dealt":    s.totals(s.dealt, hero),
+			"received": s.totals(s.received, hero),
+		}
+		(*wrote)++
+		if err := out.add(s.parser.Tick, record, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerDamage(parser *manta.Parser, out *outputState, interval uint32, wrote *int) func() error {
+	stats := newDamageStats(parser)
+	sampler := newTickSampler(interval)
+	subscribeCombatLog(parser, func(m *dota.CMsgDOTACombatLogEntry) error {
+		if sampler.due(parser.Tick) {
+			if err := stats.emit(out, false, wrote); err != nil {
+				return err
+			}
+		}
+		stats.onCombatLog(m)
+		return nil
+	})
+	return func() error {
+		return stats.emit(out, true, wrote)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+// datasetHeroSlots is the fixed hero axis of the per-hero tensor. Each
+// player's hero sits at their scoreboard slot, Radiant 0-4 then Dire 5-9;
+// empty slots are zero-filled.
+const datasetHeroSlots = 10
+
+var datasetHeroFeatures = []string{
+	"present",
+	"team",
+	"player_id",
+	"alive",
+	"minimap_x",
+	"minimap_y",
+	"level",
+	"health_frac",
+	"mana_frac",
+}
+
+func heroFeatureRow(e *manta.Entity) []float32 {
+	row := make([]float32, len(datasetHeroFeatures))
+	row[0] = 1
+	row[1] = float32(entityTeam(e))
+	id, _ := e.GetInt32("m_iPlayerID")
+	row[2] = float32(id)
+	if isAlive(e) {
+		row[3] = 1
+	}
+	if pos, ok := entityWorldPosition(e); ok {
+		mm := pos.Minimap()
+		row[4] = float32(mm.X)
+		row[5] = float32(mm.Y)
+	}
+	level, _ := e.GetInt32("m_iCurrentLevel")
+	row[6] = float32(level)
+	health, _ := e.GetInt32("m_iHealth")
+	maxHealth, _ := e.GetInt32("m_iMaxHealth")
+	if maxHealth > 0 {
+		row[7] = float32(health) / float32(maxHealth)
+	}
+	mana, _ := e.GetFloat32("m_flMana")
+	maxMana, _ := e.GetFloat32("m_flMaxMana")
+	if maxMana > 0 {
+		row[8] = mana / maxMana
+	}
+	return row
+}
+
+type datasetSample struct {
+	tick   uint32
+	heroes []float32
+	global []float64
+}
+
+func extractDataset(demPath string, interval uint32) ([]datasetSample, int32, error) {
+	in, err := os.Open(demPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer in.Close()
+
+	parser, err := manta.NewStreamParser(in)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer releaseCombatLog(parser)
+	w := newWinProbExtractor(parser)
+	sampler := newTickSampler(interval)
+	var samples []datasetSample
+	parser.OnEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		width := len(datasetHeroFeatures)
+		rows := make([]float32, datasetHeroSlots*width)
+		for _, p := range listPlayers(parser) {
+			slot := int(p.Slot())
+			if p.Hero == nil || slot < 0 || slot >= datasetHeroSlots {
+				continue
+			}
+			copy(rows[slot*width:], heroFeatureRow(p.Hero))
+		}
+		samples = append(samples, datasetSample{tick: parser.Tick, heroes: rows, global: w.features()})
+		return nil
+	})
+	if err := parser.Start(); err != nil {
+		return nil, 0, err
+	}
+	return samples, w.winner(), nil
+}
+
+func writeDatasetShard(path string, samples []datasetSample, winner int32) error {
+	n := len(samples)
+	ticks := make([]int32, 0, n)
+	heroes := make([]float32, 0, n*datasetHeroSlots*len(datasetHeroFeatures))
+	global := make([]float32, 0, n*len(winProbFeatures))
+	for _, s := range samples {
+		ticks = append(ticks, int32(s.tick))
+		heroes = append(heroes, s.heroes...)
+		for _, v := range s.global {
+			global = append(global, float32(v))
+		}
+	}
+	radiantWin := int32(0)
+	if winner == teamRadiant {
+		radiantWin = 1
+	}
+	return writeNPZ(path, []npyArray{
+		{name: "ticks", shape: []int{n}, data: ticks},
+		{name: "heroes", shape: []int{n, datasetHeroSlots, len(datasetHeroFeatures)}, data: heroes},
+		{name: "global", shape: []int{n, len(winProbFeatures)}, data: global},
+		{name: "radiant_win", shape: []int{1}, data: []int32{radiantWin}},
+		{name: "winner", shape: []int{1}, data: []int32{winner}},
+	})
+}
+
+func writeDatasetSchema(dir string, interval uint32) error {
+	f, err := os.Create(filepath.Join(dir, "schema.json"))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]any{
+		"interval_ticks":        interval,
+		"hero_slots":            datasetHeroSlots,
+		"hero_features":         datasetHeroFeatures,
+		"global_features":       winProbFeatures,
+		"global_schema_version": winProbSchemaVersion,
+		"arrays":                []string{"ticks", "heroes", "global", "radiant_win", "winner"},
+	}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runDataset implements `dataset [flags] replay.dem...`, writing one .npz
+// shard per replay plus a shared schema.json into -out-dir.
+func runDataset(args []string) {
+	fs := flag.NewFlagSet("dataset", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	outDir := fs.String("out-dir", "dataset", "directory for .npz shards and schema.json")
+	interval := fs.Uint("interval", 30, "sample interval in ticks")
+	format := fs.String("format", "npz", "shard format (only npz is supported)")
+	replays := global.parseArgs(fs, args, nil)
+
+	if *format != "npz" {
+		fatal(exitUsage, "unsupported format (only npz is supported)", "format", *format)
+	}
+	if len(replays) == 0 {
+		fatal(exitUsage, "at least one replay is required")
+	}
+	if *interval == 0 {
+		fatal(exitUsage, "-interval must be positive")
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fatal(exitIO, "create output dir", "err", err)
+	}
+	if err := writeDatasetSchema(*outDir, uint32(*interval)); err != nil {
+		fatal(exitIO, "write schema", "err", err)
+	}
+
+	for _, dem := range replays {
+		logger.Debug("extracting", "replay", dem)
+		samples, winner, err := extractDataset(dem, uint32(*interval))
+		if err != nil {
+			fatal(exitInvalidReplay, "extract replay", "replay", dem, "err", err)
+		}
+		name := strings.TrimSuffix(filepath.Base(dem), filepath.Ext(dem)) + ".npz"
+		if err := writeDatasetShard(filepath.Join(*outDir, name), samples, winner); err != nil {
+			fatal(exitIO, "write shard", "replay", dem, "err", err)
+		}
+		logger.Info("wrote shard", "replay", dem, "samples", len(samples))
+	}
+}
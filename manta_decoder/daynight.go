@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/dotabuff/manta"
+)
+
+type dayNightChange struct {
+	clock  float32
+	night  bool
+	reason string
+}
+
+// dayNightTracker follows the day/night state on the game rules entity,
+// writing a day_night record at every change and annotating interval records
+// with the state they started in.
+type dayNightTracker struct {
+	parser  *manta.Parser
+	rules   *gameRulesRef
+	out     *outputState
+	wrote   *int
+	changes []dayNightChange
+}
+
+func (t *dayNightTracker) onEntity(e *manta.Entity, _ manta.EntityOp) error {
+	if e.GetClassName() != "CDOTAGamerulesProxy" {
+		return nil
+	}
+	night, reason := nightState(e)
+	if reason == "" {
+		return nil
+	}
+	if n := len(t.changes); n > 0 && t.changes[n-1].night == night && t.changes[n-1].reason == reason {
+		return nil
+	}
+	c := dayNightChange{clock: t.rules.now(), night: night, reason: reason}
+	t.changes = append(t.changes, c)
+	rec := map[string]any{
+		"kind":   "day_night",
+		"tick":   t.parser.Tick,
+		"time":   c.clock,
+		"night":  night,
+		"reason": reason,
+	}
+	if tod, ok := e.GetInt32("m_pGameRules.m_iNetTimeOfDay"); ok {
+		rec["time_of_day"] = float32(tod) / timeOfDayCycle
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+// stateAt returns the last change at or before clock.
+func (t *dayNightTracker) stateAt(clock float32) (dayNightChange, bool) {
+	for i := len(t.changes) - 1; i >= 0; i-- {
+		if t.changes[i].clock <= clock {
+			return t.changes[i], true
+		}
+	}
+	return dayNightChange{}, false
+}
+
+// nightShare returns the fraction of [start, end] that was night.
+func (t *dayNightTracker) nightShare(start, end float32) float32 {
+	if end <= start {
+		if c, ok := t.stateAt(start); ok && c.night {
+			return 1
+		}
+		return 0
+	}
+	var night float32
+	for i, c := range t.changes {
+		from, to := c.clock, end
+		if i+1 < len(t.changes) {
+			to = t.changes[i+1].clock
+		}
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		if c.night && to > from {
+			night += to - from
+		}
+	}
+	return night / (end - start)
+}
+
+func clockField(rec map[string]any, key string) (float32, bool) {
+	switch v := rec[key].(type) {
+	case float32:
+		return v, true
+	case float64:
+		return float32(v), true
+	}
+	return 0, false
+}
+
+// annotate adds night, night_reason and night_share to records that span a
+// start/end clock interval, such as fights, dives and ability states.
+func (t *dayNightTracker) annotate(rec map[string]any) {
+	if rec["kind"] == "day_night" {
+		return
+	}
+	start, ok := clockField(rec, "start")
+	if !ok {
+		return
+	}
+	end, ok := clockField(rec, "end")
+	if !ok {
+		return
+	}
+	c, ok := t.stateAt(start)
+	if !ok {
+		return
+	}
+	rec["night"] = c.night
+	rec["night_reason"] = c.reason
+	rec["night_share"] = t.nightShare(start, end)
+}
+
+func registerDayNight(parser *manta.Parser, out *outputState, wrote *int) *dayNightTracker {
+	t := &dayNightTracker{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+	}
+	parser.OnEntity(t.onEntity)
+	return t
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+// deathDamageWindow is how far back (game seconds) a death report sums the
+// damage its victim took.
+const deathDamageWindow = 10
+
+type damageTaken struct {
+	clock          float32
+	attacker       string
+	inflictor      string
+	attackerIsHero bool
+	value          uint64
+}
+
+// deathReporter writes a death_report for every real hero death: the
+// victim's items and cooldowns, the heroes around the death and the damage
+// that led to it.
+type deathReporter struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	state  *stateContext
+	recent map[string][]damageTaken
+}
+
+// prune drops damage older than the window from before clock.
+func (d *deathReporter) prune(hero string, clock float32) []damageTaken {
+	taken := d.recent[hero]
+	i := 0
+	for i < len(taken) && clock-taken[i].clock > deathDamageWindow {
+		i++
+	}
+	taken = taken[i:]
+	d.recent[hero] = taken
+	return taken
+}
+
+func (d *deathReporter) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if !m.GetIsTargetHero() || m.GetIsTargetIllusion() {
+		return nil
+	}
+	clock := d.rules.clock(m.GetTimestamp())
+	target := lookupCombatLogName(d.parser, m.GetTargetName())
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE:
+		d.recent[target] = append(d.prune(target, clock), damageTaken{
+			clock:          clock,
+			attacker:       lookupCombatLogName(d.parser, m.GetAttackerName()),
+			inflictor:      lookupCombatLogName(d.parser, m.GetInflictorName()),
+			attackerIsHero: m.GetIsAttackerHero() && !m.GetIsAttackerIllusion(),
+			value:          uint64(m.GetValue()),
+		})
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		if err := d.report(m, target, clock); err != nil {
+			return err
+		}
+		delete(d.recent, target)
+	}
+	return nil
+}
+
+// nearby lists the other heroes within the context radius of the death,
+// split into the victim's allies and enemies.
+func (d *deathReporter) nearby(victim *manta.Entity, where mapcoord.World) (allies, enemies []map[string]any) {
+	allies, enemies = []map[string]any{}, []map[string]any{}
+	team := entityTeam(victim)
+	near := heroesWithin(d.parser, where, d.state.radius)
+	sort.Slice(near, func(i, j int) bool { return near[i].GetIndex() < near[j].GetIndex() })
+	for _, h := range near {
+		if h.GetIndex() == victim.GetIndex() {
+			continue
+		}
+		hp, _ := h.GetInt32("m_iHealth")
+		maxHP, _ := h.GetInt32("m_iMaxHealth")
+		entry := map[string]any{
+			"hero":       entityUnitName(d.parser, h),
+			"alive":      isAlive(h),
+			"health":     hp,
+			"max_health": maxHP,
+		}
+		if pos, ok := entityWorldPosition(h); ok {
+			entry["distance"] = mapcoord.Distance2D(pos, where)
+		}
+		if entityTeam(h) == team {
+			allies = append(allies, entry)
+		} else {
+			enemies = append(enemies, entry)
+		}
+	}
+	return allies, enemies
+}
+
+func (d *deathReporter) report(m *dota.CMsgDOTACombatLogEntry, victim string, clock float32) error {
+	where := mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())}
+	damage := newDamageTotals()
+	for _, t := range d.prune(victim, clock) {
+		damage.add(t.inflictor, t.attacker, t.attackerIsHero, t.value)
+	}
+	rec := map[string]any{
+		"kind":             "death_report",
+		"tick":             d.parser.Tick,
+		"time":             clock,
+		"hero":             victim,
+		"killer":           lookupCombatLogName(d.parser, m.GetAttackerName()),
+		"will_reincarnate": m.GetWillReincarnate(),
+		"world":            where,
+		"minimap":          where.Minimap(),
+		"damage_window":    deathDamageWindow,
+		"damage_taken":     damage,
+	}
+	if e := heroEntityByName(d.parser, victim); e != nil {
+		level, _ := e.GetInt32("m_iCurrentLevel")
+		rec["level"] = level
+		rec["items"] = heroItems(d.parser, e)
+		rec["cooldowns"] = d.state.cooldowns(e, d.rules.gameTime())
+		rec["net_worth"] = d.state.netWorths()[victim]
+		rec["allies"], rec["enemies"] = d.nearby(e, where)
+	}
+	(*d.wrote)++
+	return d.out.add(d.parser.Tick, rec, false)
+}
+
+func registerDeathReports(h *extractorHooks, out *outputState, radius float64, wrote *int) {
+	parser := h.parser()
+	d := &deathReporter{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		state:  newStateContext(parser, radius),
+		recent: map[string][]damageTaken{},
+	}
+	h.onCombatLog(d.onCombatLog)
+	h.onPause(func() {
+		d.recent = map[string][]damageTaken{}
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+	"google.golang.org/protobuf/proto"
+)
+
+// messageDedup drops messages that a demo full packet re-delivers: the same
+// callback with the same wire encoding at the same tick, but from a later
+// demo command than the first copy. Identical messages inside one packet
+// are genuine (two equal hits in a tick) and are kept.
+type messageDedup struct {
+	tick    uint32
+	current map[uint64]bool
+	earlier map[uint64]bool
+	dropped int
+	// tables is the hash of each string table as of the last
+	// CDemoStringTables dump; a dump repeating them all is a re-delivery.
+	tables map[string]uint64
+}
+
+// newMessageDedup tracks demo command boundaries on parser. manta runs its
+// own handlers first, so these fire once a command's messages are done.
+func newMessageDedup(parser *manta.Parser) *messageDedup {
+	d := &messageDedup{current: map[uint64]bool{}, earlier: map[uint64]bool{}, tables: map[string]uint64{}}
+	parser.Callbacks.OnCDemoPacket(func(*dota.CDemoPacket) error { d.boundary(); return nil })
+	parser.Callbacks.OnCDemoSignonPacket(func(*dota.CDemoPacket) error { d.boundary(); return nil })
+	parser.Callbacks.OnCDemoFullPacket(func(*dota.CDemoFullPacket) error { d.boundary(); return nil })
+	return d
+}
+
+func (d *messageDedup) boundary() {
+	for k := range d.current {
+		d.earlier[k] = true
+	}
+	clear(d.current)
+}
+
+// duplicate reports whether name/payload is a re-delivered copy, and records
+// it otherwise. String table dumps are duplicates when they repeat the last
+// one; other demo commands and non-protobuf payloads never are.
+func (d *messageDedup) duplicate(tick uint32, name string, payload any) bool {
+	if dump, ok := payload.(*dota.CDemoStringTables); ok {
+		return d.repeatedTables(dump)
+	}
+	msg, ok := payload.(proto.Message)
+	if !ok || strings.HasPrefix(name, "CDemo") {
+		return false
+	}
+	if tick != d.tick {
+		d.tick = tick
+		clear(d.current)
+		clear(d.earlier)
+	}
+	key, ok := messageHash(name, msg)
+	if !ok {
+		return false
+	}
+	if d.earlier[key] {
+		d.dropped++
+		return true
+	}
+	d.current[key] = true
+	return false
+}
+
+// repeatedTables records the tables of a CDemoStringTables dump and reports
+// whether every one of them is unchanged since the previous dump.
+func (d *messageDedup) repeatedTables(dump *dota.CDemoStringTables) bool {
+	repeated := len(dump.GetTables()) > 0
+	for _, t := range dump.GetTables() {
+		key, ok := messageHash(t.GetTableName(), t)
+		if !ok {
+			return false
+		}
+		if prev, seen := d.tables[t.GetTableName()]; !seen || prev != key {
+			repeated = false
+		}
+		d.tables[t.GetTableName()] = key
+	}
+	if repeated {
+		d.dropped++
+	}
+	return repeated
+}
+
+// messageHash hashes name and the deterministic wire encoding of msg.
+func messageHash(name string, msg proto.Message) (uint64, bool) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return 0, false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(b)
+	return h.Sum64(), true
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dotabuff/manta/dota"
+	"google.golang.org/protobuf/proto"
+)
+
+func testDedup() *messageDedup {
+	return &messageDedup{current: map[uint64]bool{}, earlier: map[uint64]bool{}, tables: map[string]uint64{}}
+}
+
+func combatEntry(value uint32) *dota.CMsgDOTACombatLogEntry {
+	return &dota.CMsgDOTACombatLogEntry{
+		Type:         dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE.Enum(),
+		AttackerName: proto.Uint32(12),
+		TargetName:   proto.Uint32(34),
+		Value:        proto.Uint32(value),
+		Timestamp:    proto.Float32(612.5),
+	}
+}
+
+func TestMessageDedup(t *testing.T) {
+	const name = "CMsgDOTACombatLogEntry"
+	d := testDedup()
+	steps := []struct {
+		tick     uint32
+		boundary bool
+		value    uint32
+		want     bool
+	}{
+		{tick: 100, value: 50, want: false},
+		{tick: 100, value: 50, want: false}, // equal hit in the same packet
+		{tick: 100, boundary: true, value: 50, want: true},
+		{tick: 100, value: 60, want: false},
+		{tick: 101, boundary: true, value: 50, want: false}, // new tick
+	}
+	for i, s := range steps {
+		if s.boundary {
+			d.boundary()
+		}
+		if got := d.duplicate(s.tick, name, combatEntry(s.value)); got != s.want {
+			t.Errorf("step %d: duplicate = %v, want %v", i, got, s.want)
+		}
+	}
+	if d.dropped != 1 {
+		t.Errorf("dropped = %d, want 1", d.dropped)
+	}
+	if d.duplicate(100, "CDemoPacket", &dota.CDemoPacket{}) {
+		t.Error("a demo command was a duplicate")
+	}
+}
+
+func TestStringTableDumpDedup(t *testing.T) {
+	dump := func(items ...string) *dota.CDemoStringTables {
+		table := &dota.CDemoStringTablesTableT{TableName: proto.String("EntityNames")}
+		for _, s := range items {
+			table.Items = append(table.Items, &dota.CDemoStringTablesItemsT{Str: proto.String(s)})
+		}
+		return &dota.CDemoStringTables{Tables: []*dota.CDemoStringTablesTableT{table}}
+	}
+	d := testDedup()
+	steps := []struct {
+		dump *dota.CDemoStringTables
+		want bool
+	}{
+		{dump("npc_dota_hero_axe"), false},
+		{dump("npc_dota_hero_axe"), true},
+		{dump("npc_dota_hero_axe", "npc_dota_hero_lina"), false},
+		{dump("npc_dota_hero_axe", "npc_dota_hero_lina"), true},
+		{&dota.CDemoStringTables{}, false},
+	}
+	for i, s := range steps {
+		if got := d.duplicate(uint32(i), "CDemoStringTables", s.dump); got != s.want {
+			t.Errorf("step %d: duplicate = %v, want %v", i, got, s.want)
+		}
+	}
+}
+
+// BenchmarkMessageDedup is the per-message cost -dedup adds.
+func BenchmarkMessageDedup(b *testing.B) {
+	d := testDedup()
+	m := combatEntry(50)
+	b.ReportAllocs()
+	for i := 0; b.Loop(); i++ {
+		d.duplicate(uint32(i/8), "CMsgDOTACombatLogEntry", m)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"hash/crc32"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// dictDmer is the length of the byte strings whose frequency across
+	// samples scores a segment.
+	dictDmer = 8
+	// dictMaxLine skips records too large to be typical.
+	dictMaxLine = 64 << 10
+	// dictHoldout keeps every Nth sample out of training to measure the
+	// dictionary on.
+	dictHoldout = 10
+	// dictMinID is the first dictionary ID outside the range zstd reserves
+	// for a future registry.
+	dictMinID = 1 << 15
+)
+
+// dictSampler reservoir-samples encoded records, so a fixed number of
+// lines is kept however large the corpus.
+type dictSampler struct {
+	max     int
+	seen    int
+	samples [][]byte
+	rng     *rand.Rand
+}
+
+func newDictSampler(max int) *dictSampler {
+	return &dictSampler{max: max, rng: rand.New(rand.NewPCG(1, 2))}
+}
+
+func (s *dictSampler) add(line []byte) {
+	if len(line) == 0 || len(line) > dictMaxLine {
+		return
+	}
+	s.seen++
+	if len(s.samples) < s.max {
+		s.samples = append(s.samples, bytes.Clone(line))
+		return
+	}
+	if i := s.rng.IntN(s.seen); i < s.max {
+		s.samples[i] = bytes.Clone(line)
+	}
+}
+
+// Encode lets the sampler stand in for the output encoder when records are
+// decoded from a replay.
+func (s *dictSampler) Encode(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.add(line)
+	return nil
+}
+
+func (s *dictSampler) readJSONL(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		s.add(bytes.TrimRight(line, "\r\n"))
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeReplay samples the records `events` writes by default: raw
+// callbacks and game events.
+func (s *dictSampler) decodeReplay(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	parser, err := manta.NewStreamParser(in)
+	if err != nil {
+		return err
+	}
+	defer releaseCombatLog(parser)
+	out := newOutputState(s, false)
+	wrote := 0
+	extractors := newExtractorRegistry(parser)
+	extractors.definePausable("raw", nil, func(h *extractorHooks) func() error {
+		registerAllCallbacks(parser, out, h, &wrote, false, newMessageDedup(parser))
+		return nil
+	})
+	extractors.definePausable("game_events", nil, func(h *extractorHooks) func() error {
+		registerGameEvents(parser, out, h, &wrote)
+		return nil
+	})
+	for _, name := range []string{"raw", "game_events"} {
+		if err := extractors.enable(name); err != nil {
+			return err
+		}
+	}
+	if err := extractors.install(); err != nil {
+		return err
+	}
+	if err := parser.Start(); err != nil {
+		return err
+	}
+	return out.flushFinal()
+}
+
+func (s *dictSampler) load(path string) error {
+	if strings.EqualFold(filepath.Ext(path), ".dem") {
+		return s.decodeReplay(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.readJSONL(f)
+}
+
+func dmerAt(b []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(b[i : i+dictDmer])
+}
+
+// dictSegment is a candidate slice of a sample and its last known score.
+type dictSegment struct {
+	data  []byte
+	score uint64
+}
+
+type segmentHeap []*dictSegment
+
+func (h segmentHeap) Len() int           { return len(h) }
+func (h segmentHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h segmentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *segmentHeap) Push(x any)        { *h = append(*h, x.(*dictSegment)) }
+func (h *segmentHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// segmentScore sums the frequency of the distinct dmers in data that no
+// chosen segment covers yet.
+func segmentScore(data []byte, freq map[uint64]uint32) uint64 {
+	var score uint64
+	counted := map[uint64]bool{}
+	for i := 0; i+dictDmer <= len(data); i++ {
+		d := dmerAt(data, i)
+		if !counted[d] {
+			counted[d] = true
+			score += uint64(freq[d])
+		}
+	}
+	return score
+}
+
+// trainDictionary picks the content of a dictionary, up to size bytes, from
+// samples after the COVER approach zstd uses: segments are chosen greedily
+// by how often their dmers recur across samples, each dmer counting once,
+// and laid out with the most valuable segment last, nearest the data.
+func trainDictionary(samples [][]byte, size, segment int) []byte {
+	freq := map[uint64]uint32{}
+	for _, s := range samples {
+		seen := map[uint64]bool{}
+		for i := 0; i+dictDmer <= len(s); i++ {
+			d := dmerAt(s, i)
+			if !seen[d] {
+				seen[d] = true
+				freq[d]++
+			}
+		}
+	}
+	var candidates segmentHeap
+	step := max(segment/2, 1)
+	for _, s := range samples {
+		for start := 0; start < len(s); start += step {
+			data := s[start:min(start+segment, len(s))]
+			if len(data) < dictDmer {
+				break
+			}
+			candidates = append(candidates, &dictSegment{data: data, score: segmentScore(data, freq)})
+		}
+	}
+	heap.Init(&candidates)
+
+	var chosen [][]byte
+	total := 0
+	for total < size && candidates.Len() > 0 {
+		best := heap.Pop(&candidates).(*dictSegment)
+		best.score = segmentScore(best.data, freq)
+		if best.score == 0 {
+			continue
+		}
+		if candidates.Len() > 0 && best.score < candidates[0].score {
+			heap.Push(&candidates, best)
+			continue
+		}
+		chosen = append(chosen, best.data)
+		total += len(best.data)
+		for i := 0; i+dictDmer <= len(best.data); i++ {
+			delete(freq, dmerAt(best.data, i))
+		}
+	}
+
+	dict := make([]byte, 0, total)
+	for i := len(chosen) - 1; i >= 0; i-- {
+		dict = append(dict, chosen[i]...)
+	}
+	if len(dict) > size {
+		dict = dict[len(dict)-size:]
+	}
+	return dict
+}
+
+// dictRepeatOffsets are the initial repeat offsets zstd starts every frame
+// with.
+var dictRepeatOffsets = [3]int{1, 4, 8}
+
+// buildZstdDictionary wraps trained content in the zstd dictionary format:
+// an ID, entropy tables fitted to how samples compress against the content,
+// and the content itself.
+func buildZstdDictionary(content []byte, samples [][]byte) ([]byte, error) {
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       dictContentID(content),
+		Contents: samples,
+		History:  content,
+		Offsets:  dictRepeatOffsets,
+		Level:    zstd.SpeedDefault,
+	})
+}
+
+// dictContentID derives a dictionary ID from its content, so training the
+// same content twice gives the same ID.
+func dictContentID(content []byte) uint32 {
+	return dictMinID + crc32.ChecksumIEEE(content)%(1<<31-dictMinID)
+}
+
+// compressedSize is the zstd size of each sample compressed on its own, the
+// way small batches and single records are shipped. dict is a zstd
+// dictionary, or nil.
+func compressedSize(samples [][]byte, dict []byte) (int, error) {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer enc.Close()
+	total := 0
+	var buf []byte
+	for _, s := range samples {
+		buf = enc.EncodeAll(s, buf[:0])
+		total += len(buf)
+	}
+	return total, nil
+}
+
+// dictionaryID is the ID stored in a zstd dictionary, which -ingest sends
+// so a collector can pick the matching dictionary.
+func dictionaryID(dict []byte) (uint32, error) {
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return 0, err
+	}
+	return d.ID(), nil
+}
+
+// runDict implements `dict [flags] input...`, training a compression
+// dictionary from records sampled across decoder outputs or replays.
+func runDict(args []string) {
+	fs := flag.NewFlagSet("dict", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	outPath := fs.String("out", "faeton.dict", "dictionary output path")
+	size := fs.Int("size", 110<<10, "dictionary content size in bytes, before the zstd entropy tables")
+	samples := fs.Int("samples", 20000, "records sampled across all inputs")
+	segment := fs.Int("segment", 256, "length of the byte segments the dictionary is built from")
+	inputs := global.parseArgs(fs, args, nil)
+
+	if len(inputs) == 0 {
+		fatal(exitUsage, "at least one input (.jsonl output or .dem replay) is required")
+	}
+	if *size < dictDmer || *samples < dictHoldout || *segment < dictDmer {
+		fatal(exitUsage, "-size, -samples and -segment are too small")
+	}
+	sampler := newDictSampler(*samples)
+	for _, path := range inputs {
+		logger.Debug("sampling input", "input", path)
+		if err := sampler.load(path); err != nil {
+			warn("sample input", "input", path, "err", err)
+		}
+	}
+	if len(sampler.samples) < dictHoldout {
+		fatal(exitInvalidReplay, "too few records to train on", "records", len(sampler.samples))
+	}
+
+	var train, holdout [][]byte
+	for i, s := range sampler.samples {
+		if i%dictHoldout == 0 {
+			holdout = append(holdout, s)
+		} else {
+			train = append(train, s)
+		}
+	}
+	dict, err := buildZstdDictionary(trainDictionary(train, *size, *segment), train)
+	if err != nil {
+		fatal(exitInvalidReplay, "build dictionary", "err", err)
+	}
+	if err := os.WriteFile(*outPath, dict, 0o644); err != nil {
+		fatal(exitIO, "write dictionary", "err", err)
+	}
+
+	raw := 0
+	for _, s := range holdout {
+		raw += len(s)
+	}
+	plain, err := compressedSize(holdout, nil)
+	if err != nil {
+		fatal(exitIO, "measure dictionary", "err", err)
+	}
+	withDict, err := compressedSize(holdout, dict)
+	if err != nil {
+		fatal(exitIO, "measure dictionary", "err", err)
+	}
+	id, err := dictionaryID(dict)
+	if err != nil {
+		fatal(exitInvalidReplay, "build dictionary", "err", err)
+	}
+	logger.Info("dictionary written", "path", *outPath, "bytes", len(dict), "id", id,
+		"records", sampler.seen, "sampled", len(sampler.samples),
+		"holdout_ratio", float64(raw)/float64(plain), "holdout_ratio_dict", float64(raw)/float64(withDict))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func dictSamples(n int) [][]byte {
+	heroes := []string{"axe", "lina", "techies", "pudge"}
+	samples := make([][]byte, n)
+	for i := range samples {
+		samples[i] = fmt.Appendf(nil, `{"kind":"cast","tick":%d,"hero":"npc_dota_hero_%s","ability":"%s_ability_%d"}`,
+			1000+i*37, heroes[i%len(heroes)], heroes[i%len(heroes)], i%3)
+	}
+	return samples
+}
+
+func TestTrainDictionary(t *testing.T) {
+	samples := dictSamples(64)
+	tests := []struct {
+		name    string
+		samples [][]byte
+		size    int
+		segment int
+		want    []byte // must appear in the dictionary
+		maxLen  int
+	}{
+		{name: "no samples", size: 1024, segment: 32},
+		{name: "too short", samples: [][]byte{[]byte("abc")}, size: 1024, segment: 32},
+		{name: "shared prefix", samples: samples, size: 4096, segment: 32, want: []byte(`{"kind":"cast","tick":`), maxLen: 4096},
+		{name: "capped", samples: samples, size: 40, segment: 32, maxLen: 40},
+		{name: "tiny segment", samples: samples, size: 256, segment: 1, maxLen: 256},
+	}
+	for _, tt := range tests {
+		dict := trainDictionary(tt.samples, tt.size, tt.segment)
+		if len(dict) > tt.maxLen {
+			t.Errorf("%s: %d byte dictionary, want at most %d", tt.name, len(dict), tt.maxLen)
+		}
+		if tt.want != nil && !bytes.Contains(dict, tt.want) {
+			t.Errorf("%s: dictionary %q lacks %q", tt.name, dict, tt.want)
+		}
+	}
+}
+
+func TestCompressedSize(t *testing.T) {
+	train, holdout := dictSamples(64), dictSamples(80)[64:]
+	plain, err := compressedSize(holdout, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := trainDictionary(train, 4096, 64)
+	dict, err := buildZstdDictionary(content, train)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withDict, err := compressedSize(holdout, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withDict >= plain {
+		t.Errorf("dictionary compressed to %d bytes, not below %d without", withDict, plain)
+	}
+	if n, err := compressedSize(nil, dict); n != 0 || err != nil {
+		t.Errorf("compressedSize(nil) = %d, %v", n, err)
+	}
+	id, err := dictionaryID(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := dictContentID(content); id != want || id < dictMinID {
+		t.Errorf("dictionaryID = %d, want %d", id, want)
+	}
+	if _, err := dictionaryID(content); err == nil {
+		t.Error("raw content was accepted as a zstd dictionary")
+	}
+	if _, err := buildZstdDictionary([]byte("short"), train); err == nil {
+		t.Error("a dictionary was built from 5 bytes of content")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dotaconstantsBaseURL hosts the build output of odota/dotaconstants.
+const dotaconstantsBaseURL = "https://raw.githubusercontent.com/odota/dotaconstants/master/build/"
+
+var dotaconstantsFiles = []string{"heroes.json", "abilities.json", "items.json", "hero_abilities.json"}
+
+type heroFacet struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+}
+
+type heroKit struct {
+	Abilities []string    `json:"abilities"`
+	Facets    []heroFacet `json:"facets"`
+}
+
+// displayNames maps internal unit, ability and item names to the localized
+// names shown in game. It is loaded from a directory of dotaconstants JSON
+// files.
+type displayNames struct {
+	names  map[string]string
+	innate map[string]bool
+	kits   map[string]heroKit
+}
+
+// nameMapping is set from -names and consulted by prettyUnitName and
+// prettyAbilityName before falling back to the built-in heuristics.
+var nameMapping *displayNames
+
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func loadDisplayNames(dir string) (*displayNames, error) {
+	d := &displayNames{
+		names:  map[string]string{},
+		innate: map[string]bool{},
+		kits:   map[string]heroKit{},
+	}
+
+	var heroes map[string]struct {
+		Name          string `json:"name"`
+		LocalizedName string `json:"localized_name"`
+	}
+	if err := readJSONFile(filepath.Join(dir, "heroes.json"), &heroes); err != nil {
+		return nil, fmt.Errorf("heroes.json: %w", err)
+	}
+	for _, h := range heroes {
+		if h.Name != "" && h.LocalizedName != "" {
+			d.names[h.Name] = h.LocalizedName
+		}
+	}
+
+	var abilities map[string]struct {
+		Dname    string `json:"dname"`
+		IsInnate bool   `json:"is_innate"`
+	}
+	if err := readJSONFile(filepath.Join(dir, "abilities.json"), &abilities); err != nil {
+		return nil, fmt.Errorf("abilities.json: %w", err)
+	}
+	for key, a := range abilities {
+		if a.Dname != "" {
+			d.names[key] = a.Dname
+		}
+		if a.IsInnate {
+			d.innate[key] = true
+		}
+	}
+
+	var items map[string]struct {
+		Dname string `json:"dname"`
+	}
+	if err := readJSONFile(filepath.Join(dir, "items.json"), &items); err != nil {
+		return nil, fmt.Errorf("items.json: %w", err)
+	}
+	for key, it := range items {
+		if it.Dname != "" {
+			d.names["item_"+key] = it.Dname
+		}
+	}
+
+	// hero_abilities.json predates facets in older refreshes, so it is
+	// optional; without it facets are reported by index only.
+	err := readJSONFile(filepath.Join(dir, "hero_abilities.json"), &d.kits)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("hero_abilities.json: %w", err)
+	}
+	return d, nil
+}
+
+func (d *displayNames) lookup(name string) (string, bool) {
+	if d == nil || name == "" {
+		return "", false
+	}
+	if v, ok := d.names[name]; ok {
+		return v, true
+	}
+	// Modifiers usually share the name of the ability that applies them.
+	if strings.HasPrefix(name, "modifier_") {
+		v, ok := d.names[strings.TrimPrefix(name, "modifier_")]
+		return v, ok
+	}
+	return "", false
+}
+
+// facet returns the facet a hero variant index (1-based, as networked)
+// refers to.
+func (d *displayNames) facet(hero string, variant int32) (heroFacet, bool) {
+	if d == nil || variant < 1 {
+		return heroFacet{}, false
+	}
+	facets := d.kits[hero].Facets
+	if int(variant) > len(facets) {
+		return heroFacet{}, false
+	}
+	return facets[variant-1], true
+}
+
+func (d *displayNames) isInnate(ability string) bool {
+	return d != nil && d.innate[ability]
+}
+
+// isBaseAbility reports whether ability is part of hero's standard kit; ok
+// is false when the kit is unknown.
+func (d *displayNames) isBaseAbility(hero, ability string) (base, ok bool) {
+	if d == nil {
+		return false, false
+	}
+	kit, found := d.kits[hero]
+	if !found {
+		return false, false
+	}
+	for _, a := range kit.Abilities {
+		if a == ability {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// annotate adds a <key>_display field next to every top-level string field
+// of rec that names a known unit, ability or item.
+func (d *displayNames) annotate(rec map[string]any) {
+	if d == nil {
+		return
+	}
+	var keys []string
+	for k, v := range rec {
+		if s, ok := v.(string); ok {
+			if _, known := d.lookup(s); known {
+				keys = append(keys, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		rec[k+"_display"], _ = d.lookup(rec[k].(string))
+	}
+}
+
+func downloadFile(client *http.Client, url, path string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runRefreshNames implements `refresh-names -dir <dir>`, downloading the
+// dotaconstants files that -names reads.
+func runRefreshNames(args []string) {
+	fs := flag.NewFlagSet("refresh-names", flag.ExitOnError)
+	dir := fs.String("dir", "names", "directory to write heroes.json, abilities.json and items.json into")
+	baseURL := fs.String("url", dotaconstantsBaseURL, "base URL of the dotaconstants build directory")
+	global := addGlobalFlags(fs)
+	global.parseArgs(fs, args, nil)
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		fatal(exitIO, "create dir", "err", err)
+	}
+	client := &http.Client{Timeout: time.Minute}
+	for _, name := range dotaconstantsFiles {
+		url := strings.TrimSuffix(*baseURL, "/") + "/" + name
+		if err := downloadFile(client, url, filepath.Join(*dir, name)); err != nil {
+			fatal(exitIO, "download", "url", url, "err", err)
+		}
+	}
+	d, err := loadDisplayNames(*dir)
+	if err != nil {
+		fatal(exitIO, "downloaded files do not parse", "err", err)
+	}
+	logger.Info("refreshed names", "names", len(d.names), "dir", *dir)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+const (
+	// diveRadius is how close to a tower (world units) a death must be to
+	// count as under it: tower attack range plus a margin for hero size.
+	diveRadius = 900
+	// diveWindow is how long (game seconds) a dive stays open after its
+	// last death or tower shot, to catch trades.
+	diveWindow = 10
+)
+
+type towerDive struct {
+	tower       string
+	towerTeam   uint32
+	startTick   uint32
+	start       float32
+	last        float32
+	victims     []string
+	diverDeaths []string
+	divers      map[string]bool
+	towerDamage map[string]uint64
+}
+
+func (d *towerDive) outcome() string {
+	switch {
+	case len(d.victims) > 0 && len(d.diverDeaths) == 0:
+		return "kill"
+	case len(d.victims) > 0:
+		return "trade"
+	}
+	return "failed"
+}
+
+type diveDetector struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	active map[string]*towerDive
+}
+
+func isTowerEntity(e *manta.Entity) bool {
+	return strings.HasPrefix(e.GetClassName(), "CDOTA_BaseNPC_Tower") && isAlive(e)
+}
+
+func (d *diveDetector) dive(tower string, team uint32, clock float32) *towerDive {
+	dv, ok := d.active[tower]
+	if !ok {
+		dv = &towerDive{
+			tower:       tower,
+			towerTeam:   team,
+			startTick:   d.parser.Tick,
+			start:       clock,
+			divers:      map[string]bool{},
+			towerDamage: map[string]uint64{},
+		}
+		d.active[tower] = dv
+	}
+	dv.last = clock
+	return dv
+}
+
+// addDiversNear marks enemy heroes standing within diveRadius of the tower.
+func (d *diveDetector) addDiversNear(dv *towerDive, towerPos mapcoord.World) {
+	for _, h := range realHeroes(d.parser) {
+		if entityTeam(h) == dv.towerTeam || !isAlive(h) {
+			continue
+		}
+		pos, ok := entityWorldPosition(h)
+		if !ok || mapcoord.Distance2D(pos, towerPos) > diveRadius {
+			continue
+		}
+		if name := entityUnitName(d.parser, h); name != "" {
+			dv.divers[name] = true
+		}
+	}
+}
+
+func (d *diveDetector) onHeroDeath(m *dota.CMsgDOTACombatLogEntry, clock float32) {
+	victim := lookupCombatLogName(d.parser, m.GetTargetName())
+	attacker := lookupCombatLogName(d.parser, m.GetAttackerName())
+	where := mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())}
+	for _, t := range d.parser.FilterEntity(isTowerEntity) {
+		pos, ok := entityWorldPosition(t)
+		if !ok || mapcoord.Distance2D(pos, where) > diveRadius {
+			continue
+		}
+		name := entityUnitName(d.parser, t)
+		team := entityTeam(t)
+		dv := d.dive(name, team, clock)
+		if team == m.GetTargetTeam() {
+			dv.victims = append(dv.victims, victim)
+			if m.GetIsAttackerHero() && !m.GetIsAttackerIllusion() && m.GetAttackerTeam() != team {
+				dv.divers[attacker] = true
+			}
+		} else {
+			dv.diverDeaths = append(dv.diverDeaths, victim)
+			dv.divers[victim] = true
+		}
+		d.addDiversNear(dv, pos)
+	}
+}
+
+func (d *diveDetector) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	clock := d.rules.clock(m.GetTimestamp())
+	if err := d.closeExpired(clock); err != nil {
+		return err
+	}
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		if m.GetIsTargetHero() && !m.GetIsTargetIllusion() {
+			d.onHeroDeath(m, clock)
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE:
+		if !m.GetIsTargetHero() || m.GetIsTargetIllusion() {
+			return nil
+		}
+		tower := lookupCombatLogName(d.parser, m.GetAttackerName())
+		if dv, ok := d.active[tower]; ok {
+			target := lookupCombatLogName(d.parser, m.GetTargetName())
+			dv.towerDamage[target] += uint64(m.GetValue())
+			dv.divers[target] = true
+			dv.last = clock
+		}
+	}
+	return nil
+}
+
+func (d *diveDetector) closeExpired(clock float32) error {
+	var expired []string
+	for tower, dv := range d.active {
+		if clock-dv.last > diveWindow {
+			expired = append(expired, tower)
+		}
+	}
+	sort.Strings(expired)
+	for _, tower := range expired {
+		if err := d.close(tower); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *diveDetector) close(tower string) error {
+	dv := d.active[tower]
+	delete(d.active, tower)
+	divers := make([]string, 0, len(dv.divers))
+	for h := range dv.divers {
+		divers = append(divers, h)
+	}
+	sort.Strings(divers)
+	rec := map[string]any{
+		"kind":         "dive",
+		"tick":         d.parser.Tick,
+		"start_tick":   dv.startTick,
+		"start":        dv.start,
+		"end":          dv.last,
+		"tower":        dv.tower,
+		"tower_team":   dv.towerTeam,
+		"divers":       divers,
+		"victims":      dv.victims,
+		"diver_deaths": dv.diverDeaths,
+		"tower_damage": dv.towerDamage,
+		"outcome":      dv.outcome(),
+	}
+	(*d.wrote)++
+	return d.out.add(d.parser.Tick, rec, false)
+}
+
+func (d *diveDetector) finalize() error {
+	towers := make([]string, 0, len(d.active))
+	for tower := range d.active {
+		towers = append(towers, tower)
+	}
+	sort.Strings(towers)
+	for _, tower := range towers {
+		if err := d.close(tower); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerDives(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	d := &diveDetector{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		active: map[string]*towerDive{},
+	}
+	subscribeCombatLog(parser, d.onCombatLog)
+	return d.finalize
+}
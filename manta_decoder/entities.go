@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dotabuff/manta"
+
+	"manta_decoder/mapcoord"
+)
+
+func isHeroEntity(e *manta.Entity) bool {
+	return strings.HasPrefix(e.GetClassName(), "CDOTA_Unit_Hero_")
+}
+
+// invalidHandle is the value of an unset entity handle field.
+const invalidHandle = 0xFFFFFF
+
+// entityUnitName resolves the npc_dota_* name the combat log uses for an
+// entity via the EntityNames string table, in its canonical form.
+func entityUnitName(parser *manta.Parser, e *manta.Entity) string {
+	return canonicalName(rawEntityUnitName(parser, e))
+}
+
+// rawEntityUnitName is entityUnitName before aliasing, for extractors that
+// tell drop variants apart.
+func rawEntityUnitName(parser *manta.Parser, e *manta.Entity) string {
+	idx, ok := e.GetInt32("m_pEntity.m_nameStringableIndex")
+	if !ok || idx < 0 {
+		return ""
+	}
+	name, _ := parser.LookupStringByIndex("EntityNames", idx)
+	return name
+}
+
+func isIllusionEntity(e *manta.Entity) bool {
+	h, ok := e.GetUint32("m_hReplicatingOtherHeroModel")
+	return ok && h != invalidHandle
+}
+
+// realHeroes returns every non-illusion hero entity.
+func realHeroes(parser *manta.Parser) []*manta.Entity {
+	return parser.FilterEntity(func(e *manta.Entity) bool {
+		return isHeroEntity(e) && !isIllusionEntity(e)
+	})
+}
+
+// heroNamesByPlayerID maps player IDs, as used in combat log assist lists,
+// to the unit name of the hero that player controls.
+func heroNamesByPlayerID(parser *manta.Parser) map[int32]string {
+	names := map[int32]string{}
+	for _, e := range realHeroes(parser) {
+		id, ok := e.GetInt32("m_iPlayerID")
+		if !ok {
+			continue
+		}
+		if name := entityUnitName(parser, e); name != "" {
+			names[id] = name
+		}
+	}
+	return names
+}
+
+func entityWorldPosition(e *manta.Entity) (mapcoord.World, bool) {
+	cellX, okX := e.GetUint32("CBodyComponent.m_cellX")
+	cellY, okY := e.GetUint32("CBodyComponent.m_cellY")
+	if !okX || !okY {
+		return mapcoord.World{}, false
+	}
+	cellZ, _ := e.GetUint32("CBodyComponent.m_cellZ")
+	vecX, _ := e.GetFloat32("CBodyComponent.m_vecX")
+	vecY, _ := e.GetFloat32("CBodyComponent.m_vecY")
+	vecZ, _ := e.GetFloat32("CBodyComponent.m_vecZ")
+	return mapcoord.FromCell(cellX, cellY, cellZ, vecX, vecY, vecZ), true
+}
+
+// tickSampler fires at most once per interval ticks. Entity handlers run once
+// per changed entity, so samplers keep whole-world snapshots from repeating
+// within a single packet.
+type tickSampler struct {
+	interval uint32
+	next     uint32
+}
+
+func newTickSampler(interval uint32) *tickSampler {
+	return &tickSampler{interval: interval}
+}
+
+func (s *tickSampler) due(tick uint32) bool {
+	if s.interval == 0 || tick < s.next {
+		return false
+	}
+	s.next = tick - tick%s.interval + s.interval
+	return true
+}
+
+func positionRecord(parser *manta.Parser, e *manta.Entity, w mapcoord.World) map[string]any {
+	team, _ := e.GetInt32("m_iTeamNum")
+	return map[string]any{
+		"kind":    "position",
+		"tick":    parser.Tick,
+		"entity":  e.GetIndex(),
+		"class":   e.GetClassName(),
+		"team":    team,
+		"world":   w,
+		"minimap": w.Minimap(),
+	}
+}
+
+// registerPositions samples hero entities, including illusions and clones,
+// and with summons also every unit a hero owns. Records carry unit_type and
+// owner so consumers can tell them apart.
+func registerPositions(h *extractorHooks, out *outputState, interval uint32, summons bool, wrote *int) {
+	parser := h.parser()
+	sampler := newTickSampler(interval)
+	units := newUnitClassifier(parser)
+	h.onEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		for _, e := range parser.FilterEntity(func(e *manta.Entity) bool {
+			return isHeroEntity(e) || (summons && isSummonCandidate(e))
+		}) {
+			w, ok := entityWorldPosition(e)
+			if !ok {
+				continue
+			}
+			rec := positionRecord(parser, e, w)
+			if !units.annotate(rec, e) {
+				continue
+			}
+			(*wrote)++
+			if err := out.add(parser.Tick, rec, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// maxAbilitySlots bounds the m_vecAbilities array on hero entities.
+const maxAbilitySlots = 35
+
+func heroAbilities(parser *manta.Parser, hero *manta.Entity) []*manta.Entity {
+	var abilities []*manta.Entity
+	for i := 0; i < maxAbilitySlots; i++ {
+		h, ok := hero.GetUint32(fmt.Sprintf("m_vecAbilities.%04d", i))
+		if !ok {
+			break
+		}
+		if h == invalidHandle {
+			continue
+		}
+		if a := parser.FindEntityByHandle(uint64(h)); a != nil {
+			abilities = append(abilities, a)
+		}
+	}
+	return abilities
+}
+
+func entityTeam(e *manta.Entity) uint32 {
+	team, _ := e.GetInt32("m_iTeamNum")
+	return uint32(team)
+}
+
+// heroEntityByName finds the real hero entity with the given unit name.
+func heroEntityByName(parser *manta.Parser, name string) *manta.Entity {
+	for _, h := range realHeroes(parser) {
+		if entityUnitName(parser, h) == name {
+			return h
+		}
+	}
+	return nil
+}
+
+// heroesWithin returns the real heroes standing within radius of center.
+func heroesWithin(parser *manta.Parser, center mapcoord.World, radius float64) []*manta.Entity {
+	var near []*manta.Entity
+	for _, h := range realHeroes(parser) {
+		if pos, ok := entityWorldPosition(h); ok && mapcoord.Distance2D(pos, center) <= radius {
+			near = append(near, h)
+		}
+	}
+	return near
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// extractorSetup installs an extractor's callbacks on the parser and
+// returns its end-of-replay finalizer, or nil.
+type extractorSetup func(h *extractorHooks) func() error
+
+type extractor struct {
+	name      string
+	deps      []string
+	setup     extractorSetup
+	enabled   bool
+	installed bool
+	// active is cleared to pause an installed extractor; handlers added
+	// through its hooks then return without doing anything. Only pausable
+	// extractors, whose handlers are all added through the hooks, can be
+	// paused, and cascaded marks one paused because a dependency was.
+	active   bool
+	pausable bool
+	cascaded bool
+	finalize func() error
+	// release drops the state a paused extractor keeps for its later
+	// records, which it no longer writes.
+	release func()
+}
+
+// extractorRegistry holds the named extractors of a run. Defining, enabling
+// and installing are idempotent, dependencies are installed first, and
+// claim guards parser callbacks that must be registered only once.
+type extractorRegistry struct {
+	parser    *manta.Parser
+	byName    map[string]*extractor
+	order     []*extractor
+	installed []*extractor
+	claimed   map[string]bool
+}
+
+func newExtractorRegistry(parser *manta.Parser) *extractorRegistry {
+	return &extractorRegistry{
+		parser:  parser,
+		byName:  map[string]*extractor{},
+		claimed: map[string]bool{},
+	}
+}
+
+// define adds a named extractor that needs deps installed before it. A name
+// that is already defined keeps its first definition.
+func (r *extractorRegistry) define(name string, deps []string, setup extractorSetup) {
+	if _, ok := r.byName[name]; ok {
+		return
+	}
+	x := &extractor{name: name, deps: deps, setup: setup, active: true}
+	r.byName[name] = x
+	r.order = append(r.order, x)
+}
+
+// definePausable is define for an extractor that registers every handler
+// through its hooks, so setActive can pause it.
+func (r *extractorRegistry) definePausable(name string, deps []string, setup extractorSetup) {
+	r.define(name, deps, setup)
+	r.byName[name].pausable = true
+}
+
+// enable marks name, and through install its dependencies, to be installed.
+func (r *extractorRegistry) enable(name string) error {
+	x, ok := r.byName[name]
+	if !ok {
+		return fmt.Errorf("unknown extractor %q", name)
+	}
+	x.enabled = true
+	return nil
+}
+
+// install sets up every enabled extractor not yet installed, dependencies
+// first and otherwise in definition order.
+func (r *extractorRegistry) install() error {
+	visiting := map[string]bool{}
+	var visit func(x *extractor) error
+	visit = func(x *extractor) error {
+		if x.installed {
+			return nil
+		}
+		if visiting[x.name] {
+			return fmt.Errorf("extractor dependency cycle through %q", x.name)
+		}
+		visiting[x.name] = true
+		for _, dep := range x.deps {
+			d, ok := r.byName[dep]
+			if !ok {
+				return fmt.Errorf("extractor %q needs unknown extractor %q", x.name, dep)
+			}
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		x.installed = true
+		x.finalize = x.setup(&extractorHooks{registry: r, extractor: x})
+		r.installed = append(r.installed, x)
+		return nil
+	}
+	for _, x := range r.order {
+		if !x.enabled {
+			continue
+		}
+		if err := visit(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setActive pauses or resumes an installed extractor during the parse.
+// Pausing also pauses the extractors that depend on it, and resuming resumes
+// those again, with the state their onPause functions dropped gone. Extractors with handlers outside their hooks would keep
+// running while paused, so setActive refuses them.
+func (r *extractorRegistry) setActive(name string, active bool) error {
+	x, ok := r.byName[name]
+	if !ok || !x.installed {
+		return fmt.Errorf("extractor %q is not installed", name)
+	}
+	if !r.canPause(x) {
+		return fmt.Errorf("extractor %q or one depending on it registers callbacks outside its hooks and cannot be paused", name)
+	}
+	r.setActiveFrom(x, active, false)
+	return nil
+}
+
+// canPause reports whether x and every installed extractor depending on it
+// are pausable.
+func (r *extractorRegistry) canPause(x *extractor) bool {
+	if !x.pausable {
+		return false
+	}
+	for _, d := range r.dependents(x) {
+		if !r.canPause(d) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *extractorRegistry) setActiveFrom(x *extractor, active, cascaded bool) {
+	if x.active == active {
+		return
+	}
+	if active && cascaded && !x.cascaded {
+		// Paused on its own account, not by the dependency resuming now.
+		return
+	}
+	x.active = active
+	x.cascaded = !active && cascaded
+	if !active && x.release != nil {
+		x.release()
+	}
+	for _, d := range r.dependents(x) {
+		r.setActiveFrom(d, active, true)
+	}
+}
+
+// dependents lists the installed extractors that depend directly on x.
+func (r *extractorRegistry) dependents(x *extractor) []*extractor {
+	var deps []*extractor
+	for _, other := range r.installed {
+		if slices.Contains(other.deps, x.name) {
+			deps = append(deps, other)
+		}
+	}
+	return deps
+}
+
+// pausable reports whether setActive can pause name.
+func (r *extractorRegistry) pausable(name string) bool {
+	x, ok := r.byName[name]
+	return ok && x.installed && r.canPause(x)
+}
+
+// installedNames lists the installed extractors in installation order.
+func (r *extractorRegistry) installedNames() []string {
+	names := make([]string, len(r.installed))
+	for i, x := range r.installed {
+		names[i] = x.name
+	}
+	return names
+}
+
+// finalize runs the finalizers of the installed extractors in
+// installation order. Paused extractors are skipped, since their state
+// stopped following the replay.
+func (r *extractorRegistry) finalize() error {
+	for _, x := range r.installed {
+		if x.finalize == nil || !x.active {
+			continue
+		}
+		if err := x.finalize(); err != nil {
+			return fmt.Errorf("%s: %w", x.name, err)
+		}
+	}
+	return nil
+}
+
+// claim reports whether key is claimed for the first time, so a callback
+// keyed by it is registered on the parser only once.
+func (r *extractorRegistry) claim(key string) bool {
+	if r.claimed[key] {
+		return false
+	}
+	r.claimed[key] = true
+	return true
+}
+
+// extractorHooks is what an extractor's setup registers through; handlers
+// added here stop running while the extractor is paused.
+type extractorHooks struct {
+	registry  *extractorRegistry
+	extractor *extractor
+}
+
+func (h *extractorHooks) parser() *manta.Parser {
+	return h.registry.parser
+}
+
+func (h *extractorHooks) active() bool {
+	return h.extractor.active
+}
+
+func (h *extractorHooks) claim(key string) bool {
+	return h.registry.claim(key)
+}
+
+// onPause sets fn to drop the extractor's accumulated state when it is
+// paused. A resumed extractor starts again from what fn left.
+func (h *extractorHooks) onPause(fn func()) {
+	h.extractor.release = fn
+}
+
+func (h *extractorHooks) onEntity(fn manta.EntityHandler) {
+	h.registry.parser.OnEntity(func(e *manta.Entity, op manta.EntityOp) error {
+		if !h.extractor.active {
+			return nil
+		}
+		return fn(e, op)
+	})
+}
+
+func (h *extractorHooks) onCombatLog(fn func(*dota.CMsgDOTACombatLogEntry) error) {
+	subscribeCombatLog(h.registry.parser, func(m *dota.CMsgDOTACombatLogEntry) error {
+		if !h.extractor.active {
+			return nil
+		}
+		return fn(m)
+	})
+}
+
+func (h *extractorHooks) onGameEvent(name string, fn manta.GameEventHandler) {
+	h.registry.parser.OnGameEvent(name, func(e *manta.GameEvent) error {
+		if !h.extractor.active {
+			return nil
+		}
+		return fn(e)
+	})
+}
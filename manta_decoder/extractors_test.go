@@ -0,0 +1,110 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func noSetup(*extractorHooks) func() error { return nil }
+
+// releasing counts, by extractor, how often its state was dropped.
+func releasing(released map[string]int, name string) extractorSetup {
+	return func(h *extractorHooks) func() error {
+		h.onPause(func() { released[name]++ })
+		return nil
+	}
+}
+
+func TestExtractorInstallOrder(t *testing.T) {
+	r := newExtractorRegistry(nil)
+	r.define("a", nil, noSetup)
+	r.define("b", []string{"c"}, noSetup)
+	r.define("c", nil, noSetup)
+	r.define("d", nil, noSetup)
+	for _, name := range []string{"b", "a"} {
+		if err := r.enable(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.install(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := r.installedNames(), []string{"a", "c", "b"}; !slices.Equal(got, want) {
+		t.Errorf("installed %v, want %v", got, want)
+	}
+	if err := r.enable("missing"); err == nil {
+		t.Error("enabling an unknown extractor succeeded")
+	}
+}
+
+func TestExtractorInstallErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		defs map[string][]string
+	}{
+		{"cycle", map[string][]string{"a": {"b"}, "b": {"a"}}},
+		{"unknown dependency", map[string][]string{"a": {"nope"}}},
+	}
+	for _, tt := range tests {
+		r := newExtractorRegistry(nil)
+		for name, deps := range tt.defs {
+			r.define(name, deps, noSetup)
+		}
+		r.enable("a")
+		if err := r.install(); err == nil {
+			t.Errorf("%s: install succeeded", tt.name)
+		}
+	}
+}
+
+func TestExtractorPause(t *testing.T) {
+	r := newExtractorRegistry(nil)
+	released := map[string]int{}
+	r.definePausable("base", nil, releasing(released, "base"))
+	r.definePausable("child", []string{"base"}, releasing(released, "child"))
+	r.definePausable("own", []string{"base"}, noSetup)
+	r.define("direct", nil, noSetup)
+	r.definePausable("shared", nil, noSetup)
+	r.define("user", []string{"shared"}, noSetup)
+	for _, name := range []string{"base", "child", "own", "direct", "shared", "user"} {
+		r.enable(name)
+	}
+	if err := r.install(); err != nil {
+		t.Fatal(err)
+	}
+	active := func(name string) bool { return r.byName[name].active }
+
+	if err := r.setActive("own", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setActive("base", false); err != nil {
+		t.Fatal(err)
+	}
+	if active("base") || active("child") || active("own") {
+		t.Error("pausing base left it or a dependent running")
+	}
+	if released["base"] != 1 || released["child"] != 1 {
+		t.Errorf("released %v, want base and child once", released)
+	}
+	if err := r.setActive("base", true); err != nil {
+		t.Fatal(err)
+	}
+	if !active("base") || !active("child") {
+		t.Error("resuming base did not resume its cascaded dependent")
+	}
+	if active("own") {
+		t.Error("resuming base resumed a dependent paused on its own")
+	}
+
+	for _, name := range []string{"direct", "shared"} {
+		if err := r.setActive(name, false); err == nil {
+			t.Errorf("pausing %s succeeded", name)
+		}
+		if !active(name) {
+			t.Errorf("%s was paused", name)
+		}
+		if r.pausable(name) {
+			t.Errorf("%s reported pausable", name)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// fightGap is how long (in game seconds) hero-vs-hero combat may pause
+// before the current fight is considered over.
+const fightGap = 15
+
+type fight struct {
+	ID           int
+	StartTick    uint32
+	EndTick      uint32
+	Start        float32
+	End          float32
+	Deaths       []string
+	Participants map[string]bool
+}
+
+func (f *fight) participantList() []string {
+	names := make([]string, 0, len(f.Participants))
+	for h := range f.Participants {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fightHandler is called for every fight that closes. record is the fight
+// record about to be written, or nil when the skirmish ended with no hero
+// deaths and is discarded; handlers may add fields to a non-nil record.
+type fightHandler func(f *fight, record map[string]any) error
+
+// fightDetector groups hero-vs-hero damage and hero deaths into fights.
+// It must be registered before analyzers that call current(), so that a
+// combat log entry opening a new fight is seen by the detector first.
+type fightDetector struct {
+	parser  *manta.Parser
+	rules   *gameRulesRef
+	out     *outputState
+	emit    bool
+	wrote   *int
+	active  *fight
+	nextID  int
+	last    float32
+	onClose []fightHandler
+	onFinal []func() error
+}
+
+func newFightDetector(parser *manta.Parser, out *outputState, emit bool, wrote *int) *fightDetector {
+	d := &fightDetector{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		emit:   emit,
+		wrote:  wrote,
+		nextID: 1,
+	}
+	subscribeCombatLog(parser, d.onCombatLog)
+	return d
+}
+
+func (d *fightDetector) subscribe(h fightHandler) {
+	d.onClose = append(d.onClose, h)
+}
+
+// beforeFinalize adds a function run at the end of the replay before the
+// last fight closes, for analyzers that still add to it.
+func (d *fightDetector) beforeFinalize(fn func() error) {
+	d.onFinal = append(d.onFinal, fn)
+}
+
+// current returns the fight in progress, if any.
+func (d *fightDetector) current() *fight {
+	return d.active
+}
+
+func (d *fightDetector) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	clock := d.rules.clock(m.GetTimestamp())
+	if d.active != nil && clock-d.last > fightGap {
+		if err := d.close(); err != nil {
+			return err
+		}
+	}
+
+	attacker := lookupCombatLogName(d.parser, m.GetAttackerName())
+	target := lookupCombatLogName(d.parser, m.GetTargetName())
+	attackerHero := m.GetIsAttackerHero() && !m.GetIsAttackerIllusion()
+	targetHero := m.GetIsTargetHero() && !m.GetIsTargetIllusion()
+	enemies := m.GetAttackerTeam() != m.GetTargetTeam()
+
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE:
+		if !attackerHero || !targetHero || !enemies {
+			return nil
+		}
+		f := d.touch(clock)
+		f.Participants[attacker] = true
+		f.Participants[target] = true
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		if !targetHero {
+			return nil
+		}
+		f := d.touch(clock)
+		f.Deaths = append(f.Deaths, target)
+		f.Participants[target] = true
+		if attackerHero && enemies {
+			f.Participants[attacker] = true
+		}
+	}
+	return nil
+}
+
+func (d *fightDetector) touch(clock float32) *fight {
+	if d.active == nil {
+		d.active = &fight{
+			ID:           d.nextID,
+			StartTick:    d.parser.Tick,
+			Start:        clock,
+			Participants: map[string]bool{},
+		}
+		d.nextID++
+	}
+	d.active.EndTick = d.parser.Tick
+	d.active.End = clock
+	d.last = clock
+	return d.active
+}
+
+func (d *fightDetector) close() error {
+	f := d.active
+	d.active = nil
+	if f == nil {
+		return nil
+	}
+
+	var record map[string]any
+	if len(f.Deaths) > 0 {
+		record = map[string]any{
+			"kind":         "fight",
+			"tick":         f.EndTick,
+			"fight_id":     f.ID,
+			"start_tick":   f.StartTick,
+			"end_tick":     f.EndTick,
+			"start":        f.Start,
+			"end":          f.End,
+			"duration":     f.End - f.Start,
+			"deaths":       f.Deaths,
+			"participants": f.participantList(),
+		}
+	}
+	for _, h := range d.onClose {
+		if err := h(f, record); err != nil {
+			return err
+		}
+	}
+	if record == nil || !d.emit {
+		return nil
+	}
+	(*d.wrote)++
+	return d.out.add(d.parser.Tick, record, false)
+}
+
+func (d *fightDetector) finalize() error {
+	for _, fn := range d.onFinal {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return d.close()
+}
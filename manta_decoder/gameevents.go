@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// gameEventKeyTypes names the Source1 game event key types, numbered as in
+// the descriptor list.
+var gameEventKeyTypes = map[int32]string{
+	1: "string",
+	2: "float",
+	3: "long",
+	4: "short",
+	5: "byte",
+	6: "bool",
+	7: "uint64",
+}
+
+type gameEventKey struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type gameEventSchema struct {
+	EventID int32          `json:"event_id"`
+	Name    string         `json:"name"`
+	Keys    []gameEventKey `json:"keys"`
+}
+
+func newGameEventSchema(d *dota.CMsgSource1LegacyGameEventListDescriptorT) gameEventSchema {
+	s := gameEventSchema{EventID: d.GetEventid(), Name: d.GetName(), Keys: []gameEventKey{}}
+	for _, k := range d.GetKeys() {
+		typ, ok := gameEventKeyTypes[k.GetType()]
+		if !ok {
+			typ = fmt.Sprintf("unknown_%d", k.GetType())
+		}
+		s.Keys = append(s.Keys, gameEventKey{Name: k.GetName(), Type: typ})
+	}
+	return s
+}
+
+// fields decodes an event's keys by their descriptor types. Keys of a type
+// manta cannot read are left out.
+func (s gameEventSchema) fields(e *manta.GameEvent) map[string]any {
+	fields := make(map[string]any, len(s.Keys))
+	for _, k := range s.Keys {
+		var v any
+		var err error
+		switch k.Type {
+		case "string":
+			var str string
+			str, err = e.GetString(k.Name)
+			v = canonicalString(str)
+		case "float":
+			v, err = e.GetFloat32(k.Name)
+		case "long", "short", "byte":
+			v, err = e.GetInt32(k.Name)
+		case "bool":
+			v, err = e.GetBool(k.Name)
+		case "uint64":
+			v, err = e.GetUint64(k.Name)
+		default:
+			continue
+		}
+		if err == nil {
+			fields[k.Name] = v
+		}
+	}
+	return fields
+}
+
+// registerGameEvents emits a game_event record with decoded fields for every
+// Source1 legacy game event, and a game_event_schema record listing each
+// descriptor's keys and types the first time it is seen.
+func registerGameEvents(parser *manta.Parser, out *outputState, hooks *extractorHooks, wrote *int) {
+	parser.Callbacks.OnCMsgSource1LegacyGameEventList(func(m *dota.CMsgSource1LegacyGameEventList) error {
+		var added []gameEventSchema
+		for _, d := range m.GetDescriptors() {
+			name := d.GetName()
+			if name == "" {
+				warn("game event descriptor without a name", "event_id", d.GetEventid())
+				continue
+			}
+			if !hooks.claim("game_event:" + name) {
+				continue
+			}
+			schema := newGameEventSchema(d)
+			added = append(added, schema)
+			hooks.onGameEvent(name, func(e *manta.GameEvent) error {
+				(*wrote)++
+				record := map[string]any{
+					"kind":       "game_event",
+					"tick":       parser.Tick,
+					"event_name": schema.Name,
+					"fields":     schema.fields(e),
+				}
+				return out.add(parser.Tick, record, false)
+			})
+		}
+		if len(added) == 0 || !hooks.active() {
+			return nil
+		}
+		(*wrote)++
+		return out.add(parser.Tick, map[string]any{
+			"kind":   "game_event_schema",
+			"tick":   parser.Tick,
+			"events": added,
+		}, false)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/dotabuff/manta"
+)
+
+const (
+	teamRadiant = 2
+	teamDire    = 3
+)
+
+// m_iNetTimeOfDay runs over a 16-bit cycle; the sun rises at a quarter and
+// sets at three quarters of it.
+const (
+	timeOfDayCycle   = 65536
+	timeOfDaySunrise = timeOfDayCycle / 4
+	timeOfDaySunset  = timeOfDayCycle * 3 / 4
+)
+
+func findGameRules(parser *manta.Parser) *manta.Entity {
+	rules := parser.FilterEntity(func(e *manta.Entity) bool {
+		return e.GetClassName() == "CDOTAGamerulesProxy"
+	})
+	if len(rules) == 0 {
+		return nil
+	}
+	return rules[0]
+}
+
+func isNight(rules *manta.Entity) bool {
+	night, _ := nightState(rules)
+	return night
+}
+
+// nightState reports whether it is night and why: the regular cycle, or a
+// temporary day or night forced by an ability.
+func nightState(rules *manta.Entity) (bool, string) {
+	if rules == nil {
+		return false, ""
+	}
+	if v, _ := rules.GetBool("m_pGameRules.m_bIsTemporaryDay"); v {
+		return false, "temporary_day"
+	}
+	if v, _ := rules.GetBool("m_pGameRules.m_bIsNightstalkerNight"); v {
+		return true, "nightstalker"
+	}
+	if v, _ := rules.GetBool("m_pGameRules.m_bIsTemporaryNight"); v {
+		return true, "temporary_night"
+	}
+	tod, ok := rules.GetInt32("m_pGameRules.m_iNetTimeOfDay")
+	if !ok {
+		return false, ""
+	}
+	return tod < timeOfDaySunrise || tod >= timeOfDaySunset, "cycle"
+}
+
+func isAlive(e *manta.Entity) bool {
+	state, ok := e.GetInt32("m_lifeState")
+	return !ok || state == 0
+}
+
+// gameClock converts a game time (as carried in combat log timestamps) to
+// seconds since the horn, or returns the raw time before the game starts.
+// With -exclude-pauses, time paused since the horn is left out.
+func gameClock(rules *manta.Entity, gameTime float32) float32 {
+	if rules == nil {
+		return gameTime
+	}
+	start, ok := rules.GetFloat32("m_pGameRules.m_flGameStartTime")
+	if !ok || start <= 0 {
+		return gameTime
+	}
+	if pauseClock != nil && gameTime > start {
+		return gameTime - start - (pauseClock.excluded(gameTime) - pauseClock.excluded(start))
+	}
+	return gameTime - start
+}
+
+// gameRulesRef remembers the game rules proxy entity index so per-event
+// lookups do not scan every entity.
+type gameRulesRef struct {
+	parser *manta.Parser
+	index  int32
+}
+
+func newGameRulesRef(parser *manta.Parser) *gameRulesRef {
+	return &gameRulesRef{parser: parser, index: -1}
+}
+
+func (g *gameRulesRef) get() *manta.Entity {
+	if g.index >= 0 {
+		if e := g.parser.FindEntity(g.index); e != nil && e.GetClassName() == "CDOTAGamerulesProxy" {
+			return e
+		}
+	}
+	e := findGameRules(g.parser)
+	if e != nil {
+		g.index = e.GetIndex()
+	}
+	return e
+}
+
+func (g *gameRulesRef) clock(gameTime float32) float32 {
+	return gameClock(g.get(), gameTime)
+}
+
+// gameTime returns the raw game time, the timebase of ability cooldown
+// fields and combat log timestamps.
+func (g *gameRulesRef) gameTime() float32 {
+	rules := g.get()
+	if rules == nil {
+		return 0
+	}
+	t, _ := rules.GetFloat32("m_pGameRules.m_fGameTime")
+	return t
+}
+
+// now returns the current game clock from the game rules entity.
+func (g *gameRulesRef) now() float32 {
+	return g.clock(g.gameTime())
+}
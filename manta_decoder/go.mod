@@ -1,12 +1,17 @@
 module manta_decoder
 
-go 1.25
+go 1.25.0
 
-require github.com/dotabuff/manta v0.0.0-20260206214907-b92892d50d0f
+require (
+	github.com/dotabuff/manta v0.0.0-20260206214907-b92892d50d0f
+	github.com/klauspost/compress v1.20.1
+	github.com/tetratelabs/wazero v1.12.0
+	google.golang.org/protobuf v1.36.11
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/golang/protobuf v1.5.4 // indirect
 	github.com/golang/snappy v0.0.3 // indirect
-	google.golang.org/protobuf v1.36.11 // indirect
+	golang.org/x/sys v0.44.0 // indirect
 )
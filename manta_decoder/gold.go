@@ -0,0 +1,172 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// Values of EDOTA_ModifyGold_Reason carried in combat log gold_reason. The
+// bundled protos do not ship this enum.
+const (
+	goldReasonUnspecified        = 0
+	goldReasonDeath              = 1
+	goldReasonBuyback            = 2
+	goldReasonPurchaseConsumable = 3
+	goldReasonPurchaseItem       = 4
+	goldReasonAbandoned          = 5
+	goldReasonSellItem           = 6
+	goldReasonAbilityCost        = 7
+	goldReasonCheatCommand       = 8
+	goldReasonSelectionPenalty   = 9
+	goldReasonGameTick           = 10
+	goldReasonBuilding           = 11
+	goldReasonHeroKill           = 12
+	goldReasonCreepKill          = 13
+	goldReasonNeutralKill        = 14
+	goldReasonRoshanKill         = 15
+	goldReasonCourierKill        = 16
+	goldReasonBountyRune         = 17
+	goldReasonSharedGold         = 18
+	goldReasonAbilityGold        = 19
+	goldReasonWardKill           = 20
+	goldReasonCourierKilledBy    = 21
+)
+
+var goldReasonCategories = map[uint32]string{
+	goldReasonDeath:              "death",
+	goldReasonBuyback:            "buyback",
+	goldReasonPurchaseConsumable: "purchases",
+	goldReasonPurchaseItem:       "purchases",
+	goldReasonAbandoned:          "abandon",
+	goldReasonSellItem:           "sell",
+	goldReasonAbilityCost:        "abilities",
+	goldReasonSelectionPenalty:   "selection",
+	goldReasonGameTick:           "passive",
+	goldReasonBuilding:           "buildings",
+	goldReasonHeroKill:           "heroes",
+	goldReasonCreepKill:          "creeps",
+	goldReasonNeutralKill:        "neutrals",
+	goldReasonRoshanKill:         "roshan",
+	goldReasonCourierKill:        "couriers",
+	goldReasonBountyRune:         "bounty_runes",
+	goldReasonSharedGold:         "shared",
+	goldReasonAbilityGold:        "abilities",
+	goldReasonWardKill:           "wards",
+	goldReasonCourierKilledBy:    "couriers",
+}
+
+func goldReasonCategory(reason uint32) string {
+	if c, ok := goldReasonCategories[reason]; ok {
+		return c
+	}
+	return "other"
+}
+
+type goldTotals struct {
+	Earned    int64            `json:"earned"`
+	Spent     int64            `json:"spent"`
+	EarnedBy  map[string]int64 `json:"earned_by"`
+	SpentBy   map[string]int64 `json:"spent_by"`
+	Purchases map[string]int   `json:"purchases"`
+	Buybacks  int              `json:"buybacks"`
+}
+
+func newGoldTotals() *goldTotals {
+	return &goldTotals{
+		EarnedBy:  map[string]int64{},
+		SpentBy:   map[string]int64{},
+		Purchases: map[string]int{},
+	}
+}
+
+type goldStats struct {
+	parser  *manta.Parser
+	players map[string]*goldTotals
+}
+
+func newGoldStats(parser *manta.Parser) *goldStats {
+	return &goldStats{parser: parser, players: map[string]*goldTotals{}}
+}
+
+func (s *goldStats) totals(hero string) *goldTotals {
+	t, ok := s.players[hero]
+	if !ok {
+		t = newGoldTotals()
+		s.players[hero] = t
+	}
+	return t
+}
+
+func (s *goldStats) onCombatLog(m *dota.CMsgDOTACombatLogEntry) {
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_GOLD:
+		hero := lookupCombatLogName(s.parser, m.GetTargetName())
+		if hero == "" {
+			return
+		}
+		// Losses are logged as the two's complement of the amount.
+		value := int64(int32(m.GetValue()))
+		category := goldReasonCategory(m.GetGoldReason())
+		t := s.totals(hero)
+		if value < 0 {
+			t.Spent -= value
+			t.SpentBy[category] -= value
+		} else {
+			t.Earned += value
+			t.EarnedBy[category] += value
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_PURCHASE:
+		hero := lookupCombatLogName(s.parser, m.GetTargetName())
+		item := lookupCombatLogName(s.parser, m.GetValue())
+		if hero != "" && item != "" {
+			s.totals(hero).Purchases[item]++
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_BUYBACK:
+		hero := lookupCombatLogName(s.parser, m.GetTargetName())
+		if hero != "" {
+			s.totals(hero).Buybacks++
+		}
+	}
+}
+
+func (s *goldStats) emit(out *outputState, final bool, wrote *int) error {
+	names := make([]string, 0, len(s.players))
+	for h := range s.players {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+
+	for _, hero := range names {
+		record := map[string]any{
+			"kind":  "gold",
+			"tick":  s.parser.Tick,
+			"hero":  hero,
+			"final": final,
+			"gold":  s.players[hero],
+		}
+		(*wrote)++
+		if err := out.add(s.parser.Tick, record, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerGold(parser *manta.Parser, out *outputState, interval uint32, wrote *int) func() error {
+	stats := newGoldStats(parser)
+	sampler := newTickSampler(interval)
+	subscribeCombatLog(parser, func(m *dota.CMsgDOTACombatLogEntry) error {
+		if sampler.due(parser.Tick) {
+			if err := stats.emit(out, false, wrote); err != nil {
+				return err
+			}
+		}
+		stats.onCombatLog(m)
+		return nil
+	})
+	return func() error {
+		return stats.emit(out, true, wrote)
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// grepKinds are the event counters a -filter term can name. The glob after
+// the colon matches the ability, item, hero (for kill), modifier or game
+// event name; the optional @glob matches the caster, item user, buyer,
+// killer or the hero receiving the modifier.
+var grepKinds = map[string]bool{
+	"cast": true, "item": true, "purchase": true, "kill": true, "modifier": true, "event": true,
+}
+
+var grepOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// grepTerm is one `kind:glob[@hero] [op N]` comparison on a counter.
+type grepTerm struct {
+	kind   string
+	name   string
+	hero   string
+	op     string
+	value  int
+	source string
+}
+
+// grepFilter is an OR of AND groups, the usual precedence of && over ||.
+type grepFilter struct {
+	any [][]*grepTerm
+}
+
+func parseGrepTerm(s string) (*grepTerm, error) {
+	s = strings.TrimSpace(s)
+	t := &grepTerm{op: ">=", value: 1, source: s}
+	for _, op := range grepOps {
+		if i := strings.Index(s, op); i >= 0 {
+			n, err := strconv.Atoi(strings.TrimSpace(s[i+len(op):]))
+			if err != nil {
+				return nil, fmt.Errorf("%q: expected a number after %s", t.source, op)
+			}
+			t.op, t.value = op, n
+			s = strings.TrimSpace(s[:i])
+			break
+		}
+	}
+	kind, rest, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("%q: expected kind:name", t.source)
+	}
+	if !grepKinds[kind] {
+		return nil, fmt.Errorf("%q: unknown kind %q", t.source, kind)
+	}
+	t.kind = kind
+	t.name, t.hero, _ = strings.Cut(rest, "@")
+	if t.hero != "" && kind == "event" {
+		return nil, fmt.Errorf("%q: event terms take no @hero", t.source)
+	}
+	for _, g := range []string{t.name, t.hero} {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("%q: bad glob %q", t.source, g)
+		}
+	}
+	if t.name == "" {
+		return nil, fmt.Errorf("%q: empty name", t.source)
+	}
+	return t, nil
+}
+
+func parseGrepFilter(expr string) (*grepFilter, error) {
+	f := &grepFilter{}
+	for _, group := range strings.Split(expr, "||") {
+		var terms []*grepTerm
+		for _, s := range strings.Split(group, "&&") {
+			t, err := parseGrepTerm(s)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, t)
+		}
+		f.any = append(f.any, terms)
+	}
+	return f, nil
+}
+
+// monotonic reports whether the filter can only go from false to true as
+// counters grow, so a replay can stop at the first match.
+func (f *grepFilter) monotonic() bool {
+	for _, group := range f.any {
+		for _, t := range group {
+			if t.op != ">" && t.op != ">=" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *grepFilter) needsGameEvents() bool {
+	for _, group := range f.any {
+		for _, t := range group {
+			if t.kind == "event" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t *grepTerm) holds(count int) bool {
+	switch t.op {
+	case ">":
+		return count > t.value
+	case ">=":
+		return count >= t.value
+	case "<":
+		return count < t.value
+	case "<=":
+		return count <= t.value
+	case "==":
+		return count == t.value
+	}
+	return count != t.value
+}
+
+// matchName matches a glob against a name, also trying hero names without
+// their npc_dota_hero_ prefix so `@techies` works.
+func matchName(glob, name string) bool {
+	if glob == "" {
+		return true
+	}
+	if ok, _ := path.Match(glob, name); ok {
+		return true
+	}
+	if short, ok := strings.CutPrefix(name, "npc_dota_hero_"); ok {
+		m, _ := path.Match(glob, short)
+		return m
+	}
+	return false
+}
+
+// grepMatch is where a replay first satisfied the filter.
+type grepMatch struct {
+	tick  uint32
+	clock float32
+}
+
+// grepScan counts filter events in one replay.
+type grepScan struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	filter *grepFilter
+	counts map[*grepTerm]int
+	stop   bool
+	match  *grepMatch
+}
+
+func (g *grepScan) evaluate() bool {
+	for _, group := range g.filter.any {
+		ok := true
+		for _, t := range group {
+			if !t.holds(g.counts[t]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *grepScan) count(kind, name, hero string) {
+	changed := false
+	for _, group := range g.filter.any {
+		for _, t := range group {
+			if t.kind == kind && matchName(t.name, name) && matchName(t.hero, hero) {
+				g.counts[t]++
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+	if g.evaluate() {
+		if g.match == nil {
+			g.match = &grepMatch{tick: g.parser.Tick, clock: g.rules.now()}
+		}
+		if g.stop {
+			g.parser.Stop()
+		}
+	} else {
+		g.match = nil
+	}
+}
+
+func (g *grepScan) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	name := func(idx uint32) string { return lookupCombatLogName(g.parser, idx) }
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ABILITY:
+		g.count("cast", name(m.GetInflictorName()), name(m.GetAttackerName()))
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ITEM:
+		g.count("item", name(m.GetInflictorName()), name(m.GetAttackerName()))
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_PURCHASE:
+		g.count("purchase", name(m.GetValue()), name(m.GetTargetName()))
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		if m.GetIsTargetHero() && !m.GetIsTargetIllusion() && !m.GetWillReincarnate() {
+			g.count("kill", name(m.GetTargetName()), name(m.GetAttackerName()))
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD:
+		if m.GetIsTargetHero() && !m.GetIsTargetIllusion() {
+			g.count("modifier", name(m.GetInflictorName()), name(m.GetTargetName()))
+		}
+	}
+	return nil
+}
+
+// scanReplay parses one replay until the filter matches (when it can no
+// longer stop matching) or the replay ends.
+func scanReplay(dem string, filter *grepFilter, dedup bool) (*grepMatch, error) {
+	in, err := os.Open(dem)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	parser, err := manta.NewStreamParser(in)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCombatLog(parser)
+	if dedup {
+		dedupCombatLog(parser)
+	}
+	g := &grepScan{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		filter: filter,
+		counts: map[*grepTerm]int{},
+		stop:   filter.monotonic(),
+	}
+	extractors := newExtractorRegistry(parser)
+	extractors.definePausable("grep", nil, func(h *extractorHooks) func() error {
+		h.onCombatLog(g.onCombatLog)
+		return nil
+	})
+	extractors.definePausable("grep_events", nil, func(h *extractorHooks) func() error {
+		parser.Callbacks.OnCMsgSource1LegacyGameEventList(func(m *dota.CMsgSource1LegacyGameEventList) error {
+			for _, d := range m.GetDescriptors() {
+				name := d.GetName()
+				if name == "" || !h.claim("game_event:"+name) {
+					continue
+				}
+				h.onGameEvent(name, func(*manta.GameEvent) error {
+					g.count("event", name, "")
+					return nil
+				})
+			}
+			return nil
+		})
+		return nil
+	})
+	extractors.enable("grep")
+	if filter.needsGameEvents() {
+		extractors.enable("grep_events")
+	}
+	if err := extractors.install(); err != nil {
+		return nil, err
+	}
+	if err := parser.Start(); err != nil {
+		return nil, err
+	}
+	if !g.stop && g.evaluate() && g.match == nil {
+		// Only true with zero counts, e.g. `kill:*@techies == 0`.
+		g.match = &grepMatch{tick: parser.Tick, clock: g.rules.now()}
+	}
+	return g.match, nil
+}
+
+type grepResult struct {
+	dem   string
+	match *grepMatch
+	err   error
+}
+
+// runGrep implements `grep -filter EXPR replay.dem...`, printing each replay
+// that satisfies the filter with the tick and game clock where it first did.
+func runGrep(args []string) {
+	fs := flag.NewFlagSet("grep", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	expr := fs.String("filter", "", "condition on event counts, e.g. 'cast:techies_land_mines@techies > 40 && kill:*@techies >= 10'")
+	jobs := fs.Int("j", runtime.NumCPU(), "replays scanned in parallel")
+	invert := fs.Bool("invert", false, "print the replays that do not match instead")
+	dedup := fs.Bool("dedup", false, "drop combat log entries re-delivered by demo full packets before counting")
+	replays := global.parseArgs(fs, args, nil)
+
+	if *expr == "" {
+		fatal(exitUsage, "-filter is required")
+	}
+	filter, err := parseGrepFilter(*expr)
+	if err != nil {
+		fatal(exitUsage, "bad -filter", "err", err)
+	}
+	if len(replays) == 0 {
+		fatal(exitUsage, "at least one replay is required")
+	}
+	if *jobs < 1 {
+		*jobs = 1
+	}
+
+	results := make([]chan grepResult, len(replays))
+	for i := range results {
+		results[i] = make(chan grepResult, 1)
+	}
+	work := make(chan int)
+	for w := 0; w < *jobs; w++ {
+		go func() {
+			for i := range work {
+				match, err := scanReplay(replays[i], filter, *dedup)
+				results[i] <- grepResult{dem: replays[i], match: match, err: err}
+			}
+		}()
+	}
+	go func() {
+		for i := range replays {
+			work <- i
+		}
+		close(work)
+	}()
+
+	matched := 0
+	for _, ch := range results {
+		r := <-ch
+		if r.err != nil {
+			warn("scan replay", "replay", r.dem, "err", r.err)
+			continue
+		}
+		switch {
+		case *invert && r.match == nil:
+			fmt.Println(r.dem)
+		case !*invert && r.match != nil:
+			matched++
+			fmt.Printf("%s\ttick=%d\tclock=%s\n", r.dem, r.match.tick, formatGameClock(r.match.clock))
+		}
+	}
+	logger.Info("done", "replays", len(replays), "matched", matched)
+}
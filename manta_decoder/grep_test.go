@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGrepFilter(t *testing.T) {
+	tests := []struct {
+		expr      string
+		want      [][]grepTerm
+		monotonic bool
+		events    bool
+		err       string
+	}{
+		{
+			expr:      "cast:techies_*",
+			want:      [][]grepTerm{{{kind: "cast", name: "techies_*", op: ">=", value: 1}}},
+			monotonic: true,
+		},
+		{
+			expr: "kill:*@lina >= 3 && item:item_blink < 2 || event:roshan_kill",
+			want: [][]grepTerm{
+				{{kind: "kill", name: "*", hero: "lina", op: ">=", value: 3}, {kind: "item", name: "item_blink", op: "<", value: 2}},
+				{{kind: "event", name: "roshan_kill", op: ">=", value: 1}},
+			},
+			events: true,
+		},
+		{
+			expr:      "purchase:item_gem>2||modifier:modifier_smoke*==0",
+			want:      [][]grepTerm{{{kind: "purchase", name: "item_gem", op: ">", value: 2}}, {{kind: "modifier", name: "modifier_smoke*", op: "==", value: 0}}},
+			monotonic: false,
+		},
+		{expr: "cast", err: "expected kind:name"},
+		{expr: "ward:obs", err: `unknown kind "ward"`},
+		{expr: "cast: >= 2", err: "empty name"},
+		{expr: "cast:x >= many", err: "expected a number after >="},
+		{expr: "event:roshan_kill@axe", err: "event terms take no @hero"},
+		{expr: "cast:[x", err: "bad glob"},
+		{expr: "cast:x && ", err: "expected kind:name"},
+	}
+	for _, tt := range tests {
+		f, err := parseGrepFilter(tt.expr)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error = %v, want %q", tt.expr, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		if len(f.any) != len(tt.want) {
+			t.Errorf("%q: %d groups, want %d", tt.expr, len(f.any), len(tt.want))
+			continue
+		}
+		for i, group := range f.any {
+			if len(group) != len(tt.want[i]) {
+				t.Errorf("%q: group %d has %d terms, want %d", tt.expr, i, len(group), len(tt.want[i]))
+				continue
+			}
+			for j, term := range group {
+				got, want := *term, tt.want[i][j]
+				got.source = ""
+				if got != want {
+					t.Errorf("%q: term %d.%d = %+v, want %+v", tt.expr, i, j, got, want)
+				}
+			}
+		}
+		if f.monotonic() != tt.monotonic {
+			t.Errorf("%q: monotonic = %v, want %v", tt.expr, f.monotonic(), tt.monotonic)
+		}
+		if f.needsGameEvents() != tt.events {
+			t.Errorf("%q: needsGameEvents = %v, want %v", tt.expr, f.needsGameEvents(), tt.events)
+		}
+	}
+}
+
+func TestGrepTermHolds(t *testing.T) {
+	tests := []struct {
+		op    string
+		count int
+		want  bool
+	}{
+		{">", 3, true}, {">", 2, false},
+		{">=", 2, true}, {">=", 1, false},
+		{"<", 1, true}, {"<", 2, false},
+		{"<=", 2, true}, {"<=", 3, false},
+		{"==", 2, true}, {"==", 3, false},
+		{"!=", 3, true}, {"!=", 2, false},
+	}
+	for _, tt := range tests {
+		term := &grepTerm{op: tt.op, value: 2}
+		if got := term.holds(tt.count); got != tt.want {
+			t.Errorf("%d %s 2 = %v, want %v", tt.count, tt.op, got, tt.want)
+		}
+	}
+}
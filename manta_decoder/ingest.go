@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ingestTokenEnv holds a bearer token for -ingest, so it need not appear in
+// argv or a config file.
+const ingestTokenEnv = "FAETON_INGEST_TOKEN"
+
+const (
+	ingestRetryBase = 500 * time.Millisecond
+	ingestRetryMax  = 30 * time.Second
+)
+
+// ingestError marks a failure to deliver records to the -ingest endpoint so
+// it exits with exitSink rather than as a bad replay.
+type ingestError struct{ err error }
+
+func (e *ingestError) Error() string { return "ingest: " + e.err.Error() }
+func (e *ingestError) Unwrap() error { return e.err }
+
+// ingestBatch is one POST body: zstd-compressed JSONL, with the
+// -ingest-dict dictionary when one is given.
+type ingestBatch struct {
+	seq     int
+	records int
+	body    []byte
+}
+
+// ingestEncoder ships records to a collector in batches of up to batchSize
+// records. A single sender posts batches in order; once queue batches are
+// waiting, Encode blocks, which holds the parser back until the collector
+// catches up. Records also go to inner when it is set.
+type ingestEncoder struct {
+	inner     recordEncoder
+	url       string
+	headers   http.Header
+	client    *http.Client
+	retries   int
+	batchSize int
+
+	buf     bytes.Buffer
+	zw      *zstd.Encoder
+	enc     *json.Encoder
+	pending int
+	seq     int
+
+	queue chan ingestBatch
+	done  chan error
+	// failed is closed when the sender gives up, so Encode stops queueing.
+	failed chan struct{}
+	err    error
+
+	sent    int
+	batches int
+}
+
+func newIngestEncoder(inner recordEncoder, url string, headers []string, replay string, dict []byte, batchSize, queue, retries int, timeout time.Duration) (*ingestEncoder, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("-ingest %q: expected an http:// or https:// URL", url)
+	}
+	if batchSize < 1 || queue < 1 {
+		return nil, fmt.Errorf("-ingest-batch and -ingest-queue must be at least 1")
+	}
+	h := http.Header{}
+	for _, kv := range headers {
+		name, value, ok := strings.Cut(kv, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("-ingest-header %q: expected Name: value", kv)
+		}
+		h.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if token := os.Getenv(ingestTokenEnv); token != "" && h.Get("Authorization") == "" {
+		h.Set("Authorization", "Bearer "+token)
+	}
+	h.Set("Content-Type", "application/x-ndjson")
+	h.Set("Content-Encoding", "zstd")
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if dict != nil {
+		// The collector looks the dictionary up by the ID it also finds in
+		// each frame header.
+		id, err := dictionaryID(dict)
+		if err != nil {
+			return nil, fmt.Errorf("-ingest-dict: %w", err)
+		}
+		h.Set("X-Faeton-Dictionary", strconv.FormatUint(uint64(id), 10))
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	h.Set("X-Faeton-Replay", filepath.Base(replay))
+	zw, err := zstd.NewWriter(nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("-ingest-dict: %w", err)
+	}
+
+	i := &ingestEncoder{
+		inner:     inner,
+		url:       url,
+		headers:   h,
+		client:    &http.Client{Timeout: timeout},
+		retries:   retries,
+		batchSize: batchSize,
+		zw:        zw,
+		queue:     make(chan ingestBatch, queue),
+		done:      make(chan error, 1),
+		failed:    make(chan struct{}),
+	}
+	i.reset()
+	go func() { i.done <- i.send() }()
+	return i, nil
+}
+
+func (i *ingestEncoder) reset() {
+	i.buf.Reset()
+	i.zw.Reset(&i.buf)
+	i.enc = json.NewEncoder(i.zw)
+	i.pending = 0
+}
+
+func (i *ingestEncoder) Encode(v any) error {
+	if i.inner != nil {
+		if err := i.inner.Encode(v); err != nil {
+			return err
+		}
+	}
+	if err := i.enc.Encode(v); err != nil {
+		return err
+	}
+	i.pending++
+	if i.pending >= i.batchSize {
+		return i.flush()
+	}
+	return nil
+}
+
+// flush hands the current batch to the sender, waiting while the queue is
+// full.
+func (i *ingestEncoder) flush() error {
+	if i.pending == 0 {
+		return nil
+	}
+	if err := i.zw.Close(); err != nil {
+		return err
+	}
+	i.seq++
+	batch := ingestBatch{seq: i.seq, records: i.pending, body: bytes.Clone(i.buf.Bytes())}
+	i.reset()
+	select {
+	case i.queue <- batch:
+		return nil
+	case <-i.failed:
+		return i.err
+	}
+}
+
+// send posts queued batches in order until the queue is closed or a batch
+// cannot be delivered.
+func (i *ingestEncoder) send() error {
+	for batch := range i.queue {
+		if err := i.post(batch); err != nil {
+			i.err = &ingestError{err}
+			close(i.failed)
+			for range i.queue {
+			}
+			return i.err
+		}
+		i.sent += batch.records
+		i.batches++
+	}
+	return nil
+}
+
+// post delivers one batch, retrying network errors, 429 and 5xx responses
+// with exponential backoff (or the server's Retry-After, capped at ingestRetryMax).
+func (i *ingestEncoder) post(batch ingestBatch) error {
+	var last error
+	for attempt := 0; attempt <= i.retries; attempt++ {
+		if attempt > 0 {
+			logger.Debug("retrying ingest batch", "batch", batch.seq, "attempt", attempt, "err", last)
+		}
+		retryAfter, err := i.postOnce(batch)
+		if err == nil {
+			return nil
+		}
+		var permanent *ingestRejected
+		if errors.As(err, &permanent) {
+			return err
+		}
+		last = err
+		if attempt == i.retries {
+			break
+		}
+		time.Sleep(retryDelay(attempt, retryAfter))
+	}
+	return fmt.Errorf("batch %d: giving up after %d attempts: %w", batch.seq, i.retries+1, last)
+}
+
+// retryDelay is the wait before retry attempt+1: exponential backoff, or the
+// server's Retry-After, never more than ingestRetryMax so a misbehaving
+// server cannot stall the sender.
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	delay := ingestRetryBase << attempt
+	if retryAfter > 0 {
+		delay = retryAfter
+	}
+	if delay > ingestRetryMax || delay <= 0 {
+		delay = ingestRetryMax
+	}
+	return delay
+}
+
+// ingestRejected is a 4xx answer other than 429, which retrying will not fix.
+type ingestRejected struct {
+	status string
+	body   string
+}
+
+func (e *ingestRejected) Error() string {
+	if e.body == "" {
+		return "rejected: " + e.status
+	}
+	return fmt.Sprintf("rejected: %s: %s", e.status, e.body)
+}
+
+func (i *ingestEncoder) postOnce(batch ingestBatch) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, i.url, bytes.NewReader(batch.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header = i.headers.Clone()
+	req.Header.Set("X-Faeton-Batch", strconv.Itoa(batch.seq))
+	req.Header.Set("X-Faeton-Records", strconv.Itoa(batch.records))
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		var wait time.Duration
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait = ingestRetryMax
+			if s < int(ingestRetryMax/time.Second) {
+				wait = time.Duration(s) * time.Second
+			}
+		}
+		return wait, fmt.Errorf("%s", resp.Status)
+	default:
+		return 0, &ingestRejected{status: resp.Status, body: strings.TrimSpace(string(msg))}
+	}
+}
+
+// close sends the last partial batch and waits until every batch has been
+// delivered.
+func (i *ingestEncoder) close() error {
+	err := i.flush()
+	close(i.queue)
+	if sendErr := <-i.done; sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("ingest finished", "records", i.sent, "batches", i.batches)
+	return nil
+}
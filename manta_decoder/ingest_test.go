@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempt    int
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{0, 0, ingestRetryBase},
+		{1, 0, 2 * ingestRetryBase},
+		{3, 0, 8 * ingestRetryBase},
+		{10, 0, ingestRetryMax},
+		{70, 0, ingestRetryMax},
+		{0, 5 * time.Second, 5 * time.Second},
+		{4, time.Second, time.Second},
+		{0, time.Hour, ingestRetryMax},
+		{0, -time.Second, ingestRetryBase},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.attempt, tt.retryAfter); got != tt.want {
+			t.Errorf("retryDelay(%d, %v) = %v, want %v", tt.attempt, tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestIngestZstdBatches(t *testing.T) {
+	samples := dictSamples(64)
+	dict, err := buildZstdDictionary(trainDictionary(samples, 4096, 64), samples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := dictionaryID(dict)
+	for _, tt := range []struct {
+		name string
+		dict []byte
+	}{{"plain", nil}, {"dictionary", dict}} {
+		var mu sync.Mutex
+		records := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if enc := r.Header.Get("Content-Encoding"); enc != "zstd" {
+				t.Errorf("%s: Content-Encoding %q", tt.name, enc)
+			}
+			var opts []zstd.DOption
+			if tt.dict != nil {
+				if got := r.Header.Get("X-Faeton-Dictionary"); got != fmt.Sprint(id) {
+					t.Errorf("%s: X-Faeton-Dictionary %q, want %d", tt.name, got, id)
+				}
+				opts = append(opts, zstd.WithDecoderDicts(tt.dict))
+			}
+			zr, err := zstd.NewReader(r.Body, opts...)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer zr.Close()
+			sc := bufio.NewScanner(zr)
+			mu.Lock()
+			for sc.Scan() {
+				records++
+			}
+			mu.Unlock()
+			if err := sc.Err(); err != nil {
+				t.Errorf("%s: decode batch: %v", tt.name, err)
+			}
+			io.Copy(io.Discard, r.Body)
+		}))
+		enc, err := newIngestEncoder(nil, srv.URL, nil, "a.dem", tt.dict, 3, 1, 0, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if err := enc.Encode(map[string]any{"kind": "cast", "tick": i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.close(); err != nil {
+			t.Errorf("%s: close: %v", tt.name, err)
+		}
+		srv.Close()
+		if records != 10 || enc.batches != 4 {
+			t.Errorf("%s: collector got %d records in %d batches, want 10 in 4", tt.name, records, enc.batches)
+		}
+	}
+	if _, err := newIngestEncoder(nil, "http://localhost/", nil, "a.dem", []byte("not a dictionary"), 1, 1, 0, time.Second); err == nil {
+		t.Error("a raw-content -ingest-dict was accepted")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+// inventoryArea names the part of a unit's m_hItems array a slot is in.
+func inventoryArea(slot int) string {
+	switch {
+	case slot < 6:
+		return "inventory"
+	case slot < 9:
+		return "backpack"
+	case slot < 15:
+		return "stash"
+	case slot == 15:
+		return "teleport"
+	}
+	return "neutral"
+}
+
+func isCourierEntity(e *manta.Entity) bool {
+	return strings.HasPrefix(e.GetClassName(), "CDOTA_Unit_Courier")
+}
+
+// itemSlotFields are the m_hItems field paths, built once since inventories
+// are read on every hero update.
+var itemSlotFields = func() []string {
+	fields := make([]string, maxItemSlots)
+	for i := range fields {
+		fields[i] = fmt.Sprintf("m_hItems.%04d", i)
+	}
+	return fields
+}()
+
+// heldItem is an item entity in a slot, named when it was first seen since
+// a removed item's entity may already be gone.
+type heldItem struct {
+	handle uint32
+	name   string
+}
+
+// itemLocation is where an item entity was last seen.
+type itemLocation struct {
+	unit int32
+	slot int
+}
+
+// inventoryTracker diffs the item slots of heroes and couriers on every
+// update and writes one inventory record per added, removed or moved item.
+type inventoryTracker struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	units  *unitClassifier
+	// slots holds each unit's items by slot as last seen.
+	slots map[int32]map[int]heldItem
+	// seen is the last location of each item handle, to tell items passed
+	// between units (a courier delivery) from new ones.
+	seen map[uint32]itemLocation
+}
+
+// items reads e's item slots, reusing the names already known from prev.
+func (t *inventoryTracker) items(e *manta.Entity, prev map[int]heldItem) map[int]heldItem {
+	items := map[int]heldItem{}
+	for i, field := range itemSlotFields {
+		h, ok := e.GetUint32(field)
+		if !ok {
+			break
+		}
+		if h == invalidHandle {
+			continue
+		}
+		if p, ok := prev[i]; ok && p.handle == h {
+			items[i] = p
+			continue
+		}
+		name := ""
+		if item := t.parser.FindEntityByHandle(uint64(h)); item != nil {
+			name = entityUnitName(t.parser, item)
+		}
+		items[i] = heldItem{handle: h, name: name}
+	}
+	return items
+}
+
+// courierOwner returns the hero of the player a courier belongs to.
+func (t *inventoryTracker) courierOwner(e *manta.Entity) string {
+	if h, ok := e.GetUint32("m_hOwnerEntity"); ok && h != invalidHandle {
+		if o := t.parser.FindEntityByHandle(uint64(h)); o != nil && isHeroEntity(o) {
+			return entityUnitName(t.parser, o)
+		}
+	}
+	if id, ok := e.GetInt32("m_nPlayerOwnerID"); ok {
+		return heroNamesByPlayerID(t.parser)[id]
+	}
+	return ""
+}
+
+// unitInfo returns the unit type and the hero an inventory belongs to, or
+// ok false for units whose inventory is not followed.
+func (t *inventoryTracker) unitInfo(e *manta.Entity) (unit, hero string, ok bool) {
+	if isCourierEntity(e) {
+		return "courier", t.courierOwner(e), true
+	}
+	if !isHeroEntity(e) || isIllusionEntity(e) {
+		return "", "", false
+	}
+	unit, hero, ok = t.units.classify(e)
+	return unit, hero, ok
+}
+
+func (t *inventoryTracker) emit(change, unit, hero, item string, slot int, extra map[string]any) error {
+	rec := map[string]any{
+		"kind":   "inventory",
+		"tick":   t.parser.Tick,
+		"time":   t.rules.now(),
+		"change": change,
+		"unit":   unit,
+		"hero":   hero,
+		"item":   item,
+		"slot":   slot,
+		"area":   inventoryArea(slot),
+	}
+	for k, v := range extra {
+		rec[k] = v
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func (t *inventoryTracker) onEntity(e *manta.Entity, op manta.EntityOp) error {
+	idx := e.GetIndex()
+	if op.Flag(manta.EntityOpDeleted) {
+		delete(t.slots, idx)
+		return nil
+	}
+	unit, hero, ok := t.unitInfo(e)
+	if !ok {
+		return nil
+	}
+	prev := t.slots[idx]
+	cur := t.items(e, prev)
+	t.slots[idx] = cur
+
+	prevSlot := map[uint32]int{}
+	for slot, it := range prev {
+		prevSlot[it.handle] = slot
+	}
+	curSlot := map[uint32]int{}
+	for slot, it := range cur {
+		curSlot[it.handle] = slot
+	}
+	for _, slot := range sortedSlots(cur) {
+		it := cur[slot]
+		from, held := prevSlot[it.handle]
+		switch {
+		case held && from == slot:
+			continue
+		case held:
+			if err := t.emit("moved", unit, hero, it.name, slot, map[string]any{
+				"from_slot": from,
+				"from_area": inventoryArea(from),
+			}); err != nil {
+				return err
+			}
+		default:
+			extra := map[string]any{}
+			if item := t.parser.FindEntityByHandle(uint64(it.handle)); item != nil {
+				if charges, ok := item.GetInt32("m_iCurrentCharges"); ok && charges > 0 {
+					extra["charges"] = charges
+				}
+			}
+			if loc, ok := t.seen[it.handle]; ok && loc.unit != idx {
+				if src := t.parser.FindEntity(loc.unit); src != nil {
+					extra["from_unit"], extra["from_hero"], _ = t.unitInfo(src)
+				}
+				extra["from_slot"] = loc.slot
+			}
+			if err := t.emit("added", unit, hero, it.name, slot, extra); err != nil {
+				return err
+			}
+		}
+		t.seen[it.handle] = itemLocation{unit: idx, slot: slot}
+	}
+	for _, slot := range sortedSlots(prev) {
+		it := prev[slot]
+		if _, kept := curSlot[it.handle]; kept {
+			continue
+		}
+		if err := t.emit("removed", unit, hero, it.name, slot, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedSlots(items map[int]heldItem) []int {
+	slots := make([]int, 0, len(items))
+	for slot := range items {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+func registerInventory(parser *manta.Parser, out *outputState, wrote *int) {
+	t := &inventoryTracker{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		units:  newUnitClassifier(parser),
+		slots:  map[int32]map[int]heldItem{},
+		seen:   map[uint32]itemLocation{},
+	}
+	parser.OnEntity(t.onEntity)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+func creepType(name string) string {
+	switch {
+	case strings.HasPrefix(name, "npc_dota_neutral_"), strings.HasPrefix(name, "npc_dota_creep_neutral"):
+		return "neutral"
+	case strings.Contains(name, "siege"):
+		if strings.Contains(name, "goodguys") || strings.Contains(name, "badguys") {
+			return "siege"
+		}
+	case strings.HasPrefix(name, "npc_dota_creep_goodguys_"), strings.HasPrefix(name, "npc_dota_creep_badguys_"):
+		return "lane"
+	}
+	return ""
+}
+
+type csMinute struct {
+	LastHits int            `json:"last_hits"`
+	Denies   int            `json:"denies"`
+	ByType   map[string]int `json:"by_type"`
+}
+
+type lastHitStats struct {
+	parser  *manta.Parser
+	rules   *gameRulesRef
+	wrote   *int
+	out     *outputState
+	minutes map[string]map[int]*csMinute
+}
+
+func (s *lastHitStats) minute(hero string, m int) *csMinute {
+	byMinute, ok := s.minutes[hero]
+	if !ok {
+		byMinute = map[int]*csMinute{}
+		s.minutes[hero] = byMinute
+	}
+	c, ok := byMinute[m]
+	if !ok {
+		c = &csMinute{ByType: map[string]int{}}
+		byMinute[m] = c
+	}
+	return c
+}
+
+func (s *lastHitStats) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH {
+		return nil
+	}
+	creep := lookupCombatLogName(s.parser, m.GetTargetName())
+	kind := creepType(creep)
+	if kind == "" {
+		return nil
+	}
+	// Summons and controlled units last hit for the hero that owns them.
+	hero := creditedHero(s.parser, m)
+	if hero == "" {
+		return nil
+	}
+	deny := m.GetAttackerTeam() == m.GetTargetTeam()
+	clock := s.rules.clock(m.GetTimestamp())
+	pos := mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())}
+
+	c := s.minute(hero, int(math.Floor(float64(clock)/60)))
+	if deny {
+		c.Denies++
+	} else {
+		c.LastHits++
+		c.ByType[kind]++
+	}
+
+	record := map[string]any{
+		"kind":       "last_hit",
+		"tick":       s.parser.Tick,
+		"time":       clock,
+		"hero":       hero,
+		"creep":      creep,
+		"creep_type": kind,
+		"deny":       deny,
+		"world":      pos,
+		"minimap":    pos.Minimap(),
+	}
+	(*s.wrote)++
+	return s.out.add(s.parser.Tick, record, false)
+}
+
+func (s *lastHitStats) emitMinutes() error {
+	heroes := make([]string, 0, len(s.minutes))
+	for h := range s.minutes {
+		heroes = append(heroes, h)
+	}
+	sort.Strings(heroes)
+
+	for _, hero := range heroes {
+		minutes := make([]int, 0, len(s.minutes[hero]))
+		for m := range s.minutes[hero] {
+			minutes = append(minutes, m)
+		}
+		sort.Ints(minutes)
+		for _, m := range minutes {
+			c := s.minutes[hero][m]
+			record := map[string]any{
+				"kind":      "cs",
+				"tick":      s.parser.Tick,
+				"hero":      hero,
+				"minute":    m,
+				"last_hits": c.LastHits,
+				"denies":    c.Denies,
+				"by_type":   c.ByType,
+			}
+			(*s.wrote)++
+			if err := s.out.add(s.parser.Tick, record, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func registerLastHits(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	stats := &lastHitStats{
+		parser:  parser,
+		rules:   newGameRulesRef(parser),
+		wrote:   wrote,
+		out:     out,
+		minutes: map[string]map[int]*csMinute{},
+	}
+	subscribeCombatLog(parser, stats.onCombatLog)
+	return stats.emitMinutes
+}
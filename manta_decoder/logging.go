@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// logger is the process-wide structured logger. Subcommands narrow it with
+// command and replay fields so batch runs stay attributable.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// setupLogging installs the handler chosen by -v and -log-format.
+func setupLogging(verbose bool, format string) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if verbose {
+		opts.Level = slog.LevelDebug
+	}
+	switch format {
+	case "text":
+		logger = slog.New(slog.NewTextHandler(os.Stderr, opts))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	default:
+		return fmt.Errorf("unknown -log-format %q", format)
+	}
+	return nil
+}
+
+// Exit codes, documented in the README. Anything else is a Go runtime
+// failure.
+const (
+	exitOK            = 0
+	exitUsage         = 1 // bad flags, arguments or config
+	exitWarnings      = 2 // finished, but logged recoverable warnings
+	exitInvalidReplay = 3 // the replay (or an input file) could not be parsed
+	exitIO            = 4 // reading or writing a file failed
+	exitStrict        = 5 // -strict turned a warning into a failure
+	exitHook          = 6 // a -plugin or -wasm hook failed
+	exitSink          = 7 // the -ingest endpoint did not accept the records
+)
+
+var (
+	// strictMode is set by -strict.
+	strictMode bool
+	warnings   int
+)
+
+// fatal logs at error level and exits with code.
+func fatal(code int, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(code)
+}
+
+// parseExitCode classifies an error from parser.Start: callbacks that fail
+// to write output surface there too, and those are I/O or sink errors rather
+// than a bad replay.
+func parseExitCode(err error) int {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return exitIO
+	}
+	var sinkErr *ingestError
+	if errors.As(err, &sinkErr) {
+		return exitSink
+	}
+	return exitInvalidReplay
+}
+
+// warn reports a recoverable problem: output is still produced, but the
+// run exits with exitWarnings, or fails at once under -strict.
+func warn(msg string, args ...any) {
+	if strictMode {
+		fatal(exitStrict, msg, append(args, "strict", true)...)
+	}
+	warnings++
+	logger.Warn(msg, args...)
+}
+
+// exitStatus is the code for a run that reached its end.
+func exitStatus() int {
+	if warnings > 0 {
+		return exitWarnings
+	}
+	return exitOK
+}
@@ -4,28 +4,44 @@ import (
 	"encoding/json"
 	"flag"
 	"io"
-	"log"
 	"os"
 	"reflect"
 	"unicode/utf8"
 	"strings"
+	"time"
 
 	"github.com/dotabuff/manta"
 	"github.com/dotabuff/manta/dota"
+	"google.golang.org/protobuf/proto"
 )
 
 var errorType = reflect.TypeOf((*error)(nil)).Elem()
 
+type recordEncoder interface {
+	Encode(v any) error
+}
+
 type outputState struct {
-	encoder       *json.Encoder
+	encoder       recordEncoder
 	eclipseOnly   bool
+	names         *displayNames
+	// context, when set, adds a "context" snapshot to matched records.
+	context *stateContext
+	// dayNight, when set, adds the day/night state to interval records.
+	dayNight *dayNightTracker
+	// phases, when set, tags every record with the match phase.
+	phases *phaseTracker
 	hasTick       bool
 	currentTick   uint32
 	currentBuffer []map[string]any
 	tickMatched   bool
+	// build, when set by -force, tags every record with the replay build.
+	build uint32
+	// droppedBinary counts raw callbacks skipped for unreadable payloads.
+	droppedBinary int
 }
 
-func newOutputState(enc *json.Encoder, eclipseOnly bool) *outputState {
+func newOutputState(enc recordEncoder, eclipseOnly bool) *outputState {
 	return &outputState{
 		encoder:     enc,
 		eclipseOnly: eclipseOnly,
@@ -33,6 +49,21 @@ func newOutputState(enc *json.Encoder, eclipseOnly bool) *outputState {
 }
 
 func (o *outputState) add(tick uint32, rec map[string]any, matches bool) error {
+	if matches && o.context != nil {
+		if ctx := o.context.snapshot(rec); ctx != nil {
+			rec["context"] = ctx
+		}
+	}
+	if o.dayNight != nil {
+		o.dayNight.annotate(rec)
+	}
+	if o.phases != nil {
+		o.phases.annotate(rec)
+	}
+	o.names.annotate(rec)
+	if o.build != 0 {
+		rec["build"] = o.build
+	}
 	if !o.eclipseOnly {
 		return o.encoder.Encode(rec)
 	}
@@ -77,13 +108,16 @@ func (o *outputState) flushFinal() error {
 }
 
 func lookupCombatLogName(parser *manta.Parser, idx uint32) string {
+	return canonicalName(lookupRawCombatLogName(parser, idx))
+}
+
+// lookupRawCombatLogName returns the CombatLogNames entry as is, for
+// extractors that tell drop variants apart before they are aliased.
+func lookupRawCombatLogName(parser *manta.Parser, idx uint32) string {
 	if idx == 0 {
 		return ""
 	}
-	name, ok := parser.LookupStringByIndex("CombatLogNames", int32(idx))
-	if !ok {
-		return ""
-	}
+	name, _ := parser.LookupStringByIndex("CombatLogNames", int32(idx))
 	return name
 }
 
@@ -169,9 +203,10 @@ func hasUnreadableBinaryPayload(v reflect.Value, depth int) bool {
 func registerAllCallbacks(
 	parser *manta.Parser,
 	out *outputState,
-	registered *map[string]bool,
+	hooks *extractorHooks,
 	wrote *int,
 	includeBinary bool,
+	dedup *messageDedup,
 ) {
 	cbValue := reflect.ValueOf(parser.Callbacks)
 	cbType := cbValue.Type()
@@ -195,13 +230,15 @@ func registerAllCallbacks(
 			continue
 		}
 
-		if (*registered)[methodName] {
+		if !hooks.claim("callback:" + methodName) {
 			continue
 		}
-		(*registered)[methodName] = true
 
 		eventLabel := strings.TrimPrefix(methodName, "On")
 		handler := reflect.MakeFunc(fnType, func(args []reflect.Value) []reflect.Value {
+			if !hooks.active() {
+				return []reflect.Value{reflect.Zero(errorType)}
+			}
 			if !includeBinary &&
 				(strings.HasPrefix(eventLabel, "CNETMsg_") ||
 					strings.HasPrefix(eventLabel, "CSVCMsg_") ||
@@ -210,9 +247,16 @@ func registerAllCallbacks(
 			}
 
 			payload := args[0].Interface()
+			if dedup != nil && dedup.duplicate(parser.Tick, eventLabel, payload) {
+				return []reflect.Value{reflect.Zero(errorType)}
+			}
 			if !includeBinary && hasUnreadableBinaryPayload(reflect.ValueOf(payload), 0) {
+				out.droppedBinary++
 				return []reflect.Value{reflect.Zero(errorType)}
 			}
+			if msg, ok := payload.(proto.Message); ok {
+				payload = canonicalPayload(msg)
+			}
 
 			(*wrote)++
 			matches := false
@@ -238,72 +282,460 @@ func registerAllCallbacks(
 }
 
 func main() {
-	demPath := flag.String("dem", "", "path to replay .dem file")
-	outPath := flag.String("out", "-", "output path (.jsonl), or '-' for stdout")
-	eclipseOnly := flag.Bool("eclipse", false, "only output events for ticks where Luna casts Eclipse")
-	includeBinary := flag.Bool("include-binary", false, "include callbacks with unreadable binary payload bytes")
-	flag.Parse()
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") || strings.HasSuffix(os.Args[1], ".dem") {
+		runEvents("events", os.Args[1:], nil)
+		os.Exit(exitStatus())
+	}
+	if os.Args[1] == "help" {
+		printUsage()
+		return
+	}
+	cmd := findSubcommand(os.Args[1])
+	if cmd == nil {
+		printUsage()
+		os.Exit(exitUsage)
+	}
+	cmd.run(os.Args[2:])
+	os.Exit(exitStatus())
+}
 
+// runEvents decodes one replay. presets fill flags left unset by both the
+// command line and -config, so convenience subcommands reuse this path.
+func runEvents(name string, args []string, presets map[string]string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	demPath := fs.String("dem", "", "path to replay .dem file")
+	outPath := fs.String("out", "-", "output path (.jsonl), or '-' for stdout")
+	eclipseOnly := fs.Bool("eclipse", false, "only output events for ticks where Luna casts Eclipse")
+	includeBinary := fs.Bool("include-binary", false, "include callbacks with unreadable binary payload bytes")
+	positions := fs.Uint("positions", 0, "emit hero position records (world and minimap coordinates) every N ticks; 0 disables")
+	positionSummons := fs.Bool("positions-summons", false, "with -positions, also emit positions of units owned by heroes (summons, dominated creeps)")
+	vision := fs.Uint("vision", 0, "emit per-team vision coverage estimates every N ticks; 0 disables")
+	visionRaster := fs.Bool("vision-raster", false, "include the coverage raster in vision records")
+	damage := fs.Bool("damage", false, "emit per-hero damage dealt/received breakdowns at the end of the replay")
+	damageInterval := fs.Uint("damage-interval", 0, "with -damage, also emit running damage snapshots every N ticks")
+	gold := fs.Bool("gold", false, "emit per-hero gold earned/spent breakdowns by reason at the end of the replay")
+	goldInterval := fs.Uint("gold-interval", 0, "with -gold, also emit running gold snapshots every N ticks")
+	cs := fs.Bool("cs", false, "emit last hit/deny events with creep type and location, plus per-minute CS totals")
+	cc := fs.Bool("cc", false, "emit stun/root/silence/hex events with per-fight and per-game uptime totals")
+	fights := fs.Bool("fights", false, "emit a fight record for every skirmish that ends with a hero death")
+	participation := fs.Bool("participation", false, "emit per-fight and per-game hero participation and kill involvement")
+	winProb := fs.Uint("winprob", 0, "emit win-probability feature vectors every N ticks, plus the schema and final label")
+	format := fs.String("format", "json", "record format: json (raw callbacks and game events), opendota, clarity or text (combat log lines)")
+	roster := fs.Bool("roster", false, "emit a roster record per player (hero, facet, abilities with innate/facet flags) at the end of the replay")
+	aghs := fs.Bool("aghs", false, "emit Aghanim's Scepter/Shard acquisition timings, sold, stashed or dropped upgrade items, and hero ability casts flagged with upgrade state")
+	dives := fs.Bool("dives", false, "emit a dive record for hero deaths under enemy towers, with divers, tower damage and outcome")
+	rotations := fs.Bool("rotations", false, "emit rotation records when heroes leave their lane for another lane or the enemy jungle, with path and outcome")
+	camps := fs.Bool("camps", false, "emit neutral camp stack and lane creep pull events, plus per-hero stack/pull counts")
+	teleports := fs.Bool("teleports", false, "emit teleport start/finish/cancel events with origin, destination and item")
+	objectives := fs.Bool("objectives", false, "emit Tormentor spawn/kill and lotus pickup records")
+	buildingHP := fs.Uint("building-hp", 0, "emit building_hp records for every standing tower, barracks and ancient every N ticks, plus backdoor_protection transitions; 0 disables")
+	dayNight := fs.Bool("daynight", false, "emit day_night transition records (including Nightstalker and temporary day/night) and add the day/night state to fight, dive and other interval records")
+	micro := fs.Uint("micro", 0, "every N ticks, emit unit_control records comparing the units each hero owns (illusions, clones, summons) with the units it ordered; a micro summary per hero follows at the end; 0 disables")
+	validate := fs.Bool("validate", false, "at the end, compare re-derived kills, last hits and GPM with the replay's scoreboard and epilogue in a validation record; discrepancies are warnings")
+	validateTolerance := fs.Float64("validate-tolerance", 0.05, "with -validate, the relative difference allowed before a value is flagged")
+	inventory := fs.Bool("inventory", false, "emit an inventory record whenever an item is added to, removed from or moved between the inventory, backpack, stash, teleport and neutral slots of a hero or courier")
+	phases := fs.Bool("phases", false, "segment the match into laning, mid game, high ground sieges and late game, emitting phase transition records and a phases summary, and tag every record with its phase")
+	deaths := fs.Bool("deaths", false, "emit a death_report for every hero death with the victim's items, cooldowns and net worth, nearby allies and enemies, and damage taken in the preceding 10 seconds")
+	deathsRadius := fs.Float64("deaths-radius", 1500, "with -deaths, list heroes within this many units of the death as nearby")
+	broadcast := fs.Bool("broadcast", false, "emit pause, unpause and spectator_join records and a final broadcast record judging from casters, draft reserve time and pauses whether the replay is a tournament broadcast")
+	excludePauses := fs.Bool("exclude-pauses", false, "leave time the game spent paused out of the game clock of every record")
+	cooldowns := fs.Bool("cooldowns", false, "emit ready/cooldown ability_state intervals for hero abilities and items, plus per-ability ability_usage stats at the end")
+	var plugins stringList
+	fs.Var(&plugins, "plugin", "run an external extractor command that reads records as JSONL on stdin and writes derived records to stdout (repeatable)")
+	pluginKinds := fs.String("plugin-kinds", "", "comma-separated record kinds sent to -plugin commands; empty sends every record")
+	var wasmModules stringList
+	fs.Var(&wasmModules, "wasm", "run a WebAssembly module in the embedded runtime, with no filesystem, network or environment, as a hook that may drop each record or add derived records (repeatable)")
+	wasmKinds := fs.String("wasm-kinds", "", "comma-separated record kinds sent to -wasm hooks; other kinds pass through")
+	withContext := fs.Bool("context", false, "add a snapshot of nearby heroes (position, HP/mana, cooldowns, net worth) to records matching a filter such as -eclipse")
+	contextRadius := fs.Float64("context-radius", 1500, "with -context, include heroes within this many units of the record's hero")
+	snapshot := fs.Uint("snapshot", 0, "emit a snapshot record with every decoded field of each entity matching -snapshot-classes every N ticks; 0 disables")
+	snapshotClasses := fs.String("snapshot-classes", "CDOTA_Unit_Hero_*", "comma-separated class-name globs selecting -snapshot entities")
+	dedupFlag := fs.Bool("dedup", false, "drop combat log entries, raw callbacks and string table dumps re-delivered by demo full packets before any extractor counts them")
+	force := fs.Bool("force", false, "on a decode error (often a replay newer than the bundled manta), keep the partial output and finish with a warning; records are tagged with the detected build")
+	latest := fs.Bool("latest", false, "parse the most recent replay in the local Steam Dota 2 replays directory")
+	steamDir := fs.String("steam-dir", "", "with -latest, the Steam install to search instead of the OS default locations")
+	ingestURL := fs.String("ingest", "", "POST records as zstd-compressed JSONL batches to this http(s) URL; local output is then written only when -out is given")
+	var ingestHeaders stringList
+	fs.Var(&ingestHeaders, "ingest-header", "extra `Name: value` header on -ingest requests, e.g. for auth (repeatable); "+ingestTokenEnv+" sets a bearer token")
+	ingestDict := fs.String("ingest-dict", "", "compress -ingest batches with this zstd dictionary (see dict)")
+	ingestBatchSize := fs.Int("ingest-batch", 5000, "records per -ingest request")
+	ingestQueue := fs.Int("ingest-queue", 4, "batches buffered for -ingest before parsing waits for the endpoint")
+	ingestRetries := fs.Int("ingest-retries", 5, "retries per -ingest batch on network errors, 429 and 5xx responses")
+	ingestTimeout := fs.Duration("ingest-timeout", 30*time.Second, "timeout for one -ingest request")
+	manifestPath := fs.String("manifest", "", "write a JSON manifest (match ID, replay and output SHA-256, versions, flags, record counts by kind) to this path when the run ends")
+	maxMemory := fs.String("max-memory", "", "heap budget such as 4GiB; when the live heap nears it, pause the optional extractors that hold the most state (rotations, cooldowns, deaths, micro) one at a time, dropping that state, and record each in a degraded record")
+	raw := fs.Bool("raw", true, "with -format=json, emit every raw callback and game event")
+	positional := global.parseArgs(fs, args, presets)
+
+	if len(positional) > 2 {
+		fatal(exitUsage, "expected at most a replay and an output path")
+	}
+	if len(positional) > 0 && *demPath == "" {
+		*demPath = positional[0]
+	}
+	if len(positional) > 1 {
+		*outPath = positional[1]
+	}
+	if *latest && *demPath == "" {
+		dem, err := latestReplay(*steamDir)
+		if err != nil {
+			fatal(exitIO, "find latest replay", "err", err)
+		}
+		logger.Info("using latest replay", "replay", dem)
+		*demPath = dem
+	}
 	if *demPath == "" {
-		log.Fatal("-dem is required")
+		fatal(exitUsage, "-dem is required")
+	}
+	logger = logger.With("replay", *demPath)
+	switch *format {
+	case "json", "opendota", "clarity", "text":
+	default:
+		fatal(exitUsage, "unknown -format", "format", *format)
+	}
+	var snapshotGlobs []string
+	if *snapshot > 0 {
+		var err error
+		if snapshotGlobs, err = parseClassGlobs(*snapshotClasses); err != nil {
+			fatal(exitUsage, "bad -snapshot-classes", "err", err)
+		}
+	}
+
+	var memoryLimit uint64
+	if *maxMemory != "" {
+		var err error
+		if memoryLimit, err = parseByteSize(*maxMemory); err != nil {
+			fatal(exitUsage, "bad -max-memory", "err", err)
+		}
+	}
+
+	writeLocal := true
+	if *ingestURL != "" {
+		writeLocal = len(positional) > 1
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "out" {
+				writeLocal = true
+			}
+		})
 	}
 
 	in, err := os.Open(*demPath)
 	if err != nil {
-		log.Fatalf("open replay: %v", err)
+		fatal(exitIO, "open replay", "err", err)
 	}
 	defer in.Close()
 
 	var out io.Writer = os.Stdout
 	var outFile *os.File
-	if *outPath != "-" {
+	if writeLocal && *outPath != "-" {
 		outFile, err = os.Create(*outPath)
 		if err != nil {
-			log.Fatalf("create output: %v", err)
+			fatal(exitIO, "create output", "err", err)
 		}
 		defer outFile.Close()
 		out = outFile
 	}
+	var replay io.Reader = in
+	var manifest *manifestBuilder
+	if *manifestPath != "" {
+		manifest = newManifestBuilder()
+		replay = io.TeeReader(in, manifest.replay)
+		out = io.MultiWriter(out, manifest)
+	}
 
-	parser, err := manta.NewStreamParser(in)
+	parser, err := manta.NewStreamParser(replay)
 	if err != nil {
-		log.Fatalf("create parser: %v", err)
+		fatal(exitInvalidReplay, "create parser", "err", err)
 	}
 
-	enc := json.NewEncoder(out)
+	var enc recordEncoder = json.NewEncoder(out)
+	if *format == "text" {
+		enc = newTextEncoder(out)
+	}
+	var ingestEnc *ingestEncoder
+	if *ingestURL != "" {
+		var inner recordEncoder
+		if writeLocal {
+			inner = enc
+		}
+		var dict []byte
+		if *ingestDict != "" {
+			if dict, err = os.ReadFile(*ingestDict); err != nil {
+				fatal(exitIO, "read -ingest-dict", "err", err)
+			}
+		}
+		ingestEnc, err = newIngestEncoder(inner, *ingestURL, ingestHeaders, *demPath, dict, *ingestBatchSize, *ingestQueue, *ingestRetries, *ingestTimeout)
+		if err != nil {
+			fatal(exitUsage, "bad -ingest", "err", err)
+		}
+		enc = ingestEnc
+	}
+	if manifest != nil {
+		manifest.inner = enc
+		manifest.watch(parser)
+		enc = manifest
+	}
+	var pluginEnc *pluginEncoder
+	if len(plugins) > 0 {
+		pluginEnc, err = newPluginEncoder(enc, plugins, *pluginKinds)
+		if err != nil {
+			fatal(exitHook, "start plugins", "err", err)
+		}
+		enc = pluginEnc
+	}
+	var wasmEnc *wasmEncoder
+	if len(wasmModules) > 0 {
+		wasmEnc, err = newWASMEncoder(enc, wasmModules, *wasmKinds)
+		if err != nil {
+			fatal(exitHook, "start wasm hooks", "err", err)
+		}
+		enc = wasmEnc
+	}
 	output := newOutputState(enc, *eclipseOnly)
-	registered := make(map[string]bool)
+	output.names = nameMapping
+	if *withContext {
+		output.context = newStateContext(parser, *contextRadius)
+	}
 	wrote := 0
-
-	registerAllCallbacks(parser, output, &registered, &wrote, *includeBinary)
-
-	parser.Callbacks.OnCMsgSource1LegacyGameEventList(func(m *dota.CMsgSource1LegacyGameEventList) error {
-		for _, d := range m.GetDescriptors() {
-			name := d.GetName()
-			if name == "" || registered[name] {
-				continue
-			}
-			registered[name] = true
-			eventName := name
-			parser.OnGameEvent(eventName, func(e *manta.GameEvent) error {
-				wrote++
-				record := map[string]any{
-					"kind":       "game_event",
-					"tick":       parser.Tick,
-					"event_name": eventName,
-				}
-				return output.add(parser.Tick, record, false)
-			})
+	var version *replayVersion
+	var dedup, combatDedup *messageDedup
+	if *dedupFlag {
+		combatDedup = dedupCombatLog(parser)
+	}
+	var detector *fightDetector
+	var pauses *pauseLedger
+	extractors := newExtractorRegistry(parser)
+	// The pause ledger and annotators come first so the clock and their
+	// state are current when later extractors write records in the same
+	// tick.
+	extractors.define("pauses", nil, func(*extractorHooks) func() error {
+		pauses = registerPauseLedger(parser)
+		if *excludePauses {
+			pauseClock = pauses
 		}
 		return nil
 	})
+	extractors.define("daynight", nil, func(*extractorHooks) func() error {
+		output.dayNight = registerDayNight(parser, output, &wrote)
+		return nil
+	})
+	extractors.define("phases", nil, func(*extractorHooks) func() error {
+		var finish func() error
+		output.phases, finish = registerPhases(parser, output, &wrote)
+		return finish
+	})
+	extractors.define("replay_version", nil, func(*extractorHooks) func() error {
+		version = registerReplayVersion(parser, output, *format == "json", *force, &wrote)
+		return nil
+	})
+	extractors.define("opendota", nil, func(*extractorHooks) func() error {
+		registerOpenDota(parser, output, &wrote)
+		return nil
+	})
+	extractors.define("clarity", nil, func(*extractorHooks) func() error {
+		registerClarity(parser, output, &wrote)
+		return nil
+	})
+	extractors.define("text", nil, func(*extractorHooks) func() error {
+		registerText(parser, output, &wrote)
+		return nil
+	})
+	extractors.definePausable("raw", nil, func(h *extractorHooks) func() error {
+		if *dedupFlag {
+			dedup = newMessageDedup(parser)
+		}
+		registerAllCallbacks(parser, output, h, &wrote, *includeBinary, dedup)
+		return nil
+	})
+	extractors.definePausable("game_events", nil, func(h *extractorHooks) func() error {
+		registerGameEvents(parser, output, h, &wrote)
+		return nil
+	})
+	extractors.definePausable("positions", nil, func(h *extractorHooks) func() error {
+		registerPositions(h, output, uint32(*positions), *positionSummons, &wrote)
+		return nil
+	})
+	extractors.define("building_hp", nil, func(*extractorHooks) func() error {
+		registerBuildingHP(parser, output, uint32(*buildingHP), &wrote)
+		return nil
+	})
+	extractors.definePausable("snapshot", nil, func(h *extractorHooks) func() error {
+		registerSnapshots(h, output, uint32(*snapshot), snapshotGlobs, &wrote)
+		return nil
+	})
+	extractors.definePausable("vision", nil, func(h *extractorHooks) func() error {
+		registerVision(h, output, uint32(*vision), *visionRaster, &wrote)
+		return nil
+	})
+	extractors.define("damage", nil, func(*extractorHooks) func() error {
+		return registerDamage(parser, output, uint32(*damageInterval), &wrote)
+	})
+	extractors.define("gold", nil, func(*extractorHooks) func() error {
+		return registerGold(parser, output, uint32(*goldInterval), &wrote)
+	})
+	extractors.define("cs", nil, func(*extractorHooks) func() error {
+		return registerLastHits(parser, output, &wrote)
+	})
+	extractors.define("aghs", nil, func(*extractorHooks) func() error {
+		return registerAghs(parser, output, &wrote)
+	})
+	extractors.define("dives", nil, func(*extractorHooks) func() error {
+		return registerDives(parser, output, &wrote)
+	})
+	extractors.definePausable("rotations", nil, func(h *extractorHooks) func() error {
+		return registerRotations(h, output, &wrote)
+	})
+	extractors.define("camps", nil, func(*extractorHooks) func() error {
+		return registerCamps(parser, output, &wrote)
+	})
+	extractors.define("teleports", nil, func(*extractorHooks) func() error {
+		return registerTeleports(parser, output, &wrote)
+	})
+	extractors.define("objectives", nil, func(*extractorHooks) func() error {
+		return registerObjectives(parser, output, &wrote)
+	})
+	extractors.definePausable("micro", nil, func(h *extractorHooks) func() error {
+		return registerMicro(h, output, uint32(*micro), &wrote)
+	})
+	extractors.define("validate", nil, func(*extractorHooks) func() error {
+		return registerValidation(parser, output, *validateTolerance, &wrote)
+	})
+	extractors.define("inventory", nil, func(*extractorHooks) func() error {
+		registerInventory(parser, output, &wrote)
+		return nil
+	})
+	extractors.definePausable("deaths", nil, func(h *extractorHooks) func() error {
+		registerDeathReports(h, output, *deathsRadius, &wrote)
+		return nil
+	})
+	extractors.definePausable("cooldowns", nil, func(h *extractorHooks) func() error {
+		return registerCooldowns(h, output, &wrote)
+	})
+	extractors.define("roster", nil, func(*extractorHooks) func() error {
+		return registerRoster(parser, output, &wrote)
+	})
+	extractors.define("winprob", nil, func(*extractorHooks) func() error {
+		return registerWinProb(parser, output, uint32(*winProb), &wrote)
+	})
+	extractors.define("broadcast", []string{"pauses"}, func(*extractorHooks) func() error {
+		return registerBroadcast(parser, output, pauses, &wrote)
+	})
+	// The detector must see combat log entries before the analyzers that
+	// ask it for the current fight, and closes the last fight before their
+	// finalizers run.
+	extractors.define("fights", nil, func(*extractorHooks) func() error {
+		detector = newFightDetector(parser, output, *fights, &wrote)
+		return detector.finalize
+	})
+	extractors.define("cc", []string{"fights"}, func(*extractorHooks) func() error {
+		return registerCC(parser, output, detector, &wrote)
+	})
+	extractors.define("participation", []string{"fights"}, func(*extractorHooks) func() error {
+		return registerParticipation(parser, output, detector, &wrote)
+	})
+
+	rawJSON := *format == "json" && *raw
+	for name, on := range map[string]bool{
+		"pauses":         *excludePauses,
+		"daynight":       *dayNight,
+		"phases":         *phases,
+		"replay_version": true,
+		"opendota":       *format == "opendota",
+		"clarity":        *format == "clarity",
+		"text":           *format == "text",
+		"raw":            rawJSON,
+		"game_events":    rawJSON,
+		"positions":      *positions > 0,
+		"building_hp":    *buildingHP > 0,
+		"snapshot":       *snapshot > 0,
+		"vision":         *vision > 0,
+		"damage":         *damage,
+		"gold":           *gold,
+		"cs":             *cs,
+		"aghs":           *aghs,
+		"dives":          *dives,
+		"rotations":      *rotations,
+		"camps":          *camps,
+		"teleports":      *teleports,
+		"objectives":     *objectives,
+		"micro":          *micro > 0,
+		"validate":       *validate,
+		"inventory":      *inventory,
+		"deaths":         *deaths,
+		"cooldowns":      *cooldowns,
+		"roster":         *roster,
+		"winprob":        *winProb > 0,
+		"broadcast":      *broadcast,
+		"fights":         *fights,
+		"cc":             *cc,
+		"participation":  *participation,
+	} {
+		if !on {
+			continue
+		}
+		if err := extractors.enable(name); err != nil {
+			fatal(exitUsage, "enable extractor", "err", err)
+		}
+	}
+	if err := extractors.install(); err != nil {
+		fatal(exitUsage, "install extractors", "err", err)
+	}
+	var governor *memoryGovernor
+	if memoryLimit > 0 {
+		governor = registerMemoryGovernor(parser, output, extractors, memoryLimit, &wrote)
+	}
 
 	if err := parser.Start(); err != nil {
-		log.Fatalf("parse replay: %v", err)
+		code := parseExitCode(err)
+		if code != exitInvalidReplay || !*force {
+			fatal(code, "parse replay", "err", err, "version", version.String(),
+				"hint", "a decode error right after a game patch usually means manta needs updating; -force keeps partial output")
+		}
+		warn("replay decoded partially", "err", err, "version", version.String(), "tick", parser.Tick)
+	}
+	if err := extractors.finalize(); err != nil {
+		fatal(exitIO, "finalize output", "err", err)
 	}
 	if err := output.flushFinal(); err != nil {
-		log.Fatalf("flush output: %v", err)
+		fatal(exitIO, "flush output", "err", err)
+	}
+	if wasmEnc != nil {
+		if err := wasmEnc.close(); err != nil {
+			fatal(exitHook, "wasm hooks", "err", err)
+		}
+	}
+	if pluginEnc != nil {
+		if err := pluginEnc.close(); err != nil {
+			fatal(exitHook, "plugins", "err", err)
+		}
+	}
+	if ingestEnc != nil {
+		if err := ingestEnc.close(); err != nil {
+			fatal(exitSink, "deliver records", "err", err)
+		}
+	}
+	if manifest != nil {
+		manifestOut := *outPath
+		if !writeLocal {
+			manifestOut = ""
+		}
+		if governor != nil {
+			manifest.degraded = governor.degraded
+		}
+		if err := manifest.write(*manifestPath, fs, in, *demPath, manifestOut, version, extractors.installedNames()); err != nil {
+			fatal(exitIO, "write manifest", "err", err)
+		}
 	}
 
-	log.Printf("wrote %d events", wrote)
+	if output.droppedBinary > 0 {
+		logger.Info("dropped callbacks with unreadable binary payloads; pass -include-binary to keep them", "count", output.droppedBinary)
+	}
+	if combatDedup != nil && combatDedup.dropped > 0 {
+		logger.Info("dropped duplicate combat log entries from full packets", "count", combatDedup.dropped)
+	}
+	if dedup != nil && dedup.dropped > 0 {
+		logger.Info("dropped duplicate messages from full packets", "count", dedup.dropped)
+	}
+	logger.Info("done", "events", wrote, "ticks", parser.Tick)
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+const mantaModule = "github.com/dotabuff/manta"
+
+// manifestSecretFlags hold credentials and are listed without their values.
+var manifestSecretFlags = map[string]bool{"ingest-header": true}
+
+// redactURL hides the credentials a URL-valued flag may carry: the userinfo
+// and every query value. Values that are not absolute URLs are unchanged.
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return value
+	}
+	if u.User != nil {
+		u.User = url.User("redacted")
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q[k] = []string{"redacted"}
+		}
+		u.RawQuery = q.Encode()
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
+
+type manifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// manifestBuilder counts records by kind on their way to the output and
+// hashes the replay and output bytes, so a manifest can be written when
+// the run ends.
+type manifestBuilder struct {
+	inner   recordEncoder
+	counts  map[string]int
+	replay  hash.Hash
+	output  hash.Hash
+	outSize int64
+	matchID uint64
+	rules   *gameRulesRef
+	// degraded lists extractors paused by -max-memory.
+	degraded []string
+}
+
+func newManifestBuilder() *manifestBuilder {
+	return &manifestBuilder{counts: map[string]int{}, replay: sha256.New(), output: sha256.New()}
+}
+
+func (m *manifestBuilder) Encode(v any) error {
+	m.counts[recordKind(v)]++
+	return m.inner.Encode(v)
+}
+
+// Write hashes output bytes; the builder sits in front of the output file
+// through io.MultiWriter.
+func (m *manifestBuilder) Write(p []byte) (int, error) {
+	m.outSize += int64(len(p))
+	return m.output.Write(p)
+}
+
+// watch records the match ID from the file info at the end of the replay.
+func (m *manifestBuilder) watch(parser *manta.Parser) {
+	m.rules = newGameRulesRef(parser)
+	parser.Callbacks.OnCDemoFileInfo(func(info *dota.CDemoFileInfo) error {
+		if id := info.GetGameInfo().GetDota().GetMatchId(); id != 0 {
+			m.matchID = id
+		}
+		return nil
+	})
+}
+
+func buildVersions() (faeton, mantaVersion string) {
+	faeton, mantaVersion = "unknown", "unknown"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	faeton = info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			faeton = s.Value
+		}
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == mantaModule {
+			mantaVersion = dep.Version
+			if dep.Replace != nil {
+				mantaVersion = dep.Replace.Version
+			}
+		}
+	}
+	return
+}
+
+// write completes the replay hash from the rest of in and writes the
+// manifest to path. outPath is empty when no local output was written;
+// extractors are the names of the extractors that ran.
+func (m *manifestBuilder) write(path string, fs *flag.FlagSet, in io.Reader, replayPath, outPath string, version *replayVersion, extractors []string) error {
+	if _, err := io.Copy(m.replay, in); err != nil {
+		return err
+	}
+	stat, err := os.Stat(replayPath)
+	if err != nil {
+		return err
+	}
+	if m.matchID == 0 && m.rules != nil {
+		// A replay cut short has no file info; the game rules carry the ID.
+		if r := m.rules.get(); r != nil {
+			m.matchID, _ = r.GetUint64("m_pGameRules.m_unMatchID64")
+		}
+	}
+	flags := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		if manifestSecretFlags[f.Name] {
+			value = "redacted"
+		} else {
+			value = redactURL(value)
+		}
+		flags[f.Name] = value
+	})
+	faeton, mantaVersion := buildVersions()
+	files := []manifestFile{}
+	if outPath != "" {
+		name := outPath
+		if outPath != "-" {
+			name = filepath.Base(outPath)
+		}
+		files = append(files, manifestFile{
+			Path:   name,
+			SHA256: hex.EncodeToString(m.output.Sum(nil)),
+			Bytes:  m.outSize,
+		})
+	}
+	manifest := map[string]any{
+		"match_id": m.matchID,
+		"replay": manifestFile{
+			Path:   filepath.Base(replayPath),
+			SHA256: hex.EncodeToString(m.replay.Sum(nil)),
+			Bytes:  stat.Size(),
+		},
+		"replay_version": version,
+		"faeton_version": faeton,
+		"manta_version":  mantaVersion,
+		"created":        time.Now().UTC().Format(time.RFC3339),
+		"flags":          flags,
+		"extractors":     extractors,
+		"records":        m.counts,
+		"files":          files,
+	}
+	if len(m.degraded) > 0 {
+		manifest["degraded"] = m.degraded
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
@@ -0,0 +1,147 @@
+// Package mapcoord converts between the coordinate spaces found in Dota 2
+// replays: the cell/offset pairs stored on entity body components, absolute
+// world units, and normalized 0-1 minimap space.
+package mapcoord
+
+import "math"
+
+const (
+	// CellBits is the number of low bits of a world coordinate that are
+	// stored in the entity's m_vec offset rather than its m_cell index.
+	CellBits = 7
+	// CellSize is the width of a single cell in world units.
+	CellSize = 1 << CellBits
+	// MaxCoord is the offset that maps cell 0 to the far edge of the world.
+	MaxCoord = 16384
+
+	// WorldMin and WorldMax bound the playable map area on both axes.
+	WorldMin = -8288.0
+	WorldMax = 8288.0
+)
+
+// World is a position in world units with the origin at the map centre,
+// X growing east and Y growing north (towards Dire).
+type World struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// Minimap is a position normalized to the minimap image: (0,0) is the top
+// left (north-west) corner and (1,1) the bottom right, so the Radiant
+// fountain sits near (0,1).
+type Minimap struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// CellToWorld converts a body component cell index and its sub-cell offset
+// to a single world-unit coordinate.
+func CellToWorld(cell uint32, offset float32) float64 {
+	return float64(cell)*CellSize + float64(offset) - MaxCoord
+}
+
+// FromCell builds a world position from the m_cell{X,Y,Z} and m_vec{X,Y,Z}
+// fields of an entity's body component.
+func FromCell(cellX, cellY, cellZ uint32, vecX, vecY, vecZ float32) World {
+	return World{
+		X: CellToWorld(cellX, vecX),
+		Y: CellToWorld(cellY, vecY),
+		Z: CellToWorld(cellZ, vecZ),
+	}
+}
+
+// WorldToMinimap projects a world position onto the minimap. Positions
+// outside the playable area are clamped to the [0,1] range.
+func WorldToMinimap(w World) Minimap {
+	return Minimap{
+		X: clamp01(normalize(w.X)),
+		Y: clamp01(1 - normalize(w.Y)),
+	}
+}
+
+// MinimapToWorld is the inverse of WorldToMinimap. Height is not recoverable
+// from minimap space and is left at zero.
+func MinimapToWorld(m Minimap) World {
+	return World{
+		X: denormalize(m.X),
+		Y: denormalize(1 - m.Y),
+	}
+}
+
+// Minimap is a convenience wrapper around WorldToMinimap.
+func (w World) Minimap() Minimap {
+	return WorldToMinimap(w)
+}
+
+// CellUnits expresses the position in cell units (world units divided by
+// CellSize, offset so the world edge is zero), the scale OpenDota and
+// clarity-based tools report coordinates in.
+func (w World) CellUnits() (x, y float64) {
+	return (w.X + MaxCoord) / CellSize, (w.Y + MaxCoord) / CellSize
+}
+
+// Distance2D returns the planar distance between two world positions.
+func Distance2D(a, b World) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// Map zones returned by ZoneOf.
+const (
+	ZoneRadiantBase   = "radiant_base"
+	ZoneDireBase      = "dire_base"
+	ZoneTop           = "top"
+	ZoneMid           = "mid"
+	ZoneBot           = "bot"
+	ZoneRiver         = "river"
+	ZoneRadiantJungle = "radiant_jungle"
+	ZoneDireJungle    = "dire_jungle"
+)
+
+// ZoneOf classifies a world position into a coarse map zone. Lanes are
+// bands along the map edges (top, bot) and the south-west to north-east
+// diagonal (mid); the river is the band along the opposite diagonal; the
+// rest is jungle on either side of it. Boundaries are approximate and meant
+// for movement analysis, not for exact lane-creep positions.
+func ZoneOf(w World) string {
+	switch {
+	case w.X < -4800 && w.Y < -4300:
+		return ZoneRadiantBase
+	case w.X > 4300 && w.Y > 3800:
+		return ZoneDireBase
+	case w.X < -5200 || w.Y > 5000:
+		return ZoneTop
+	case w.Y < -5200 || w.X > 5000:
+		return ZoneBot
+	case math.Abs(w.X-w.Y) < 1200:
+		return ZoneMid
+	case math.Abs(w.X+w.Y) < 900:
+		return ZoneRiver
+	case w.X+w.Y < 0:
+		return ZoneRadiantJungle
+	}
+	return ZoneDireJungle
+}
+
+// IsLane reports whether zone is one of the three lanes.
+func IsLane(zone string) bool {
+	return zone == ZoneTop || zone == ZoneMid || zone == ZoneBot
+}
+
+func normalize(v float64) float64 {
+	return (v - WorldMin) / (WorldMax - WorldMin)
+}
+
+func denormalize(v float64) float64 {
+	return WorldMin + v*(WorldMax-WorldMin)
+}
+
+func clamp01(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
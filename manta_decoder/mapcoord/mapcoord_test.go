@@ -0,0 +1,96 @@
+package mapcoord
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestCellToWorld(t *testing.T) {
+	tests := []struct {
+		cell   uint32
+		offset float32
+		want   float64
+	}{
+		{0, 0, -MaxCoord},
+		{128, 0, 0},
+		{130, 64, 320},
+		{127, 127.5, -0.5},
+		{256, 0, MaxCoord},
+	}
+	for _, tt := range tests {
+		if got := CellToWorld(tt.cell, tt.offset); !near(got, tt.want) {
+			t.Errorf("CellToWorld(%d, %v) = %v, want %v", tt.cell, tt.offset, got, tt.want)
+		}
+	}
+	w := FromCell(130, 126, 128, 64, 0, 32)
+	if want := (World{X: 320, Y: -256, Z: 32}); w != want {
+		t.Errorf("FromCell = %+v, want %+v", w, want)
+	}
+}
+
+func TestMinimap(t *testing.T) {
+	tests := []struct {
+		world World
+		want  Minimap
+	}{
+		{World{}, Minimap{0.5, 0.5}},
+		{World{X: WorldMin, Y: WorldMin}, Minimap{0, 1}},
+		{World{X: WorldMax, Y: WorldMax}, Minimap{1, 0}},
+		{World{X: WorldMax / 2, Y: WorldMin / 2}, Minimap{0.75, 0.75}},
+		{World{X: -10000, Y: 10000}, Minimap{0, 0}}, // clamped
+	}
+	for _, tt := range tests {
+		got := tt.world.Minimap()
+		if !near(got.X, tt.want.X) || !near(got.Y, tt.want.Y) {
+			t.Errorf("%+v.Minimap() = %+v, want %+v", tt.world, got, tt.want)
+		}
+		if tt.world.X < WorldMin || tt.world.X > WorldMax {
+			continue
+		}
+		back := MinimapToWorld(got)
+		if !near(back.X, tt.world.X) || !near(back.Y, tt.world.Y) || back.Z != 0 {
+			t.Errorf("MinimapToWorld(%+v) = %+v, want %+v", got, back, tt.world)
+		}
+	}
+}
+
+func TestCellUnits(t *testing.T) {
+	x, y := World{X: 0, Y: -MaxCoord}.CellUnits()
+	if x != 128 || y != 0 {
+		t.Errorf("CellUnits = %v, %v, want 128, 0", x, y)
+	}
+	if d := Distance2D(World{X: 3, Y: 4, Z: 100}, World{}); d != 5 {
+		t.Errorf("Distance2D = %v, want 5", d)
+	}
+}
+
+func TestZoneOf(t *testing.T) {
+	tests := []struct {
+		world World
+		want  string
+	}{
+		{World{X: -6000, Y: -6000}, ZoneRadiantBase},
+		{World{X: 6000, Y: 6000}, ZoneDireBase},
+		{World{X: -6000, Y: 0}, ZoneTop},
+		{World{X: 0, Y: 6000}, ZoneTop},
+		{World{X: 0, Y: -6000}, ZoneBot},
+		{World{X: 6000, Y: 0}, ZoneBot},
+		{World{}, ZoneMid},
+		{World{X: 2000, Y: 1500}, ZoneMid},
+		{World{X: 1000, Y: -1000}, ZoneRiver},
+		{World{X: -3000, Y: -500}, ZoneRadiantJungle},
+		{World{X: 3000, Y: 500}, ZoneDireJungle},
+	}
+	for _, tt := range tests {
+		if got := ZoneOf(tt.world); got != tt.want {
+			t.Errorf("ZoneOf(%+v) = %q, want %q", tt.world, got, tt.want)
+		}
+	}
+	for zone, want := range map[string]bool{ZoneTop: true, ZoneMid: true, ZoneBot: true, ZoneRiver: false, ZoneDireBase: false} {
+		if IsLane(zone) != want {
+			t.Errorf("IsLane(%q) = %v, want %v", zone, !want, want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+// degradableExtractors are the optional extractors -max-memory may pause,
+// most retained state first. Each keeps state for records it writes later
+// and drops it when paused; extractors such as snapshot or positions, which
+// write every sample as they go, would give back next to nothing.
+var degradableExtractors = []string{"rotations", "cooldowns", "deaths", "micro"}
+
+const (
+	// memoryPressure is the share of -max-memory the live heap may reach
+	// before an extractor is paused; the Go soft limit keeps collection
+	// working hard below it.
+	memoryPressure = 0.9
+	// memoryCheckTicks is how often the heap is checked, and
+	// memorySettleTicks how long to wait after pausing an extractor before
+	// pausing another.
+	memoryCheckTicks  = 30
+	memorySettleTicks = 900
+)
+
+const liveHeapMetric = "/gc/heap/live:bytes"
+
+// parseByteSize parses a size such as 4GiB, 512MB or 1073741824.
+func parseByteSize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		scale  uint64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+	}
+	s = strings.TrimSpace(s)
+	scale := uint64(1)
+	for _, u := range units {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, scale = strings.TrimSpace(rest), u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	return uint64(n * float64(scale)), nil
+}
+
+// memoryGovernor watches the live heap during the parse and pauses the
+// optional extractors holding the most state, one at a time, while it stays above
+// the limit.
+type memoryGovernor struct {
+	parser     *manta.Parser
+	rules      *gameRulesRef
+	out        *outputState
+	wrote      *int
+	extractors *extractorRegistry
+	limit      uint64
+	sampler    *tickSampler
+	sample     []metrics.Sample
+	settle     uint32
+	degraded   []string
+	exhausted  bool
+}
+
+func (g *memoryGovernor) liveHeap() uint64 {
+	metrics.Read(g.sample)
+	if g.sample[0].Value.Kind() != metrics.KindUint64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	return g.sample[0].Value.Uint64()
+}
+
+// next returns the next installed, still running extractor to pause.
+func (g *memoryGovernor) next() string {
+	for _, name := range degradableExtractors {
+		if x, ok := g.extractors.byName[name]; ok && x.active && g.extractors.pausable(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func (g *memoryGovernor) onEntity(_ *manta.Entity, _ manta.EntityOp) error {
+	if !g.sampler.due(g.parser.Tick) || g.parser.Tick < g.settle {
+		return nil
+	}
+	live := g.liveHeap()
+	if float64(live) < memoryPressure*float64(g.limit) {
+		return nil
+	}
+	name := g.next()
+	if name == "" {
+		if !g.exhausted {
+			g.exhausted = true
+			warn("heap above -max-memory with no extractor left to pause", "heap_live_bytes", live, "max_memory", g.limit)
+		}
+		return nil
+	}
+	released, err := g.pause(name)
+	if err != nil {
+		return err
+	}
+	g.degraded = append(g.degraded, name)
+	g.settle = g.parser.Tick + memorySettleTicks
+	warn("heap near -max-memory; paused an extractor", "extractor", name, "released_bytes", released, "heap_live_bytes", live, "max_memory", g.limit)
+	rec := map[string]any{
+		"kind":            "degraded",
+		"tick":            g.parser.Tick,
+		"time":            g.rules.now(),
+		"extractor":       name,
+		"released_bytes":  released,
+		"heap_live_bytes": live,
+		"max_memory":      g.limit,
+	}
+	(*g.wrote)++
+	return g.out.add(g.parser.Tick, rec, false)
+}
+
+// pause pauses name and returns how much the live heap shrank once the
+// state it dropped was collected.
+func (g *memoryGovernor) pause(name string) (uint64, error) {
+	runtime.GC()
+	before := g.liveHeap()
+	if err := g.extractors.setActive(name, false); err != nil {
+		return 0, err
+	}
+	runtime.GC()
+	if after := g.liveHeap(); after < before {
+		return before - after, nil
+	}
+	return 0, nil
+}
+
+// registerMemoryGovernor sets the Go soft memory limit to limit and starts
+// watching the heap.
+func registerMemoryGovernor(parser *manta.Parser, out *outputState, extractors *extractorRegistry, limit uint64, wrote *int) *memoryGovernor {
+	debug.SetMemoryLimit(int64(limit))
+	g := &memoryGovernor{
+		parser:     parser,
+		rules:      newGameRulesRef(parser),
+		out:        out,
+		wrote:      wrote,
+		extractors: extractors,
+		limit:      limit,
+		sampler:    newTickSampler(memoryCheckTicks),
+		sample:     []metrics.Sample{{Name: liveHeapMetric}},
+	}
+	parser.OnEntity(g.onEntity)
+	return g
+}
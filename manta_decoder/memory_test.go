@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{in: "1073741824", want: 1 << 30},
+		{in: "4GiB", want: 4 << 30},
+		{in: "512MB", want: 512e6},
+		{in: "1.5KiB", want: 1536},
+		{in: " 2 TB ", want: 2e12},
+		{in: "100B", want: 100},
+		{in: "1TiB", want: 1 << 40},
+		{in: "", err: true},
+		{in: "0", err: true},
+		{in: "-1MB", err: true},
+		{in: "GiB", err: true},
+		{in: "10XB", err: true},
+		{in: "4gb", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseByteSize(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// microWindow is one hero's unit control during a sampling window.
+type microWindow struct {
+	orders     int
+	unitOrders int
+	multiUnit  int
+	active     map[int32]string
+	controlled map[string]int
+}
+
+func newMicroWindow() *microWindow {
+	return &microWindow{active: map[int32]string{}, controlled: map[string]int{}}
+}
+
+type microTotals struct {
+	orders          int
+	unitOrders      int
+	multiUnit       int
+	windows         int
+	controlledSum   int
+	maxControlled   int
+	activeSum       int
+	maxActive       int
+	activeUnitTypes map[string]bool
+}
+
+// microTracker attributes spectator unit orders to the hero that owns the
+// ordered units and compares how many units each hero has with how many it
+// actually orders.
+type microTracker struct {
+	parser      *manta.Parser
+	rules       *gameRulesRef
+	out         *outputState
+	wrote       *int
+	units       *unitClassifier
+	sampler     *tickSampler
+	windowStart float32
+	windows     map[string]*microWindow
+	totals      map[string]*microTotals
+}
+
+func (t *microTracker) window(hero string) *microWindow {
+	w := t.windows[hero]
+	if w == nil {
+		w = newMicroWindow()
+		t.windows[hero] = w
+	}
+	return w
+}
+
+func (t *microTracker) onOrder(m *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) error {
+	hero := ""
+	types := map[int32]string{}
+	for _, idx := range m.GetUnits() {
+		e := t.parser.FindEntity(idx)
+		if e == nil {
+			continue
+		}
+		unit, owner, ok := t.units.classify(e)
+		if !ok || owner == "" {
+			continue
+		}
+		if hero == "" {
+			hero = owner
+		}
+		if owner == hero {
+			types[idx] = unit
+		}
+	}
+	if hero == "" {
+		return nil
+	}
+	w := t.window(hero)
+	w.orders++
+	if len(types) > 1 {
+		w.multiUnit++
+	}
+	nonHero := false
+	for idx, unit := range types {
+		w.active[idx] = unit
+		nonHero = nonHero || unit != unitHero
+	}
+	if nonHero {
+		w.unitOrders++
+	}
+	return nil
+}
+
+// countControlled tallies every unit each hero owns right now by type.
+func (t *microTracker) countControlled() {
+	for _, e := range t.parser.FilterEntity(func(e *manta.Entity) bool {
+		return (isHeroEntity(e) || isSummonCandidate(e)) && isAlive(e)
+	}) {
+		unit, owner, ok := t.units.classify(e)
+		if !ok || owner == "" {
+			continue
+		}
+		w := t.window(owner)
+		w.controlled[unit]++
+	}
+}
+
+func (t *microTracker) totalsFor(hero string) *microTotals {
+	tot := t.totals[hero]
+	if tot == nil {
+		tot = &microTotals{activeUnitTypes: map[string]bool{}}
+		t.totals[hero] = tot
+	}
+	return tot
+}
+
+// flush writes a unit_control record per hero for the window that just
+// ended and starts the next one.
+func (t *microTracker) flush() error {
+	now := t.rules.now()
+	minutes := (now - t.windowStart) / 60
+	heroes := make([]string, 0, len(t.windows))
+	for hero := range t.windows {
+		heroes = append(heroes, hero)
+	}
+	sort.Strings(heroes)
+	for _, hero := range heroes {
+		w := t.windows[hero]
+		active := map[string]int{}
+		for _, unit := range w.active {
+			active[unit]++
+		}
+		controlled := 0
+		for _, n := range w.controlled {
+			controlled += n
+		}
+		tot := t.totalsFor(hero)
+		tot.orders += w.orders
+		tot.unitOrders += w.unitOrders
+		tot.multiUnit += w.multiUnit
+		tot.windows++
+		tot.controlledSum += controlled
+		tot.activeSum += len(w.active)
+		tot.maxControlled = max(tot.maxControlled, controlled)
+		tot.maxActive = max(tot.maxActive, len(w.active))
+		for unit := range active {
+			tot.activeUnitTypes[unit] = true
+		}
+		rec := map[string]any{
+			"kind":              "unit_control",
+			"tick":              t.parser.Tick,
+			"start":             t.windowStart,
+			"end":               now,
+			"hero":              hero,
+			"controlled":        w.controlled,
+			"controlled_total":  controlled,
+			"active":            active,
+			"active_total":      len(w.active),
+			"orders":            w.orders,
+			"unit_orders":       w.unitOrders,
+			"multi_unit_orders": w.multiUnit,
+		}
+		if minutes > 0 {
+			rec["orders_per_minute"] = float32(w.orders) / minutes
+		}
+		(*t.wrote)++
+		if err := t.out.add(t.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	t.windows = map[string]*microWindow{}
+	t.windowStart = now
+	return nil
+}
+
+func (t *microTracker) onEntity(_ *manta.Entity, _ manta.EntityOp) error {
+	if !t.sampler.due(t.parser.Tick) {
+		return nil
+	}
+	if err := t.flush(); err != nil {
+		return err
+	}
+	t.countControlled()
+	return nil
+}
+
+// finish closes the last window and writes a micro summary per hero.
+func (t *microTracker) finish() error {
+	if err := t.flush(); err != nil {
+		return err
+	}
+	heroes := make([]string, 0, len(t.totals))
+	for hero := range t.totals {
+		heroes = append(heroes, hero)
+	}
+	sort.Strings(heroes)
+	for _, hero := range heroes {
+		tot := t.totals[hero]
+		types := make([]string, 0, len(tot.activeUnitTypes))
+		for unit := range tot.activeUnitTypes {
+			types = append(types, unit)
+		}
+		sort.Strings(types)
+		rec := map[string]any{
+			"kind":               "micro",
+			"tick":               t.parser.Tick,
+			"hero":               hero,
+			"orders":             tot.orders,
+			"unit_orders":        tot.unitOrders,
+			"multi_unit_orders":  tot.multiUnit,
+			"max_controlled":     tot.maxControlled,
+			"max_active":         tot.maxActive,
+			"ordered_unit_types": types,
+		}
+		if tot.orders > 0 {
+			rec["unit_order_share"] = float32(tot.unitOrders) / float32(tot.orders)
+		}
+		if tot.windows > 0 {
+			rec["avg_controlled"] = float32(tot.controlledSum) / float32(tot.windows)
+			rec["avg_active"] = float32(tot.activeSum) / float32(tot.windows)
+		}
+		(*t.wrote)++
+		if err := t.out.add(t.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerMicro(h *extractorHooks, out *outputState, interval uint32, wrote *int) func() error {
+	parser := h.parser()
+	t := &microTracker{
+		parser:  parser,
+		rules:   newGameRulesRef(parser),
+		out:     out,
+		wrote:   wrote,
+		units:   newUnitClassifier(parser),
+		sampler: newTickSampler(interval),
+		windows: map[string]*microWindow{},
+		totals:  map[string]*microTotals{},
+	}
+	parser.Callbacks.OnCDOTAUserMsg_SpectatorPlayerUnitOrders(func(m *dota.CDOTAUserMsg_SpectatorPlayerUnitOrders) error {
+		if !h.active() {
+			return nil
+		}
+		return t.onOrder(m)
+	})
+	h.onEntity(t.onEntity)
+	h.onPause(func() {
+		t.windows = map[string]*microWindow{}
+		t.totals = map[string]*microTotals{}
+	})
+	return t.finish
+}
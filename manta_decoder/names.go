@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+)
+
+var unitNamePrefixes = []string{"npc_dota_hero_", "npc_dota_"}
+
+func titleWords(s string) string {
+	words := strings.Fields(strings.ReplaceAll(s, "_", " "))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// heroShortName strips the unit prefix from a hero name, e.g.
+// npc_dota_hero_pudge -> pudge.
+func heroShortName(unit string) string {
+	return strings.TrimPrefix(unit, "npc_dota_hero_")
+}
+
+// prettyUnitName turns an internal unit name into a display name, e.g.
+// npc_dota_hero_luna -> Luna.
+func prettyUnitName(unit string) string {
+	if unit == "" {
+		return "unknown"
+	}
+	if v, ok := nameMapping.lookup(unit); ok {
+		return v
+	}
+	for _, p := range unitNamePrefixes {
+		if strings.HasPrefix(unit, p) {
+			return titleWords(strings.TrimPrefix(unit, p))
+		}
+	}
+	return titleWords(unit)
+}
+
+// prettyAbilityName turns an ability, item or modifier name into a display
+// name, dropping the owning hero's prefix: pudge_meat_hook -> Meat Hook,
+// modifier_pudge_meat_hook -> Meat Hook, item_black_king_bar -> Black King Bar.
+func prettyAbilityName(name, owner string) string {
+	if v, ok := nameMapping.lookup(name); ok {
+		return v
+	}
+	name = strings.TrimPrefix(name, "modifier_")
+	name = strings.TrimPrefix(name, "item_")
+	if short := heroShortName(owner); short != owner && short != "" {
+		name = strings.TrimPrefix(name, short+"_")
+	}
+	return titleWords(name)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// npyArray is a dense little-endian array in NumPy's .npy layout. data must
+// be a []float32 or []int32 whose length is the product of shape.
+type npyArray struct {
+	name  string
+	shape []int
+	data  any
+}
+
+func (a npyArray) descr() (string, error) {
+	switch a.data.(type) {
+	case []float32:
+		return "<f4", nil
+	case []int32:
+		return "<i4", nil
+	}
+	return "", fmt.Errorf("npy %s: unsupported element type %T", a.name, a.data)
+}
+
+func (a npyArray) encode() ([]byte, error) {
+	descr, err := a.descr()
+	if err != nil {
+		return nil, err
+	}
+	dims := make([]string, len(a.shape))
+	for i, d := range a.shape {
+		dims[i] = fmt.Sprint(d)
+	}
+	shape := strings.Join(dims, ", ")
+	if len(a.shape) == 1 {
+		shape += ","
+	}
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, shape)
+	// Magic (6) + version (2) + header length (2) + header must be a multiple
+	// of 64, with the header terminated by a newline.
+	pad := 64 - (10+len(header)+1)%64
+	header += strings.Repeat(" ", pad%64) + "\n"
+
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY")
+	buf.Write([]byte{1, 0})
+	binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	if err := binary.Write(&buf, binary.LittleEndian, a.data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeNPZ(path string, arrays []npyArray) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, a := range arrays {
+		data, err := a.encode()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w, err := zw.Create(a.name + ".npy")
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
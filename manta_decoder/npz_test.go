@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNPYEncode(t *testing.T) {
+	tests := []struct {
+		array  npyArray
+		header string
+		data   []byte
+	}{
+		{
+			array:  npyArray{name: "labels", shape: []int{3}, data: []int32{1, -1, 256}},
+			header: "{'descr': '<i4', 'fortran_order': False, 'shape': (3,), }",
+			data:   []byte{1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 1, 0, 0},
+		},
+		{
+			array:  npyArray{name: "features", shape: []int{2, 1}, data: []float32{1, -2}},
+			header: "{'descr': '<f4', 'fortran_order': False, 'shape': (2, 1), }",
+			data:   []byte{0, 0, 0x80, 0x3f, 0, 0, 0, 0xc0},
+		},
+		{
+			array:  npyArray{name: "empty", shape: []int{0, 4}, data: []float32{}},
+			header: "{'descr': '<f4', 'fortran_order': False, 'shape': (0, 4), }",
+		},
+	}
+	for _, tt := range tests {
+		b, err := tt.array.encode()
+		if err != nil {
+			t.Errorf("%s: %v", tt.array.name, err)
+			continue
+		}
+		if !bytes.HasPrefix(b, []byte("\x93NUMPY\x01\x00")) {
+			t.Errorf("%s: bad magic % x", tt.array.name, b[:8])
+			continue
+		}
+		n := int(binary.LittleEndian.Uint16(b[8:10]))
+		if (10+n)%64 != 0 {
+			t.Errorf("%s: header ends at %d, not a multiple of 64", tt.array.name, 10+n)
+		}
+		header := string(b[10 : 10+n])
+		if !strings.HasSuffix(header, "\n") || strings.TrimRight(header, " \n") != tt.header {
+			t.Errorf("%s: header %q, want %q", tt.array.name, header, tt.header)
+		}
+		if data := b[10+n:]; !bytes.Equal(data, tt.data) {
+			t.Errorf("%s: data % x, want % x", tt.array.name, data, tt.data)
+		}
+	}
+	if _, err := (npyArray{name: "bad", shape: []int{1}, data: []float64{1}}).encode(); err == nil {
+		t.Error("a []float64 array was encoded")
+	}
+}
+
+func TestWriteNPZ(t *testing.T) {
+	arrays := []npyArray{
+		{name: "features", shape: []int{2}, data: []float32{0.5, 1}},
+		{name: "labels", shape: []int{1}, data: []int32{7}},
+	}
+	path := filepath.Join(t.TempDir(), "shard.npz")
+	if err := writeNPZ(path, arrays); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != len(arrays) {
+		t.Fatalf("%d members, want %d", len(zr.File), len(arrays))
+	}
+	for i, f := range zr.File {
+		if want := arrays[i].name + ".npy"; f.Name != want {
+			t.Errorf("member %d is %s, want %s", i, f.Name, want)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := arrays[i].encode()
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s does not match its encoding", f.Name)
+		}
+	}
+	bad := append(arrays, npyArray{name: "bad", data: "x"})
+	if err := writeNPZ(filepath.Join(t.TempDir(), "bad.npz"), bad); err == nil {
+		t.Error("writeNPZ accepted an unsupported array")
+	}
+}
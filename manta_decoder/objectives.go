@@ -0,0 +1,254 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+const (
+	tormentorUnit = "npc_dota_miniboss"
+	// tormentorShardWindow is how long after a Tormentor kill a shard
+	// modifier gained by the killing team is credited to that kill (ticks).
+	tormentorShardWindow = 10 * 30
+	// tormentorReach bounds how far a hero may stand from a Tormentor for
+	// its damage to count towards that Tormentor rather than the other one.
+	tormentorReach = 2000
+)
+
+var lotusItems = map[string]bool{
+	"item_famango":         true,
+	"item_great_famango":   true,
+	"item_greater_famango": true,
+}
+
+type tormentor struct {
+	index  int32
+	where  mapcoord.World
+	spawn  float32
+	first  float32
+	damage map[string]int
+}
+
+type tormentorKill struct {
+	rec       map[string]any
+	team      uint32
+	deadline  uint32
+	recipient []string
+}
+
+type objectiveTracker struct {
+	parser     *manta.Parser
+	rules      *gameRulesRef
+	out        *outputState
+	wrote      *int
+	tormentors map[int32]*tormentor
+	kills      []*tormentorKill
+	charges    map[int32]int32
+}
+
+func (t *objectiveTracker) write(rec map[string]any) error {
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+// nearest returns the live Tormentor closest to the named hero, since both
+// share one combat log name.
+func (t *objectiveTracker) nearest(hero string) *tormentor {
+	h := heroEntityByName(t.parser, hero)
+	if h == nil {
+		return nil
+	}
+	pos, ok := entityWorldPosition(h)
+	if !ok {
+		return nil
+	}
+	var best *tormentor
+	bestDist := float64(tormentorReach)
+	for _, tm := range t.tormentors {
+		if d := mapcoord.Distance2D(pos, tm.where); d <= bestDist {
+			best, bestDist = tm, d
+		}
+	}
+	return best
+}
+
+func (t *objectiveTracker) onEntity(e *manta.Entity, op manta.EntityOp) error {
+	if err := t.flushKills(false); err != nil {
+		return err
+	}
+	switch {
+	case strings.HasPrefix(e.GetClassName(), "CDOTA_Item"):
+		return t.onItem(e, op)
+	case op.Flag(manta.EntityOpDeleted):
+		delete(t.tormentors, e.GetIndex())
+	case op.Flag(manta.EntityOpCreated):
+		if entityUnitName(t.parser, e) != tormentorUnit {
+			return nil
+		}
+		pos, _ := entityWorldPosition(e)
+		tm := &tormentor{
+			index:  e.GetIndex(),
+			where:  pos,
+			spawn:  t.rules.now(),
+			damage: map[string]int{},
+		}
+		t.tormentors[tm.index] = tm
+		return t.write(map[string]any{
+			"kind":      "tormentor",
+			"event":     "spawn",
+			"tick":      t.parser.Tick,
+			"time":      tm.spawn,
+			"position":  pos,
+			"minimap":   pos.Minimap(),
+			"zone":      mapcoord.ZoneOf(pos),
+			"entity_id": tm.index,
+		})
+	}
+	return nil
+}
+
+// onItem reports lotus pickups: a new lotus item in a hero's inventory, or
+// an existing stack gaining charges.
+func (t *objectiveTracker) onItem(e *manta.Entity, op manta.EntityOp) error {
+	if op.Flag(manta.EntityOpDeleted) {
+		delete(t.charges, e.GetIndex())
+		return nil
+	}
+	item := entityUnitName(t.parser, e)
+	if !lotusItems[item] {
+		return nil
+	}
+	charges, _ := e.GetInt32("m_iCurrentCharges")
+	if charges <= 0 {
+		charges = 1
+	}
+	prev := t.charges[e.GetIndex()]
+	t.charges[e.GetIndex()] = charges
+	if charges <= prev {
+		return nil
+	}
+	h, ok := e.GetUint32("m_hOwnerEntity")
+	if !ok || h == invalidHandle {
+		return nil
+	}
+	owner := t.parser.FindEntityByHandle(uint64(h))
+	if owner == nil || !isHeroEntity(owner) {
+		return nil
+	}
+	rec := map[string]any{
+		"kind":  "lotus",
+		"tick":  t.parser.Tick,
+		"time":  t.rules.now(),
+		"hero":  entityUnitName(t.parser, owner),
+		"team":  entityTeam(owner),
+		"item":  item,
+		"count": charges - prev,
+	}
+	if pos, ok := entityWorldPosition(owner); ok {
+		rec["position"] = pos
+		rec["zone"] = mapcoord.ZoneOf(pos)
+	}
+	return t.write(rec)
+}
+
+func (t *objectiveTracker) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	name := func(idx uint32) string { return lookupCombatLogName(t.parser, idx) }
+	clock := t.rules.clock(m.GetTimestamp())
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE:
+		if name(m.GetTargetName()) != tormentorUnit || !m.GetIsAttackerHero() || m.GetIsAttackerIllusion() {
+			return nil
+		}
+		hero := name(m.GetAttackerName())
+		if tm := t.nearest(hero); tm != nil {
+			if len(tm.damage) == 0 {
+				tm.first = clock
+			}
+			tm.damage[hero] += int(m.GetValue())
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		if name(m.GetTargetName()) != tormentorUnit {
+			return nil
+		}
+		killer := name(m.GetAttackerName())
+		rec := map[string]any{
+			"kind":   "tormentor",
+			"event":  "kill",
+			"tick":   t.parser.Tick,
+			"time":   clock,
+			"killer": killer,
+			"team":   m.GetAttackerTeam(),
+		}
+		if tm := t.nearest(killer); tm != nil {
+			participants := make([]string, 0, len(tm.damage))
+			for hero := range tm.damage {
+				participants = append(participants, hero)
+			}
+			sort.Strings(participants)
+			rec["participants"] = participants
+			rec["damage"] = tm.damage
+			rec["entity_id"] = tm.index
+			rec["position"] = tm.where
+			rec["zone"] = mapcoord.ZoneOf(tm.where)
+			if len(tm.damage) > 0 {
+				rec["duration"] = clock - tm.first
+			}
+			delete(t.tormentors, tm.index)
+		}
+		t.kills = append(t.kills, &tormentorKill{
+			rec:      rec,
+			team:     m.GetAttackerTeam(),
+			deadline: t.parser.Tick + tormentorShardWindow,
+		})
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD:
+		if name(m.GetInflictorName()) != "modifier_item_aghanims_shard" || !m.GetIsTargetHero() || m.GetIsTargetIllusion() {
+			return nil
+		}
+		for _, k := range t.kills {
+			if k.team == m.GetTargetTeam() {
+				k.recipient = append(k.recipient, name(m.GetTargetName()))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// flushKills writes Tormentor kills once their shard window has passed, or
+// all of them when force is set.
+func (t *objectiveTracker) flushKills(force bool) error {
+	kept := t.kills[:0]
+	for _, k := range t.kills {
+		if !force && t.parser.Tick < k.deadline {
+			kept = append(kept, k)
+			continue
+		}
+		k.rec["shard_recipients"] = k.recipient
+		if err := t.write(k.rec); err != nil {
+			return err
+		}
+	}
+	t.kills = kept
+	return nil
+}
+
+func registerObjectives(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	t := &objectiveTracker{
+		parser:     parser,
+		rules:      newGameRulesRef(parser),
+		out:        out,
+		wrote:      wrote,
+		tormentors: map[int32]*tormentor{},
+		charges:    map[int32]int32{},
+	}
+	subscribeCombatLog(parser, t.onCombatLog)
+	parser.OnEntity(t.onEntity)
+	return func() error {
+		return t.flushKills(true)
+	}
+}
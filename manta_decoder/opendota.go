@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// openDotaIntervalTicks matches OpenDota's one interval entry per player per
+// game second.
+const openDotaIntervalTicks = 30
+
+// openDotaWriter emits records shaped like the entries of OpenDota's Java
+// parser: flat objects keyed by "type" with times in whole seconds since the
+// horn.
+type openDotaWriter struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+}
+
+func (w *openDotaWriter) time(gameTime float32) int {
+	return int(math.Round(float64(w.rules.clock(gameTime))))
+}
+
+func (w *openDotaWriter) now() int {
+	return int(math.Round(float64(w.rules.now())))
+}
+
+func (w *openDotaWriter) write(entry map[string]any, matches bool) error {
+	(*w.wrote)++
+	return w.out.add(w.parser.Tick, entry, matches)
+}
+
+func (w *openDotaWriter) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	name := func(idx uint32) string { return lookupCombatLogName(w.parser, idx) }
+	entry := map[string]any{
+		"time":                 w.time(m.GetTimestamp()),
+		"type":                 dota.DOTA_COMBATLOG_TYPES_name[int32(m.GetType())],
+		"attackername":         name(m.GetAttackerName()),
+		"targetname":           name(m.GetTargetName()),
+		"sourcename":           name(m.GetDamageSourceName()),
+		"targetsourcename":     name(m.GetTargetSourceName()),
+		"inflictor":            name(m.GetInflictorName()),
+		"attackerhero":         m.GetIsAttackerHero(),
+		"targethero":           m.GetIsTargetHero(),
+		"attackerillusion":     m.GetIsAttackerIllusion(),
+		"targetillusion":       m.GetIsTargetIllusion(),
+		"abilitylevel":         m.GetAbilityLevel(),
+		"value":                m.GetValue(),
+		"gold_reason":          m.GetGoldReason(),
+		"xp_reason":            m.GetXpReason(),
+		"stun_duration":        m.GetStunDuration(),
+		"slow_duration":        m.GetSlowDuration(),
+		"greevils_greed_stack": m.GetStackCount(),
+	}
+	if m.GetType() == dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_PURCHASE {
+		entry["valuename"] = name(m.GetValue())
+	}
+	return w.write(entry, isLunaEclipseCast(w.parser, m))
+}
+
+func (w *openDotaWriter) onInterval() error {
+	if w.rules.get() == nil {
+		return nil
+	}
+	pr := findPlayerResource(w.parser)
+	data := map[uint32]*manta.Entity{
+		teamRadiant: teamDataEntity(w.parser, teamRadiant),
+		teamDire:    teamDataEntity(w.parser, teamDire),
+	}
+	for _, p := range listPlayers(w.parser) {
+		entry := map[string]any{
+			"time":    w.now(),
+			"type":    "interval",
+			"slot":    p.Slot(),
+			"hero_id": p.HeroID,
+		}
+		if pr != nil {
+			for key, field := range map[string]string{
+				"level":   "m_iLevel",
+				"kills":   "m_iKills",
+				"deaths":  "m_iDeaths",
+				"assists": "m_iAssists",
+			} {
+				entry[key], _ = pr.GetInt32(playerTeamField(p.ID, field))
+			}
+		}
+		if d := data[p.Team]; d != nil {
+			for key, field := range map[string]string{
+				"gold":     "m_iTotalEarnedGold",
+				"lh":       "m_iLastHitCount",
+				"denies":   "m_iDenyCount",
+				"xp":       "m_iTotalEarnedXP",
+				"networth": "m_iNetWorth",
+			} {
+				entry[key], _ = d.GetInt32(teamDataField(p.TeamSlot, field))
+			}
+			entry["stuns"], _ = d.GetFloat32(teamDataField(p.TeamSlot, "m_fStuns"))
+		}
+		if p.Hero != nil {
+			entry["unit"] = p.HeroName
+			entry["life_state"], _ = p.Hero.GetInt32("m_lifeState")
+			if pos, ok := entityWorldPosition(p.Hero); ok {
+				entry["x"], entry["y"] = pos.CellUnits()
+			}
+		}
+		if err := w.write(entry, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *openDotaWriter) onFileInfo(m *dota.CDemoFileInfo) error {
+	key, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return w.write(map[string]any{
+		"time": w.now(),
+		"type": "epilogue",
+		"key":  string(key),
+	}, false)
+}
+
+func (w *openDotaWriter) onChat(m *dota.CDOTAUserMsg_ChatMessage) error {
+	slot := int32(-1)
+	for _, p := range listPlayers(w.parser) {
+		if p.ID == m.GetSourcePlayerId() {
+			slot = p.Slot()
+		}
+	}
+	return w.write(map[string]any{
+		"time": w.now(),
+		"type": "chat",
+		"slot": slot,
+		"key":  m.GetMessageText(),
+	}, false)
+}
+
+// registerOpenDota replaces the raw callback/game_event records with
+// OpenDota-shaped combat log, interval, chat and epilogue entries.
+func registerOpenDota(parser *manta.Parser, out *outputState, wrote *int) {
+	w := &openDotaWriter{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+	}
+	subscribeCombatLog(parser, w.onCombatLog)
+	parser.Callbacks.OnCDemoFileInfo(w.onFileInfo)
+	parser.Callbacks.OnCDOTAUserMsg_ChatMessage(w.onChat)
+	sampler := newTickSampler(openDotaIntervalTicks)
+	parser.OnEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		return w.onInterval()
+	})
+}
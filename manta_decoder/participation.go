@@ -0,0 +1,258 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+// presenceRadius is how close (in world units) a hero must be to a death in
+// a fight to count as present even without dealing damage.
+const presenceRadius = 1500
+
+type heroParticipation struct {
+	Team       uint32   `json:"team"`
+	Present    bool     `json:"present"`
+	HeroDamage uint64   `json:"hero_damage"`
+	Kills      int      `json:"kills"`
+	Assists    int      `json:"assists"`
+	Deaths     int      `json:"deaths"`
+	Ultimates  []string `json:"ultimates"`
+}
+
+type participationTable map[string]*heroParticipation
+
+func (t participationTable) hero(name string, team uint32) *heroParticipation {
+	p, ok := t[name]
+	if !ok {
+		p = &heroParticipation{Ultimates: []string{}}
+		t[name] = p
+	}
+	if team != 0 {
+		p.Team = team
+	}
+	return p
+}
+
+func (t participationTable) teamKills() map[uint32]int {
+	kills := map[uint32]int{}
+	for _, p := range t {
+		kills[p.Team] += p.Kills
+	}
+	return kills
+}
+
+func (t participationTable) heroes() []string {
+	names := make([]string, 0, len(t))
+	for h := range t {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type gameParticipation struct {
+	Team          uint32 `json:"team"`
+	Fights        int    `json:"fights"`
+	FightsPresent int    `json:"fights_present"`
+	Kills         int    `json:"kills"`
+	Assists       int    `json:"assists"`
+	Deaths        int    `json:"deaths"`
+	UltimatesUsed int    `json:"ultimates_used"`
+}
+
+type participationStats struct {
+	parser  *manta.Parser
+	fights  *fightDetector
+	out     *outputState
+	wrote   *int
+	byFight map[int]participationTable
+	game    map[string]*gameParticipation
+}
+
+func (s *participationStats) gameHero(name string, team uint32) *gameParticipation {
+	g, ok := s.game[name]
+	if !ok {
+		g = &gameParticipation{}
+		s.game[name] = g
+	}
+	if team != 0 {
+		g.Team = team
+	}
+	return g
+}
+
+func (s *participationStats) fightTable() participationTable {
+	f := s.fights.current()
+	if f == nil {
+		return nil
+	}
+	t, ok := s.byFight[f.ID]
+	if !ok {
+		t = participationTable{}
+		s.byFight[f.ID] = t
+	}
+	return t
+}
+
+func (s *participationStats) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	attacker := lookupCombatLogName(s.parser, m.GetAttackerName())
+	target := lookupCombatLogName(s.parser, m.GetTargetName())
+	attackerHero := m.GetIsAttackerHero() && !m.GetIsAttackerIllusion()
+	targetHero := m.GetIsTargetHero() && !m.GetIsTargetIllusion()
+	table := s.fightTable()
+
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE:
+		if table != nil && attackerHero && targetHero && m.GetAttackerTeam() != m.GetTargetTeam() {
+			p := table.hero(attacker, m.GetAttackerTeam())
+			p.Present = true
+			p.HeroDamage += uint64(m.GetValue())
+			table.hero(target, m.GetTargetTeam()).Present = true
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ABILITY:
+		if !attackerHero || !m.GetIsUltimateAbility() {
+			return nil
+		}
+		s.gameHero(attacker, m.GetAttackerTeam()).UltimatesUsed++
+		if table != nil {
+			p := table.hero(attacker, m.GetAttackerTeam())
+			p.Ultimates = append(p.Ultimates, lookupCombatLogName(s.parser, m.GetInflictorName()))
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		if !targetHero {
+			return nil
+		}
+		s.onHeroDeath(m, table, attacker, target, attackerHero)
+	}
+	return nil
+}
+
+func (s *participationStats) onHeroDeath(m *dota.CMsgDOTACombatLogEntry, table participationTable, attacker, target string, attackerHero bool) {
+	s.gameHero(target, m.GetTargetTeam()).Deaths++
+	killed := attackerHero && m.GetAttackerTeam() != m.GetTargetTeam()
+	if killed {
+		s.gameHero(attacker, m.GetAttackerTeam()).Kills++
+	}
+	var assists []string
+	if ids := m.GetAssistPlayers(); len(ids) > 0 {
+		byID := heroNamesByPlayerID(s.parser)
+		for _, id := range ids {
+			if name, ok := byID[id]; ok && name != attacker {
+				assists = append(assists, name)
+				s.gameHero(name, m.GetAttackerTeam()).Assists++
+			}
+		}
+	}
+	if table == nil {
+		return
+	}
+
+	victim := table.hero(target, m.GetTargetTeam())
+	victim.Present = true
+	victim.Deaths++
+	if killed {
+		p := table.hero(attacker, m.GetAttackerTeam())
+		p.Present = true
+		p.Kills++
+	}
+	for _, name := range assists {
+		p := table.hero(name, m.GetAttackerTeam())
+		p.Present = true
+		p.Assists++
+	}
+
+	where := mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())}
+	for _, e := range realHeroes(s.parser) {
+		pos, ok := entityWorldPosition(e)
+		if !ok || mapcoord.Distance2D(pos, where) > presenceRadius {
+			continue
+		}
+		name := entityUnitName(s.parser, e)
+		if name == "" {
+			continue
+		}
+		team, _ := e.GetInt32("m_iTeamNum")
+		table.hero(name, uint32(team)).Present = true
+	}
+}
+
+func (s *participationStats) onFightClose(f *fight, record map[string]any) error {
+	table := s.byFight[f.ID]
+	delete(s.byFight, f.ID)
+	if record == nil {
+		return nil
+	}
+	teamKills := table.teamKills()
+	for _, hero := range table.heroes() {
+		p := table[hero]
+		g := s.gameHero(hero, p.Team)
+		g.Fights++
+		if p.Present {
+			g.FightsPresent++
+		}
+		rec := map[string]any{
+			"kind":     "participation",
+			"tick":     s.parser.Tick,
+			"fight_id": f.ID,
+			"hero":     hero,
+			"stats":    p,
+		}
+		if kills := teamKills[p.Team]; kills > 0 {
+			rec["kill_involvement"] = float64(p.Kills+p.Assists) / float64(kills)
+		}
+		(*s.wrote)++
+		if err := s.out.add(s.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *participationStats) finalize() error {
+	teamKills := map[uint32]int{}
+	for _, g := range s.game {
+		teamKills[g.Team] += g.Kills
+	}
+	names := make([]string, 0, len(s.game))
+	for h := range s.game {
+		names = append(names, h)
+	}
+	sort.Strings(names)
+	for _, hero := range names {
+		g := s.game[hero]
+		rec := map[string]any{
+			"kind":  "participation_game",
+			"tick":  s.parser.Tick,
+			"hero":  hero,
+			"stats": g,
+		}
+		if kills := teamKills[g.Team]; kills > 0 {
+			rec["kill_involvement"] = float64(g.Kills+g.Assists) / float64(kills)
+		}
+		(*s.wrote)++
+		if err := s.out.add(s.parser.Tick, rec, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerParticipation returns a finalizer that must run after the fight
+// detector's, so the last fight's per-hero stats feed the game totals.
+func registerParticipation(parser *manta.Parser, out *outputState, fights *fightDetector, wrote *int) func() error {
+	stats := &participationStats{
+		parser:  parser,
+		fights:  fights,
+		out:     out,
+		wrote:   wrote,
+		byFight: map[int]participationTable{},
+		game:    map[string]*gameParticipation{},
+	}
+	subscribeCombatLog(parser, stats.onCombatLog)
+	fights.subscribe(stats.onFightClose)
+	return stats.finalize
+}
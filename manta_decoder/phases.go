@@ -0,0 +1,305 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+const (
+	phasePregame    = "pregame"
+	phaseLaning     = "laning"
+	phaseMidGame    = "mid_game"
+	phaseHighGround = "high_ground"
+	phaseLateGame   = "late_game"
+)
+
+const (
+	// Laning ends once two tier 1 towers have fallen or the average hero
+	// reaches phaseLaningLevel, but not before phaseLaningMin, and at
+	// phaseLaningMax regardless (game clock seconds).
+	phaseLaningMin    = 8 * 60
+	phaseLaningMax    = 14 * 60
+	phaseLaningLevel  = 10
+	phaseLaningTowers = 2
+	// The late game starts when the average hero net worth reaches
+	// phaseLateNetWorth, or at phaseLateMax.
+	phaseLateNetWorth = 16000
+	phaseLateMax      = 45 * 60
+	// A high ground siege is phaseSiegeHeroes living heroes within
+	// phaseSiegeRadius of an enemy high ground building; it lasts until
+	// phaseSiegeGrace seconds pass without that.
+	phaseSiegeHeroes = 3
+	phaseSiegeRadius = 1400
+	phaseSiegeGrace  = 15
+	// phaseSampleTicks is how often positions and economy are checked.
+	phaseSampleTicks = 30
+)
+
+type phaseChange struct {
+	tick  uint32
+	clock float32
+	phase string
+}
+
+// phaseTracker segments the match into pregame, laning, mid game, high
+// ground sieges and late game from tower kills, hero positions, levels and
+// net worth, writing a phase record at every change and tagging every other
+// record with the phase it belongs to.
+type phaseTracker struct {
+	parser    *manta.Parser
+	rules     *gameRulesRef
+	out       *outputState
+	wrote     *int
+	state     *stateContext
+	sampler   *tickSampler
+	changes   []phaseChange
+	base      string
+	tier1Down int
+	siegeLast float32
+	sieging   bool
+}
+
+// towerTier returns the tier in a tower unit name such as
+// npc_dota_goodguys_tower3_mid.
+func towerTier(name string) int {
+	_, rest, ok := strings.Cut(name, "_tower")
+	if !ok || rest == "" {
+		return 0
+	}
+	tier, err := strconv.Atoi(rest[:1])
+	if err != nil {
+		return 0
+	}
+	return tier
+}
+
+// isHighGroundBuilding reports whether a building stands on a team's high
+// ground: tier 3 and 4 towers, barracks and the ancient.
+func isHighGroundBuilding(parser *manta.Parser, e *manta.Entity) bool {
+	if !isBuildingEntity(e) || !isAlive(e) {
+		return false
+	}
+	name := entityUnitName(parser, e)
+	label, _ := buildingLabel(name)
+	return label != "tower" || towerTier(name) >= 3
+}
+
+func (t *phaseTracker) current() string {
+	if n := len(t.changes); n > 0 {
+		return t.changes[n-1].phase
+	}
+	return phasePregame
+}
+
+// phaseAt returns the phase at clock.
+func (t *phaseTracker) phaseAt(clock float32) string {
+	for i := len(t.changes) - 1; i >= 0; i-- {
+		if t.changes[i].clock <= clock {
+			return t.changes[i].phase
+		}
+	}
+	return phasePregame
+}
+
+func (t *phaseTracker) set(phase, reason string, clock float32) error {
+	previous := t.current()
+	if phase == previous {
+		return nil
+	}
+	t.changes = append(t.changes, phaseChange{tick: t.parser.Tick, clock: clock, phase: phase})
+	rec := map[string]any{
+		"kind":     "phase",
+		"tick":     t.parser.Tick,
+		"time":     clock,
+		"phase":    phase,
+		"previous": previous,
+		"reason":   reason,
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func (t *phaseTracker) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH {
+		return nil
+	}
+	if towerTier(lookupCombatLogName(t.parser, m.GetTargetName())) == 1 {
+		t.tier1Down++
+	}
+	return nil
+}
+
+// averages returns the average level and net worth of the real heroes.
+func (t *phaseTracker) averages() (level, netWorth float64) {
+	heroes := realHeroes(t.parser)
+	if len(heroes) == 0 {
+		return 0, 0
+	}
+	for _, h := range heroes {
+		l, _ := h.GetInt32("m_iCurrentLevel")
+		level += float64(l)
+	}
+	worths := t.state.netWorths()
+	for _, w := range worths {
+		netWorth += float64(w)
+	}
+	level /= float64(len(heroes))
+	if len(worths) > 0 {
+		netWorth /= float64(len(worths))
+	}
+	return level, netWorth
+}
+
+// sieged reports whether enough heroes of one team stand near a living
+// high ground building of the other.
+func (t *phaseTracker) sieged() bool {
+	for _, b := range t.parser.FilterEntity(func(e *manta.Entity) bool {
+		return isHighGroundBuilding(t.parser, e)
+	}) {
+		pos, ok := entityWorldPosition(b)
+		if !ok {
+			continue
+		}
+		attackers := 0
+		for _, h := range heroesWithin(t.parser, pos, phaseSiegeRadius) {
+			if isAlive(h) && entityTeam(h) != entityTeam(b) {
+				attackers++
+			}
+		}
+		if attackers >= phaseSiegeHeroes {
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves the base phase forward; it never goes back.
+func (t *phaseTracker) advance(clock float32) string {
+	switch t.base {
+	case "":
+		t.base = phaseLaning
+		return "horn"
+	case phaseLaning:
+		if clock >= phaseLaningMax {
+			t.base = phaseMidGame
+			return "time"
+		}
+		if clock < phaseLaningMin {
+			return ""
+		}
+		if t.tier1Down >= phaseLaningTowers {
+			t.base = phaseMidGame
+			return "tier1_towers"
+		}
+		if level, _ := t.averages(); level >= phaseLaningLevel {
+			t.base = phaseMidGame
+			return "levels"
+		}
+	case phaseMidGame:
+		if clock >= phaseLateMax {
+			t.base = phaseLateGame
+			return "time"
+		}
+		if _, worth := t.averages(); worth >= phaseLateNetWorth {
+			t.base = phaseLateGame
+			return "net_worth"
+		}
+	}
+	return ""
+}
+
+func (t *phaseTracker) onEntity(_ *manta.Entity, _ manta.EntityOp) error {
+	if !t.sampler.due(t.parser.Tick) {
+		return nil
+	}
+	rules := t.rules.get()
+	if rules == nil {
+		return nil
+	}
+	if start, _ := rules.GetFloat32("m_pGameRules.m_flGameStartTime"); start <= 0 {
+		return nil
+	}
+	clock := t.rules.now()
+	if clock < 0 {
+		return nil
+	}
+	reason := t.advance(clock)
+	if t.base != phaseLaning && t.sieged() {
+		if !t.sieging {
+			reason = "siege"
+		}
+		t.sieging = true
+		t.siegeLast = clock
+	} else if t.sieging && clock-t.siegeLast > phaseSiegeGrace {
+		t.sieging = false
+		reason = "siege_ended"
+	}
+	if reason == "" {
+		return nil
+	}
+	phase := t.base
+	if t.sieging {
+		phase = phaseHighGround
+	}
+	return t.set(phase, reason, clock)
+}
+
+// annotate adds the phase to a record: the phase its interval started in,
+// or the current one.
+func (t *phaseTracker) annotate(rec map[string]any) {
+	switch rec["kind"] {
+	case "phase", "phases":
+		return
+	}
+	if start, ok := clockField(rec, "start"); ok {
+		rec["phase"] = t.phaseAt(start)
+		return
+	}
+	rec["phase"] = t.current()
+}
+
+// finish writes the segments of the match as one phases record.
+func (t *phaseTracker) finish() error {
+	end := t.rules.now()
+	segments := make([]map[string]any, 0, len(t.changes))
+	durations := map[string]float32{}
+	for i, c := range t.changes {
+		to := end
+		if i+1 < len(t.changes) {
+			to = t.changes[i+1].clock
+		}
+		segments = append(segments, map[string]any{
+			"phase":      c.phase,
+			"start_tick": c.tick,
+			"start":      c.clock,
+			"end":        to,
+			"duration":   to - c.clock,
+		})
+		durations[c.phase] += to - c.clock
+	}
+	rec := map[string]any{
+		"kind":      "phases",
+		"tick":      t.parser.Tick,
+		"segments":  segments,
+		"durations": durations,
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func registerPhases(parser *manta.Parser, out *outputState, wrote *int) (*phaseTracker, func() error) {
+	t := &phaseTracker{
+		parser:  parser,
+		rules:   newGameRulesRef(parser),
+		out:     out,
+		wrote:   wrote,
+		state:   newStateContext(parser, 0),
+		sampler: newTickSampler(phaseSampleTicks),
+	}
+	subscribeCombatLog(parser, t.onCombatLog)
+	parser.OnEntity(t.onEntity)
+	return t, t.finish
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dotabuff/manta"
+)
+
+// maxPlayers bounds the per-player arrays on CDOTA_PlayerResource, which
+// also hold spectator and coach slots.
+const maxPlayers = 24
+
+// playerInfo ties a player ID from CDOTA_PlayerResource to their team slot
+// and selected hero.
+type playerInfo struct {
+	ID       int32
+	Team     uint32
+	TeamSlot int32
+	HeroID   int32
+	Hero     *manta.Entity
+	HeroName string
+}
+
+// Slot is the 0-9 player slot used by OpenDota and the scoreboard: Radiant
+// team slots first, then Dire.
+func (p playerInfo) Slot() int32 {
+	if p.Team == teamDire {
+		return p.TeamSlot + 5
+	}
+	return p.TeamSlot
+}
+
+func findPlayerResource(parser *manta.Parser) *manta.Entity {
+	found := parser.FilterEntity(func(e *manta.Entity) bool {
+		return e.GetClassName() == "CDOTA_PlayerResource"
+	})
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+func playerTeamField(id int32, field string) string {
+	return fmt.Sprintf("m_vecPlayerTeamData.%04d.%s", id, field)
+}
+
+func playerDataField(id int32, field string) string {
+	return fmt.Sprintf("m_vecPlayerData.%04d.%s", id, field)
+}
+
+// listPlayers returns Radiant and Dire players in player ID order.
+func listPlayers(parser *manta.Parser) []playerInfo {
+	pr := findPlayerResource(parser)
+	if pr == nil {
+		return nil
+	}
+	var players []playerInfo
+	for id := int32(0); id < maxPlayers; id++ {
+		team, ok := pr.GetInt32(playerDataField(id, "m_iPlayerTeam"))
+		if !ok || (team != teamRadiant && team != teamDire) {
+			continue
+		}
+		p := playerInfo{ID: id, Team: uint32(team)}
+		p.TeamSlot, _ = pr.GetInt32(playerTeamField(id, "m_iTeamSlot"))
+		p.HeroID, _ = pr.GetInt32(playerTeamField(id, "m_nSelectedHeroID"))
+		if h, ok := pr.GetUint32(playerTeamField(id, "m_hSelectedHero")); ok && h != invalidHandle {
+			p.Hero = parser.FindEntityByHandle(uint64(h))
+		}
+		if p.Hero != nil {
+			p.HeroName = entityUnitName(parser, p.Hero)
+		}
+		players = append(players, p)
+	}
+	return players
+}
+
+// teamDataEntity returns the CDOTA_DataRadiant or CDOTA_DataDire entity
+// holding per-team-slot economy stats.
+func teamDataEntity(parser *manta.Parser, team uint32) *manta.Entity {
+	class := "CDOTA_DataRadiant"
+	if team == teamDire {
+		class = "CDOTA_DataDire"
+	}
+	found := parser.FilterEntity(func(e *manta.Entity) bool {
+		return e.GetClassName() == class
+	})
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+func teamDataField(slot int32, field string) string {
+	return fmt.Sprintf("m_vecDataTeam.%04d.%s", slot, field)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseKinds reads a comma-separated record kind list; an empty list means
+// every kind and yields nil.
+func parseKinds(list string) map[string]bool {
+	if list == "" {
+		return nil
+	}
+	kinds := map[string]bool{}
+	for _, k := range strings.Split(list, ",") {
+		kinds[strings.TrimSpace(k)] = true
+	}
+	return kinds
+}
+
+func recordKind(v any) string {
+	rec, _ := v.(map[string]any)
+	kind, _ := rec["kind"].(string)
+	return kind
+}
+
+// pluginMaxLine bounds one derived record read back from a plugin.
+const pluginMaxLine = 16 << 20
+
+// plugin is an external extractor process. It reads records as JSONL on
+// stdin and may write derived records as JSONL on stdout at any time; both
+// streams end when the replay does.
+type plugin struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	buf   *bufio.Writer
+	enc   *json.Encoder
+	done  chan error
+	count int
+	// failed is closed once err is set by a bad derived record; the plugin's
+	// output is still drained so it never blocks, but it gets no more input.
+	failed chan struct{}
+	err    error
+}
+
+// pluginEncoder passes records to the output encoder and tees them to every
+// plugin, merging the records plugins write back into the same output.
+type pluginEncoder struct {
+	mu      sync.Mutex
+	inner   recordEncoder
+	kinds   map[string]bool
+	plugins []*plugin
+}
+
+func newPluginEncoder(inner recordEncoder, commands []string, kinds string) (*pluginEncoder, error) {
+	p := &pluginEncoder{inner: inner, kinds: parseKinds(kinds)}
+	for _, command := range commands {
+		if err := p.start(command); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *pluginEncoder) start(command string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("empty plugin command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start plugin %q: %w", args[0], err)
+	}
+	buf := bufio.NewWriter(stdin)
+	pl := &plugin{
+		name:   args[0],
+		cmd:    cmd,
+		stdin:  stdin,
+		buf:    buf,
+		enc:    json.NewEncoder(buf),
+		done:   make(chan error, 1),
+		failed: make(chan struct{}),
+	}
+	p.plugins = append(p.plugins, pl)
+	go func() { pl.done <- p.readDerived(pl, stdout) }()
+	return nil
+}
+
+// readDerived merges a plugin's output records, tagging each with the
+// plugin that produced it. After the first error the rest of the output is
+// discarded, so a plugin blocked writing cannot stop reading its input and
+// hang Encode.
+func (p *pluginEncoder) readDerived(pl *plugin, r io.Reader) error {
+	err := p.mergeDerived(pl, r)
+	if err != nil {
+		pl.err = err
+		close(pl.failed)
+		io.Copy(io.Discard, r)
+	}
+	return err
+}
+
+func (p *pluginEncoder) mergeDerived(pl *plugin, r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), pluginMaxLine)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return fmt.Errorf("plugin %s: bad record: %w", pl.name, err)
+		}
+		if _, ok := rec["kind"]; !ok {
+			rec["kind"] = "plugin"
+		}
+		rec["plugin"] = pl.name
+		p.mu.Lock()
+		err := p.inner.Encode(rec)
+		p.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		pl.count++
+	}
+	return sc.Err()
+}
+
+func (p *pluginEncoder) Encode(v any) error {
+	p.mu.Lock()
+	err := p.inner.Encode(v)
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if p.kinds != nil && !p.kinds[recordKind(v)] {
+		return nil
+	}
+	for _, pl := range p.plugins {
+		select {
+		case <-pl.failed:
+			return pl.err
+		default:
+		}
+		if err := pl.enc.Encode(v); err != nil {
+			return fmt.Errorf("plugin %s: %w", pl.name, err)
+		}
+	}
+	return nil
+}
+
+// close ends every plugin's input and waits for it to finish writing
+// derived records and exit.
+func (p *pluginEncoder) close() error {
+	var first error
+	keep := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	for _, pl := range p.plugins {
+		keep(pl.buf.Flush())
+		keep(pl.stdin.Close())
+	}
+	for _, pl := range p.plugins {
+		keep(<-pl.done)
+		if err := pl.cmd.Wait(); err != nil {
+			keep(fmt.Errorf("plugin %s: %w", pl.name, err))
+		}
+		logger.Info("plugin finished", "plugin", pl.name, "records", pl.count)
+	}
+	return first
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type discardEncoder struct{}
+
+func (discardEncoder) Encode(any) error { return nil }
+
+// A plugin that writes a bad record and then echoes its input must not stall
+// the decoder once its output is no longer merged.
+func TestPluginBadRecordDoesNotHang(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "plugin.sh")
+	if err := os.WriteFile(script, []byte("echo not-json\ncat\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPluginEncoder(discardEncoder{}, []string{"sh " + script}, "")
+	if err != nil {
+		t.Skip("no shell:", err)
+	}
+	rec := map[string]any{"kind": "test", "pad": strings.Repeat("x", 1024)}
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 20000; i++ {
+			if err := p.Encode(rec); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Encode never reported the bad record")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Encode blocked after a bad plugin record")
+	}
+	if err := p.close(); err == nil || !strings.Contains(err.Error(), "bad record") {
+		t.Fatalf("close() = %v, want the bad record error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+type rosterAbility struct {
+	Name         string `json:"name"`
+	Level        int32  `json:"level"`
+	Hidden       bool   `json:"hidden"`
+	Innate       bool   `json:"innate,omitempty"`
+	FacetAbility bool   `json:"facet_ability,omitempty"`
+}
+
+func rosterAbilities(parser *manta.Parser, p playerInfo) []rosterAbility {
+	abilities := []rosterAbility{}
+	if p.Hero == nil {
+		return abilities
+	}
+	for _, a := range heroAbilities(parser, p.Hero) {
+		name := entityUnitName(parser, a)
+		if name == "" || strings.HasPrefix(name, "special_bonus_") {
+			continue
+		}
+		ra := rosterAbility{Name: name}
+		ra.Level, _ = a.GetInt32("m_iLevel")
+		ra.Hidden, _ = a.GetBool("m_bHidden")
+		ra.Innate = nameMapping.isInnate(name)
+		if base, known := nameMapping.isBaseAbility(p.HeroName, name); known && !base && !ra.Innate {
+			ra.FacetAbility = true
+		}
+		abilities = append(abilities, ra)
+	}
+	return abilities
+}
+
+func rosterRecord(parser *manta.Parser, pr *manta.Entity, p playerInfo) map[string]any {
+	rec := map[string]any{
+		"kind":      "roster",
+		"tick":      parser.Tick,
+		"player_id": p.ID,
+		"team":      p.Team,
+		"slot":      p.Slot(),
+		"hero_id":   p.HeroID,
+		"hero":      p.HeroName,
+		"abilities": rosterAbilities(parser, p),
+	}
+	if steamID, ok := pr.GetUint64(playerDataField(p.ID, "m_iPlayerSteamID")); ok && steamID != 0 {
+		rec["steam_id"] = steamID
+	}
+	if name, ok := pr.GetString(playerDataField(p.ID, "m_iszPlayerName")); ok && name != "" {
+		rec["player_name"] = name
+	}
+	variant, ok := pr.GetInt32(playerTeamField(p.ID, "m_nSelectedHeroVariant"))
+	if ok {
+		rec["facet"] = variant
+		if f, ok := nameMapping.facet(p.HeroName, variant); ok {
+			rec["facet_name"] = f.Name
+			rec["facet_title"] = f.Title
+		}
+	}
+	return rec
+}
+
+// registerRoster emits one roster record per player at the end of the
+// replay, when every hero, facet and ability entity is known.
+func registerRoster(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	return func() error {
+		pr := findPlayerResource(parser)
+		if pr == nil {
+			return nil
+		}
+		for _, p := range listPlayers(parser) {
+			(*wrote)++
+			if err := out.add(parser.Tick, rosterRecord(parser, pr, p), false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+const (
+	// rotationSampleTicks is how often hero zones are sampled (1 second).
+	rotationSampleTicks = 30
+	// laneAssignmentEnd is the game clock (seconds) by which each hero's
+	// assigned lane is fixed from the zones it was seen in.
+	laneAssignmentEnd = 180
+	// rotationArriveSamples is how many consecutive samples a hero must
+	// spend in a destination zone for the move to count as an arrival.
+	rotationArriveSamples = 3
+	// rotationOutcomeWindow is how long (game seconds) after arrival kills,
+	// deaths and retreats are attributed to the rotation.
+	rotationOutcomeWindow = 20
+	// rotationEngageRadius bounds which enemies count as "met" on arrival;
+	// an enemy counts as retreated once it is farther than retreatRadius.
+	rotationEngageRadius  = 1500
+	rotationRetreatRadius = 2500
+	// rotationPathEvery thins the recorded travel path to one point per
+	// this many samples.
+	rotationPathEvery = 5
+)
+
+type rotationDeath struct {
+	clock  float32
+	victim string
+	team   uint32
+	where  mapcoord.World
+}
+
+type rotation struct {
+	hero        string
+	team        uint32
+	origin      string
+	destination string
+	departTick  uint32
+	depart      float32
+	arriveTick  uint32
+	arrive      float32
+	zones       []string
+	path        []mapcoord.Minimap
+	arrivePos   mapcoord.World
+	metEnemies  map[string]bool
+}
+
+// heroMovement tracks one hero. assigned is fixed at laneAssignmentEnd;
+// base is the lane or zone the hero last settled in, and leaving it starts
+// a candidate rotation.
+type heroMovement struct {
+	team        uint32
+	laneSamples map[string]int
+	assigned    string
+	base        string
+	away        *rotation
+	candidate   string
+	candidateN  int
+	samples     int
+}
+
+type rotationDetector struct {
+	parser  *manta.Parser
+	rules   *gameRulesRef
+	out     *outputState
+	wrote   *int
+	heroes  map[string]*heroMovement
+	pending []*rotation
+	deaths  []rotationDeath
+}
+
+func enemyJungle(team uint32) string {
+	if team == teamRadiant {
+		return mapcoord.ZoneDireJungle
+	}
+	return mapcoord.ZoneRadiantJungle
+}
+
+func (d *rotationDetector) movement(name string, team uint32) *heroMovement {
+	hm, ok := d.heroes[name]
+	if !ok {
+		hm = &heroMovement{team: team, laneSamples: map[string]int{}}
+		d.heroes[name] = hm
+	}
+	return hm
+}
+
+func (d *rotationDetector) assignLane(hm *heroMovement) {
+	best := 0
+	for lane, n := range hm.laneSamples {
+		if n > best || (n == best && lane < hm.assigned) {
+			hm.assigned, best = lane, n
+		}
+	}
+	hm.base = hm.assigned
+}
+
+func (d *rotationDetector) sample() error {
+	clock := d.rules.now()
+	heroes := realHeroes(d.parser)
+	positions := map[string]mapcoord.World{}
+	for _, h := range heroes {
+		name := entityUnitName(d.parser, h)
+		pos, ok := entityWorldPosition(h)
+		if name == "" || !ok || !isAlive(h) {
+			continue
+		}
+		positions[name] = pos
+		hm := d.movement(name, entityTeam(h))
+		zone := mapcoord.ZoneOf(pos)
+		if clock < laneAssignmentEnd {
+			if clock >= 0 && mapcoord.IsLane(zone) {
+				hm.laneSamples[zone]++
+			}
+			continue
+		}
+		if hm.assigned == "" {
+			d.assignLane(hm)
+			if hm.assigned == "" {
+				continue
+			}
+		}
+		d.track(name, hm, zone, pos, clock)
+	}
+	return d.resolve(clock, positions)
+}
+
+func (d *rotationDetector) track(name string, hm *heroMovement, zone string, pos mapcoord.World, clock float32) {
+	if hm.away == nil {
+		if zone == hm.base {
+			return
+		}
+		hm.away = &rotation{
+			hero:       name,
+			team:       hm.team,
+			origin:     hm.base,
+			departTick: d.parser.Tick,
+			depart:     clock,
+		}
+		hm.candidate, hm.candidateN, hm.samples = "", 0, 0
+	}
+
+	r := hm.away
+	if len(r.zones) == 0 || r.zones[len(r.zones)-1] != zone {
+		r.zones = append(r.zones, zone)
+	}
+	if hm.samples%rotationPathEvery == 0 {
+		r.path = append(r.path, pos.Minimap())
+	}
+	hm.samples++
+
+	if zone == hm.base || zone == mapcoord.ZoneRadiantBase || zone == mapcoord.ZoneDireBase {
+		// Back where it came from, or home, without arriving anywhere.
+		hm.away = nil
+		return
+	}
+	destination := mapcoord.IsLane(zone) || zone == enemyJungle(hm.team)
+	if !destination {
+		hm.candidate, hm.candidateN = "", 0
+		return
+	}
+	if zone != hm.candidate {
+		hm.candidate, hm.candidateN = zone, 0
+	}
+	hm.candidateN++
+	if hm.candidateN < rotationArriveSamples {
+		return
+	}
+
+	hm.away = nil
+	hm.base, hm.candidate, hm.candidateN = zone, "", 0
+	if zone == hm.assigned {
+		// Returning to the assigned lane is not an outgoing rotation.
+		return
+	}
+
+	r.destination = zone
+	r.arriveTick = d.parser.Tick
+	r.arrive = clock
+	r.arrivePos = pos
+	r.metEnemies = map[string]bool{}
+	for _, h := range realHeroes(d.parser) {
+		if entityTeam(h) == hm.team || !isAlive(h) {
+			continue
+		}
+		if p, ok := entityWorldPosition(h); ok && mapcoord.Distance2D(p, pos) <= rotationEngageRadius {
+			r.metEnemies[entityUnitName(d.parser, h)] = true
+		}
+	}
+	d.pending = append(d.pending, r)
+}
+
+func (d *rotationDetector) outcome(r *rotation, positions map[string]mapcoord.World) string {
+	kill := false
+	for _, death := range d.deaths {
+		if death.clock < r.arrive || death.clock > r.arrive+rotationOutcomeWindow {
+			continue
+		}
+		if death.victim == r.hero {
+			return "died"
+		}
+		if death.team != r.team && mapcoord.Distance2D(death.where, r.arrivePos) <= rotationRetreatRadius {
+			kill = true
+		}
+	}
+	if kill {
+		return "kill"
+	}
+	for enemy := range r.metEnemies {
+		if p, ok := positions[enemy]; ok && mapcoord.Distance2D(p, r.arrivePos) > rotationRetreatRadius {
+			return "forced_retreat"
+		}
+	}
+	return "none"
+}
+
+func (d *rotationDetector) resolve(clock float32, positions map[string]mapcoord.World) error {
+	kept := d.pending[:0]
+	for _, r := range d.pending {
+		if clock < r.arrive+rotationOutcomeWindow {
+			kept = append(kept, r)
+			continue
+		}
+		if err := d.emit(r, d.outcome(r, positions)); err != nil {
+			return err
+		}
+	}
+	d.pending = kept
+
+	horizon := clock - 2*rotationOutcomeWindow
+	deaths := d.deaths[:0]
+	for _, death := range d.deaths {
+		if death.clock >= horizon {
+			deaths = append(deaths, death)
+		}
+	}
+	d.deaths = deaths
+	return nil
+}
+
+func (d *rotationDetector) emit(r *rotation, outcome string) error {
+	met := make([]string, 0, len(r.metEnemies))
+	for h := range r.metEnemies {
+		met = append(met, h)
+	}
+	sort.Strings(met)
+	rec := map[string]any{
+		"kind":        "rotation",
+		"tick":        d.parser.Tick,
+		"hero":        r.hero,
+		"team":        r.team,
+		"lane":        d.heroes[r.hero].assigned,
+		"origin":      r.origin,
+		"destination": r.destination,
+		"depart_tick": r.departTick,
+		"depart":      r.depart,
+		"arrive_tick": r.arriveTick,
+		"arrive":      r.arrive,
+		"zones":       r.zones,
+		"path":        r.path,
+		"enemies_met": met,
+		"outcome":     outcome,
+	}
+	(*d.wrote)++
+	return d.out.add(d.parser.Tick, rec, false)
+}
+
+func (d *rotationDetector) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH || !m.GetIsTargetHero() || m.GetIsTargetIllusion() {
+		return nil
+	}
+	d.deaths = append(d.deaths, rotationDeath{
+		clock:  d.rules.clock(m.GetTimestamp()),
+		victim: lookupCombatLogName(d.parser, m.GetTargetName()),
+		team:   m.GetTargetTeam(),
+		where:  mapcoord.World{X: float64(m.GetLocationX()), Y: float64(m.GetLocationY())},
+	})
+	return nil
+}
+
+// finalize resolves rotations still inside their outcome window with what
+// has been seen so far.
+func (d *rotationDetector) finalize() error {
+	positions := map[string]mapcoord.World{}
+	for _, h := range realHeroes(d.parser) {
+		if pos, ok := entityWorldPosition(h); ok && isAlive(h) {
+			positions[entityUnitName(d.parser, h)] = pos
+		}
+	}
+	for _, r := range d.pending {
+		if err := d.emit(r, d.outcome(r, positions)); err != nil {
+			return err
+		}
+	}
+	d.pending = nil
+	return nil
+}
+
+func registerRotations(h *extractorHooks, out *outputState, wrote *int) func() error {
+	parser := h.parser()
+	d := &rotationDetector{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		heroes: map[string]*heroMovement{},
+	}
+	h.onCombatLog(d.onCombatLog)
+	sampler := newTickSampler(rotationSampleTicks)
+	h.onEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		return d.sample()
+	})
+	h.onPause(func() {
+		d.heroes = map[string]*heroMovement{}
+		d.pending, d.deaths = nil, nil
+	})
+	return d.finalize
+}
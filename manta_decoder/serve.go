@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// serveDeniedFlags are events flags a request may not set: they name
+// server-side files, start processes or send records somewhere else. Flags
+// starting with ingest are denied too.
+var serveDeniedFlags = map[string]bool{
+	"dem": true, "out": true, "latest": true, "steam-dir": true, "config": true,
+	"names": true, "aliases": true, "plugin": true, "wasm": true, "manifest": true,
+}
+
+// serveExitHeader is the trailer carrying the decoder's exit code, since
+// the status line is sent with the first record.
+const serveExitHeader = "X-Faeton-Exit-Code"
+
+// serveStderrMax bounds the decoder log kept for an error response.
+const serveStderrMax = 64 << 10
+
+// eventsFlags turns request query parameters into events flags, one flag per
+// value so repeatable flags can be given several times.
+func eventsFlags(query map[string][]string) ([]string, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		if name == "replay" {
+			continue
+		}
+		if serveDeniedFlags[name] || strings.HasPrefix(name, "ingest") || strings.HasPrefix(name, "-") {
+			return nil, fmt.Errorf("flag %q cannot be set by a request", name)
+		}
+		for _, v := range query[name] {
+			args = append(args, "-"+name+"="+v)
+		}
+	}
+	return args, nil
+}
+
+// flushWriter sends each write of the decoder's output to the client at once.
+type flushWriter struct {
+	w     http.ResponseWriter
+	wrote int64
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.wrote += int64(n)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return n, err
+}
+
+// tailBuffer keeps the last serveStderrMax bytes written to it.
+type tailBuffer struct{ bytes.Buffer }
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.Buffer.Write(p)
+	if extra := b.Len() - serveStderrMax; extra > 0 {
+		b.Next(extra)
+	}
+	return len(p), nil
+}
+
+// eventsServer runs `events` in a child process per request, so a failing
+// replay, which exits the decoder, only ends its own response.
+type eventsServer struct {
+	exe       string
+	replays   string
+	global    []string
+	maxUpload int64
+	slots     chan struct{}
+}
+
+func (s *eventsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flags, err := eventsFlags(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var dem string
+	switch r.Method {
+	case http.MethodGet:
+		name := r.URL.Query().Get("replay")
+		if s.replays == "" || name == "" || !filepath.IsLocal(name) {
+			http.Error(w, "replay must name a file in the served -replays directory", http.StatusBadRequest)
+			return
+		}
+		dem = filepath.Join(s.replays, name)
+		if info, err := os.Stat(dem); err != nil || !info.Mode().IsRegular() {
+			http.Error(w, "no such replay", http.StatusNotFound)
+			return
+		}
+	case http.MethodPost:
+		f, err := os.CreateTemp("", "faeton-*.dem")
+		if err != nil {
+			http.Error(w, "store replay", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(f.Name())
+		_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, s.maxUpload))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "replay exceeds -max-upload", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "read replay", http.StatusBadRequest)
+			return
+		}
+		dem = f.Name()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "use GET with ?replay= or POST the replay", http.StatusMethodNotAllowed)
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-r.Context().Done():
+		return
+	}
+	args := append([]string{"events"}, s.global...)
+	args = append(args, flags...)
+	args = append(args, "-dem", dem, "-out", "-")
+	cmd := exec.CommandContext(r.Context(), s.exe, args...)
+	out := &flushWriter{w: w}
+	var stderr tailBuffer
+	cmd.Stdout, cmd.Stderr = out, &stderr
+
+	contentType := "application/x-ndjson"
+	if r.URL.Query().Get("format") == "text" {
+		contentType = "text/plain; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Trailer", serveExitHeader)
+	err = cmd.Run()
+	code := exitOK
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		code = exitErr.ExitCode()
+	case err != nil:
+		logger.Error("run events", "err", err)
+		code = -1
+	}
+	if code != exitOK && code != exitWarnings {
+		logger.Info("request failed", "replay", r.URL.Query().Get("replay"), "exit", code, "records_bytes", out.wrote)
+	}
+	if out.wrote == 0 && code != exitOK && code != exitWarnings {
+		status := http.StatusInternalServerError
+		switch code {
+		case exitUsage, exitStrict:
+			status = http.StatusBadRequest
+		case exitInvalidReplay:
+			status = http.StatusUnprocessableEntity
+		}
+		w.Header().Del("Trailer")
+		w.Header().Set(serveExitHeader, strconv.Itoa(code))
+		http.Error(w, strings.TrimSpace(stderr.String()), status)
+		return
+	}
+	w.Header().Set(serveExitHeader, strconv.Itoa(code))
+}
+
+// runServe implements `serve [flags]`, serving events output over HTTP:
+// GET /events?replay=NAME decodes a replay from -replays, POST /events
+// decodes the uploaded replay, and other query parameters set events flags.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	replays := fs.String("replays", "", "directory GET requests name replays in; without it only uploaded replays are decoded")
+	jobs := fs.Int("j", 2, "replays decoded at once; further requests wait")
+	maxUpload := fs.String("max-upload", "1GiB", "largest replay a POST may upload")
+	if rest := global.parseArgs(fs, args, nil); len(rest) > 0 {
+		fatal(exitUsage, "serve takes no arguments")
+	}
+
+	limit, err := parseByteSize(*maxUpload)
+	if err != nil {
+		fatal(exitUsage, "bad -max-upload", "err", err)
+	}
+	if *jobs < 1 {
+		*jobs = 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fatal(exitIO, "find executable", "err", err)
+	}
+	s := &eventsServer{exe: exe, replays: *replays, maxUpload: int64(limit), slots: make(chan struct{}, *jobs)}
+	for _, name := range []string{"names", "aliases", "strict"} {
+		if f := fs.Lookup(name); f.Value.String() != f.DefValue {
+			s.global = append(s.global, "-"+name+"="+f.Value.String())
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/events", s)
+	logger.Info("serving", "addr", *addr, "replays", *replays)
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		fatal(exitIO, "serve", "err", err)
+	}
+}
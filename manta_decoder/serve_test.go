@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEventsFlags(t *testing.T) {
+	tests := []struct {
+		query map[string][]string
+		want  []string
+		err   bool
+	}{
+		{query: map[string][]string{"replay": {"a.dem"}}},
+		{
+			query: map[string][]string{"replay": {"a.dem"}, "raw": {"false"}, "cs": {"true"}, "plugin-kinds": {"fight"}},
+			want:  []string{"-cs=true", "-plugin-kinds=fight", "-raw=false"},
+		},
+		{query: map[string][]string{"snapshot-classes": {"a", "b"}}, want: []string{"-snapshot-classes=a", "-snapshot-classes=b"}},
+		{query: map[string][]string{"out": {"/etc/passwd"}}, err: true},
+		{query: map[string][]string{"plugin": {"sh"}}, err: true},
+		{query: map[string][]string{"ingest-dict": {"x"}}, err: true},
+		{query: map[string][]string{"-dem": {"x"}}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := eventsFlags(tt.query)
+		if (err != nil) != tt.err {
+			t.Errorf("eventsFlags(%v) error = %v, want error %v", tt.query, err, tt.err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("eventsFlags(%v) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+// fakeDecoder stands in for the binary: it prints its arguments as a record
+// and exits with the code given by -exit.
+func fakeDecoder(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "decoder")
+	script := `#!/bin/sh
+code=0
+for a in "$@"; do
+	case "$a" in -exit=*) code=${a#-exit=} ;; esac
+done
+if [ "$code" = 0 ]; then echo "{\"args\": \"$*\"}"; else echo "decode failed" >&2; fi
+exit $code
+`
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEventsServer(t *testing.T) {
+	replays := t.TempDir()
+	if err := os.WriteFile(filepath.Join(replays, "a.dem"), []byte("demo"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&eventsServer{
+		exe: fakeDecoder(t), replays: replays, global: []string{"-names=n"},
+		maxUpload: 16, slots: make(chan struct{}, 1),
+	})
+	defer srv.Close()
+
+	tests := []struct {
+		method, query, body string
+		status              int
+		exit                string
+		contains            string
+	}{
+		{method: "GET", query: "replay=a.dem&cs=true", status: 200, exit: "0", contains: "events -names=n -cs=true -dem " + filepath.Join(replays, "a.dem") + " -out -"},
+		{method: "GET", query: "replay=missing.dem", status: 404},
+		{method: "GET", query: "replay=../a.dem", status: 400},
+		{method: "GET", query: "replay=a.dem&out=x", status: 400},
+		{method: "GET", query: "replay=a.dem&exit=3", status: 422, exit: "3", contains: "decode failed"},
+		{method: "GET", query: "replay=a.dem&exit=1", status: 400, exit: "1"},
+		{method: "POST", query: "raw=false", body: "demo", status: 200, exit: "0", contains: "-raw=false -dem "},
+		{method: "POST", body: strings.Repeat("x", 17), status: 413},
+		{method: "PUT", status: 405},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, srv.URL+"/?"+tt.query, strings.NewReader(tt.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d (%s)", tt.method, tt.query, resp.StatusCode, tt.status, body)
+			continue
+		}
+		exit := resp.Trailer.Get(serveExitHeader)
+		if exit == "" {
+			exit = resp.Header.Get(serveExitHeader)
+		}
+		if exit != tt.exit {
+			t.Errorf("%s %s: exit code %q, want %q", tt.method, tt.query, exit, tt.exit)
+		}
+		if !strings.Contains(string(body), tt.contains) {
+			t.Errorf("%s %s: body %q lacks %q", tt.method, tt.query, body, tt.contains)
+		}
+	}
+}
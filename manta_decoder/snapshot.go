@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+// parseClassGlobs splits a comma-separated list of path.Match patterns and
+// rejects malformed ones up front.
+func parseClassGlobs(list string) ([]string, error) {
+	var globs []string
+	for _, g := range strings.Split(list, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" {
+			continue
+		}
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad class glob %q: %w", g, err)
+		}
+		globs = append(globs, g)
+	}
+	if len(globs) == 0 {
+		return nil, fmt.Errorf("no class globs given")
+	}
+	return globs, nil
+}
+
+func matchesClassGlob(globs []string, class string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, class); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotFields copies an entity's decoded fields, replacing NaN and
+// infinite floats, which JSON cannot carry, with null.
+func snapshotFields(e *manta.Entity) map[string]any {
+	fields := map[string]any{}
+	for k, v := range e.Map() {
+		switch f := v.(type) {
+		case float32:
+			if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+				v = nil
+			}
+		case float64:
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				v = nil
+			}
+		}
+		fields[k] = v
+	}
+	return fields
+}
+
+// registerSnapshots emits the full state of every entity whose class matches
+// one of globs every interval ticks.
+func registerSnapshots(h *extractorHooks, out *outputState, interval uint32, globs []string, wrote *int) {
+	parser := h.parser()
+	sampler := newTickSampler(interval)
+	units := newUnitClassifier(parser)
+	h.onEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		found := parser.FilterEntity(func(e *manta.Entity) bool {
+			return matchesClassGlob(globs, e.GetClassName())
+		})
+		sort.Slice(found, func(i, j int) bool { return found[i].GetIndex() < found[j].GetIndex() })
+		for _, e := range found {
+			rec := map[string]any{
+				"kind":      "snapshot",
+				"tick":      parser.Tick,
+				"class":     e.GetClassName(),
+				"entity_id": e.GetIndex(),
+				"serial":    e.GetSerial(),
+				"fields":    snapshotFields(e),
+			}
+			if name := entityUnitName(parser, e); name != "" {
+				rec["name"] = name
+			}
+			units.annotate(rec, e)
+			(*wrote)++
+			if err := out.add(parser.Tick, rec, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// dotaReplaysSubdir is where Dota 2 saves downloaded replays inside a Steam
+// library.
+var dotaReplaysSubdir = filepath.Join("steamapps", "common", "dota 2 beta", "game", "dota", "replays")
+
+var libraryPathRegexp = regexp.MustCompile(`"path"\s+"((?:[^"\\]|\\.)*)"`)
+
+// steamRoots lists the default Steam install locations for this OS.
+func steamRoots() []string {
+	home, _ := os.UserHomeDir()
+	switch runtime.GOOS {
+	case "windows":
+		var roots []string
+		for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+			if dir := os.Getenv(env); dir != "" {
+				roots = append(roots, filepath.Join(dir, "Steam"))
+			}
+		}
+		return append(roots, `C:\Program Files (x86)\Steam`)
+	case "darwin":
+		return []string{filepath.Join(home, "Library", "Application Support", "Steam")}
+	default:
+		return []string{
+			filepath.Join(home, ".steam", "steam"),
+			filepath.Join(home, ".local", "share", "Steam"),
+			filepath.Join(home, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"),
+		}
+	}
+}
+
+// steamLibraries returns root plus every library folder listed in its
+// steamapps/libraryfolders.vdf.
+func steamLibraries(root string) []string {
+	libs := []string{root}
+	data, err := os.ReadFile(filepath.Join(root, "steamapps", "libraryfolders.vdf"))
+	if err != nil {
+		return libs
+	}
+	for _, m := range libraryPathRegexp.FindAllStringSubmatch(string(data), -1) {
+		libs = append(libs, strings.ReplaceAll(m[1], `\\`, `\`))
+	}
+	return libs
+}
+
+// dotaReplayDirs returns the existing Dota 2 replay directories across all
+// Steam libraries, searching steamDir instead of the defaults when set.
+func dotaReplayDirs(steamDir string) []string {
+	roots := steamRoots()
+	if steamDir != "" {
+		roots = []string{steamDir}
+	}
+	seen := map[string]bool{}
+	var dirs []string
+	for _, root := range roots {
+		for _, lib := range steamLibraries(root) {
+			dir := filepath.Join(lib, dotaReplaysSubdir)
+			if real, err := filepath.EvalSymlinks(dir); err == nil {
+				dir = real
+			} else {
+				continue
+			}
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// latestReplay returns the most recently modified .dem in the local Dota 2
+// replay directories.
+func latestReplay(steamDir string) (string, error) {
+	dirs := dotaReplayDirs(steamDir)
+	if len(dirs) == 0 {
+		return "", fmt.Errorf("no Dota 2 replays directory found (searched %s); pass -steam-dir", strings.Join(steamRoots(), ", "))
+	}
+	var newest string
+	var newestMod int64
+	for _, dir := range dirs {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*.dem"))
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			if mod := info.ModTime().UnixNano(); newest == "" || mod > newestMod {
+				newest, newestMod = m, mod
+			}
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("no .dem files in %s", strings.Join(dirs, ", "))
+	}
+	return newest, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+
+	"manta_decoder/mapcoord"
+)
+
+const (
+	teleportModifier = "modifier_teleporting"
+	// teleportSettleTicks delays the finish/cancel decision until the hero's
+	// position has been networked after the channel ends.
+	teleportSettleTicks = 15
+	// teleportMinDistance separates a completed teleport from a cancelled
+	// one by how far the hero ended up from where it started.
+	teleportMinDistance = 1500
+)
+
+var teleportItems = map[string]bool{
+	"item_tpscroll":       true,
+	"item_travel_boots":   true,
+	"item_travel_boots_2": true,
+}
+
+type teleport struct {
+	hero       string
+	item       string
+	startTick  uint32
+	start      float32
+	end        float32
+	origin     mapcoord.World
+	hasOrigin  bool
+	settleTick uint32
+}
+
+type teleportTracker struct {
+	parser *manta.Parser
+	rules  *gameRulesRef
+	out    *outputState
+	wrote  *int
+	active map[string]*teleport
+	ending []*teleport
+}
+
+func (t *teleportTracker) write(tp *teleport, event string, extra map[string]any) error {
+	rec := map[string]any{
+		"kind":       "teleport",
+		"event":      event,
+		"tick":       t.parser.Tick,
+		"start_tick": tp.startTick,
+		"time":       tp.start,
+		"hero":       tp.hero,
+		"item":       tp.item,
+	}
+	if tp.hasOrigin {
+		rec["origin"] = tp.origin
+		rec["origin_minimap"] = tp.origin.Minimap()
+		rec["origin_zone"] = mapcoord.ZoneOf(tp.origin)
+	}
+	for k, v := range extra {
+		rec[k] = v
+	}
+	(*t.wrote)++
+	return t.out.add(t.parser.Tick, rec, false)
+}
+
+func (t *teleportTracker) begin(hero, item string, clock float32) error {
+	if tp, ok := t.active[hero]; ok {
+		// The modifier and the item use both announce the same channel.
+		if tp.item == "" {
+			tp.item = item
+		}
+		return nil
+	}
+	tp := &teleport{hero: hero, item: item, startTick: t.parser.Tick, start: clock}
+	if h := heroEntityByName(t.parser, hero); h != nil {
+		tp.origin, tp.hasOrigin = entityWorldPosition(h)
+	}
+	t.active[hero] = tp
+	return t.write(tp, "start", nil)
+}
+
+func (t *teleportTracker) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	name := func(idx uint32) string { return lookupCombatLogName(t.parser, idx) }
+	clock := t.rules.clock(m.GetTimestamp())
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ITEM:
+		if item := name(m.GetInflictorName()); teleportItems[item] && m.GetIsAttackerHero() && !m.GetIsAttackerIllusion() {
+			return t.begin(name(m.GetAttackerName()), item, clock)
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD:
+		if name(m.GetInflictorName()) == teleportModifier && m.GetIsTargetHero() && !m.GetIsTargetIllusion() {
+			return t.begin(name(m.GetTargetName()), "", clock)
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REMOVE:
+		if name(m.GetInflictorName()) != teleportModifier {
+			return nil
+		}
+		hero := name(m.GetTargetName())
+		tp, ok := t.active[hero]
+		if !ok {
+			return nil
+		}
+		delete(t.active, hero)
+		tp.end = clock
+		tp.settleTick = t.parser.Tick + teleportSettleTicks
+		t.ending = append(t.ending, tp)
+	}
+	return nil
+}
+
+func (t *teleportTracker) settle(force bool) error {
+	kept := t.ending[:0]
+	for _, tp := range t.ending {
+		if !force && t.parser.Tick < tp.settleTick {
+			kept = append(kept, tp)
+			continue
+		}
+		extra := map[string]any{"channel": tp.end - tp.start}
+		event := "cancel"
+		if h := heroEntityByName(t.parser, tp.hero); h != nil {
+			if pos, ok := entityWorldPosition(h); ok {
+				if !tp.hasOrigin || mapcoord.Distance2D(pos, tp.origin) >= teleportMinDistance {
+					event = "finish"
+					extra["destination"] = pos
+					extra["destination_minimap"] = pos.Minimap()
+					extra["destination_zone"] = mapcoord.ZoneOf(pos)
+				}
+			}
+		}
+		if err := t.write(tp, event, extra); err != nil {
+			return err
+		}
+	}
+	t.ending = kept
+	return nil
+}
+
+func registerTeleports(parser *manta.Parser, out *outputState, wrote *int) func() error {
+	t := &teleportTracker{
+		parser: parser,
+		rules:  newGameRulesRef(parser),
+		out:    out,
+		wrote:  wrote,
+		active: map[string]*teleport{},
+	}
+	subscribeCombatLog(parser, t.onCombatLog)
+	parser.OnEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if len(t.ending) == 0 {
+			return nil
+		}
+		return t.settle(false)
+	})
+	return func() error {
+		return t.settle(true)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// textEncoder writes "text" records as bare lines and everything else as
+// JSON, so -format text can still be combined with analyzer flags.
+type textEncoder struct {
+	w    io.Writer
+	json *json.Encoder
+}
+
+func newTextEncoder(w io.Writer) *textEncoder {
+	return &textEncoder{w: w, json: json.NewEncoder(w)}
+}
+
+func (t *textEncoder) Encode(v any) error {
+	if rec, ok := v.(map[string]any); ok && rec["kind"] == "text" {
+		_, err := fmt.Fprintln(t.w, rec["text"])
+		return err
+	}
+	return t.json.Encode(v)
+}
+
+// combatLogText renders an entry the way the in-game combat log does. It
+// returns "" for entry types with no useful text form.
+func combatLogText(parser *manta.Parser, clock float32, m *dota.CMsgDOTACombatLogEntry) string {
+	name := func(idx uint32) string { return lookupCombatLogName(parser, idx) }
+	attackerName := name(m.GetAttackerName())
+	targetName := name(m.GetTargetName())
+	inflictorName := name(m.GetInflictorName())
+	attacker := prettyUnitName(attackerName)
+	target := prettyUnitName(targetName)
+	with := ""
+	if inflictorName != "" {
+		with = " with " + prettyAbilityName(inflictorName, attackerName)
+	}
+	value := m.GetValue()
+
+	var line string
+	switch m.GetType() {
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DAMAGE:
+		line = fmt.Sprintf("%s hits %s%s for %d damage (%d->%d)", attacker, target, with, value, m.GetHealth()+int32(value), m.GetHealth())
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_HEAL:
+		line = fmt.Sprintf("%s heals %s%s for %d health", attacker, target, with, value)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_ADD:
+		line = fmt.Sprintf("%s receives %s from %s", target, prettyAbilityName(inflictorName, attackerName), attacker)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_MODIFIER_REMOVE:
+		line = fmt.Sprintf("%s loses %s", target, prettyAbilityName(inflictorName, attackerName))
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH:
+		line = fmt.Sprintf("%s is killed by %s", target, attacker)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ABILITY:
+		line = fmt.Sprintf("%s casts %s", attacker, prettyAbilityName(inflictorName, attackerName))
+		if level := m.GetAbilityLevel(); level > 0 {
+			line += fmt.Sprintf(" (lvl %d)", level)
+		}
+		if targetName != "" && targetName != attackerName {
+			line += " on " + target
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ITEM:
+		line = fmt.Sprintf("%s uses %s", attacker, prettyAbilityName(inflictorName, attackerName))
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_GOLD:
+		if amount := int32(value); amount < 0 {
+			line = fmt.Sprintf("%s loses %d gold", target, -amount)
+		} else {
+			line = fmt.Sprintf("%s receives %d gold", target, amount)
+		}
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_XP:
+		line = fmt.Sprintf("%s gains %d XP", target, value)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_PURCHASE:
+		line = fmt.Sprintf("%s buys %s", target, prettyAbilityName(name(value), targetName))
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_BUYBACK:
+		line = fmt.Sprintf("Player %d buys back", value)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_PICKUP_RUNE:
+		line = fmt.Sprintf("%s picks up a rune", attacker)
+	case dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_FIRST_BLOOD:
+		line = fmt.Sprintf("%s draws first blood on %s", attacker, target)
+	default:
+		return ""
+	}
+	return formatGameClock(clock) + " " + line
+}
+
+func registerText(parser *manta.Parser, out *outputState, wrote *int) {
+	rules := newGameRulesRef(parser)
+	subscribeCombatLog(parser, func(m *dota.CMsgDOTACombatLogEntry) error {
+		line := combatLogText(parser, rules.clock(m.GetTimestamp()), m)
+		if line == "" {
+			return nil
+		}
+		(*wrote)++
+		return out.add(parser.Tick, map[string]any{"kind": "text", "text": line}, isLunaEclipseCast(parser, m))
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dotabuff/manta"
+)
+
+// Unit types attached to entity, position and damage records. Only "hero"
+// units are the players' own heroes; treating the others as heroes skews
+// positions, damage and kill participation.
+const (
+	unitHero     = "hero"
+	unitIllusion = "illusion"
+	// unitClone is a second real hero controlled by the same player: a
+	// Meepo clone or an Arc Warden Tempest Double.
+	unitClone  = "clone"
+	unitSummon = "summon"
+)
+
+// unitClassifier tells heroes from illusions, clones and summons. The
+// players' selected heroes are looked up once per tick.
+type unitClassifier struct {
+	parser  *manta.Parser
+	tick    uint32
+	primary map[int32]bool
+}
+
+func newUnitClassifier(parser *manta.Parser) *unitClassifier {
+	return &unitClassifier{parser: parser}
+}
+
+func (c *unitClassifier) isPrimary(e *manta.Entity) bool {
+	if c.primary == nil || c.tick != c.parser.Tick {
+		c.tick = c.parser.Tick
+		c.primary = map[int32]bool{}
+		for _, p := range listPlayers(c.parser) {
+			if p.Hero != nil {
+				c.primary[p.Hero.GetIndex()] = true
+			}
+		}
+	}
+	// Before the player resource exists, count every hero as primary.
+	return len(c.primary) == 0 || c.primary[e.GetIndex()]
+}
+
+func (c *unitClassifier) handleName(e *manta.Entity, field string) string {
+	h, ok := e.GetUint32(field)
+	if !ok || h == invalidHandle {
+		return ""
+	}
+	if owner := c.parser.FindEntityByHandle(uint64(h)); owner != nil {
+		return entityUnitName(c.parser, owner)
+	}
+	return ""
+}
+
+// ownerHeroName returns the hero controlled by the same player as e.
+func (c *unitClassifier) ownerHeroName(e *manta.Entity) string {
+	id, ok := e.GetInt32("m_iPlayerID")
+	if !ok {
+		return ""
+	}
+	for _, p := range listPlayers(c.parser) {
+		if p.ID == id {
+			return p.HeroName
+		}
+	}
+	return ""
+}
+
+// isSummonCandidate reports whether e is a unit that may be a hero's summon;
+// owned entities such as wearables, abilities, couriers, wards and
+// buildings are not.
+func isSummonCandidate(e *manta.Entity) bool {
+	class := e.GetClassName()
+	if !strings.HasPrefix(class, "CDOTA_BaseNPC") && !strings.HasPrefix(class, "CDOTA_Unit_") && !strings.HasPrefix(class, "CDOTA_NPC_") {
+		return false
+	}
+	return !strings.HasPrefix(class, "CDOTA_Unit_Courier") && !strings.HasPrefix(class, "CDOTA_NPC_Observer_Ward") &&
+		!isBuildingEntity(e)
+}
+
+// classify returns the unit type of e and the hero it belongs to, which is
+// e itself for a hero. ok is false for units that are none of these, such as
+// creeps, couriers, wards and buildings.
+func (c *unitClassifier) classify(e *manta.Entity) (unit, owner string, ok bool) {
+	if isHeroEntity(e) {
+		switch {
+		case isIllusionEntity(e):
+			return unitIllusion, c.handleName(e, "m_hReplicatingOtherHeroModel"), true
+		case !c.isPrimary(e):
+			return unitClone, c.ownerHeroName(e), true
+		}
+		return unitHero, entityUnitName(c.parser, e), true
+	}
+	if !isSummonCandidate(e) {
+		return "", "", false
+	}
+	h, hok := e.GetUint32("m_hOwnerEntity")
+	if !hok || h == invalidHandle {
+		return "", "", false
+	}
+	o := c.parser.FindEntityByHandle(uint64(h))
+	if o == nil || !isHeroEntity(o) {
+		return "", "", false
+	}
+	if isIllusionEntity(o) {
+		return unitSummon, c.handleName(o, "m_hReplicatingOtherHeroModel"), true
+	}
+	return unitSummon, entityUnitName(c.parser, o), true
+}
+
+// annotate adds unit_type and, for anything but a hero, owner to rec. It
+// reports whether e was classified.
+func (c *unitClassifier) annotate(rec map[string]any, e *manta.Entity) bool {
+	unit, owner, ok := c.classify(e)
+	if !ok {
+		return false
+	}
+	rec["unit_type"] = unit
+	if unit != unitHero && owner != "" {
+		rec["owner"] = owner
+	}
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// validator re-derives per-player stats from the combat log, by the same
+// rules as the participation, cs and gold extractors, and compares them
+// with the end-of-game scoreboard and epilogue stored in the replay.
+type validator struct {
+	parser    *manta.Parser
+	rules     *gameRulesRef
+	out       *outputState
+	wrote     *int
+	tolerance float64
+	kills     map[string]int
+	lastHits  map[string]int
+	gold      *goldStats
+	epilogue  *dota.CDemoFileInfo
+}
+
+func (v *validator) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	v.gold.onCombatLog(m)
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH {
+		return nil
+	}
+	attacker := lookupCombatLogName(v.parser, m.GetAttackerName())
+	target := lookupCombatLogName(v.parser, m.GetTargetName())
+	enemies := m.GetAttackerTeam() != m.GetTargetTeam()
+	attackerHero := m.GetIsAttackerHero() && !m.GetIsAttackerIllusion()
+	switch {
+	case m.GetIsTargetHero() && !m.GetIsTargetIllusion():
+		if attackerHero && enemies {
+			v.kills[attacker]++
+		}
+	case enemies && creepType(target) != "":
+		if hero := creditedHero(v.parser, m); hero != "" {
+			v.lastHits[hero]++
+		}
+	}
+	return nil
+}
+
+func (v *validator) onFileInfo(m *dota.CDemoFileInfo) error {
+	v.epilogue = m
+	return nil
+}
+
+// check compares a derived value with the replay's own, allowing the
+// relative tolerance and an absolute slack of one.
+func (v *validator) check(derived, reference float64) map[string]any {
+	diff := math.Abs(derived - reference)
+	return map[string]any{
+		"derived":   derived,
+		"reference": reference,
+		"ok":        diff <= math.Max(1, v.tolerance*math.Abs(reference)),
+	}
+}
+
+// duration returns the game length in minutes from the horn to the end of
+// the game, or to the last tick when the replay has no end time.
+func (v *validator) duration() float64 {
+	rules := v.rules.get()
+	if rules == nil {
+		return 0
+	}
+	end, _ := rules.GetFloat32("m_pGameRules.m_flGameEndTime")
+	if end <= 0 {
+		return float64(v.rules.now()) / 60
+	}
+	return float64(gameClock(rules, end)) / 60
+}
+
+func (v *validator) finish() error {
+	pr := findPlayerResource(v.parser)
+	if pr == nil {
+		warn("validation skipped: no player resource")
+		return nil
+	}
+	minutes := v.duration()
+	var epiloguePlayers []*dota.CGameInfo_CDotaGameInfo_CPlayerInfo
+	if v.epilogue != nil {
+		epiloguePlayers = v.epilogue.GetGameInfo().GetDota().GetPlayerInfo()
+	}
+
+	discrepancies := 0
+	players := []map[string]any{}
+	for _, p := range listPlayers(v.parser) {
+		if p.HeroName == "" {
+			continue
+		}
+		checks := map[string]any{}
+		if kills, ok := pr.GetInt32(playerTeamField(p.ID, "m_iKills")); ok {
+			checks["kills"] = v.check(float64(v.kills[p.HeroName]), float64(kills))
+		}
+		if d := teamDataEntity(v.parser, p.Team); d != nil {
+			if lh, ok := d.GetInt32(teamDataField(p.TeamSlot, "m_iLastHitCount")); ok {
+				checks["last_hits"] = v.check(float64(v.lastHits[p.HeroName]), float64(lh))
+			}
+			if earned, ok := d.GetInt32(teamDataField(p.TeamSlot, "m_iTotalEarnedGold")); ok && minutes > 0 {
+				derived := float64(v.gold.totals(p.HeroName).Earned) / minutes
+				checks["gpm"] = v.check(math.Round(derived), math.Round(float64(earned)/minutes))
+			}
+		}
+		if steamID, ok := pr.GetUint64(playerDataField(p.ID, "m_iPlayerSteamID")); ok && steamID != 0 {
+			for _, info := range epiloguePlayers {
+				if info.GetSteamid() == steamID {
+					checks["epilogue_hero"] = map[string]any{
+						"derived":   p.HeroName,
+						"reference": info.GetHeroName(),
+						"ok":        info.GetHeroName() == p.HeroName,
+					}
+				}
+			}
+		}
+		for _, c := range checks {
+			if !c.(map[string]any)["ok"].(bool) {
+				discrepancies++
+			}
+		}
+		players = append(players, map[string]any{
+			"player_id": p.ID,
+			"hero":      p.HeroName,
+			"checks":    checks,
+		})
+	}
+
+	rec := map[string]any{
+		"kind":          "validation",
+		"tick":          v.parser.Tick,
+		"tolerance":     v.tolerance,
+		"epilogue":      v.epilogue != nil,
+		"players":       players,
+		"discrepancies": discrepancies,
+		"ok":            discrepancies == 0,
+	}
+	(*v.wrote)++
+	if err := v.out.add(v.parser.Tick, rec, false); err != nil {
+		return err
+	}
+	if discrepancies > 0 {
+		warn("derived stats disagree with the replay's end-of-game stats", "discrepancies", discrepancies)
+	}
+	return nil
+}
+
+func registerValidation(parser *manta.Parser, out *outputState, tolerance float64, wrote *int) func() error {
+	v := &validator{
+		parser:    parser,
+		rules:     newGameRulesRef(parser),
+		out:       out,
+		wrote:     wrote,
+		tolerance: tolerance,
+		kills:     map[string]int{},
+		lastHits:  map[string]int{},
+		gold:      newGoldStats(parser),
+	}
+	subscribeCombatLog(parser, v.onCombatLog)
+	parser.Callbacks.OnCDemoFileInfo(v.onFileInfo)
+	return v.finish
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// mantaMaxBuild is the newest game build the bundled manta is known to
+// decode: the build of the newest replay in manta's own test suite at the
+// revision pinned in go.mod (b92892d50d0f, February 2026). Raise both
+// together.
+const mantaMaxBuild = 6601
+
+// replayVersion identifies the game build that recorded a replay.
+type replayVersion struct {
+	Build        uint32 `json:"build"`
+	Protocol     int32  `json:"network_protocol"`
+	PatchVersion int32  `json:"patch_version"`
+	DemoVersion  string `json:"demo_version,omitempty"`
+	Server       string `json:"server,omitempty"`
+	known        bool
+}
+
+func (v *replayVersion) String() string {
+	if !v.known {
+		return "unknown build"
+	}
+	return fmt.Sprintf("build %d, network protocol %d, patch %d", v.Build, v.Protocol, v.PatchVersion)
+}
+
+// registerReplayVersion detects the replay's version from the file header
+// and server info, and warns when the build is newer than mantaMaxBuild;
+// server info arrives before any entity is decoded. With emit set it writes
+// the version as a replay_info record. With tag set, every later record
+// carries the build so best-effort output stays attributable.
+func registerReplayVersion(parser *manta.Parser, out *outputState, emit, tag bool, wrote *int) *replayVersion {
+	v := &replayVersion{}
+	parser.Callbacks.OnCDemoFileHeader(func(m *dota.CDemoFileHeader) error {
+		v.PatchVersion = m.GetPatchVersion()
+		v.DemoVersion = m.GetDemoVersionName()
+		v.Server = m.GetServerName()
+		if b := m.GetBuildNum(); b > 0 {
+			v.Build = uint32(b)
+		}
+		return nil
+	})
+	// manta's own ServerInfo handler runs first and sets GameBuild from the
+	// server's game directory.
+	parser.Callbacks.OnCSVCMsg_ServerInfo(func(m *dota.CSVCMsg_ServerInfo) error {
+		v.Protocol = m.GetProtocol()
+		if parser.GameBuild > 0 {
+			v.Build = parser.GameBuild
+		}
+		v.known = true
+		logger.Debug("detected replay version", "build", v.Build, "network_protocol", v.Protocol, "patch_version", v.PatchVersion)
+		if v.Build > mantaMaxBuild {
+			warn("replay is newer than the bundled manta", "build", v.Build, "network_protocol", v.Protocol,
+				"max_build", mantaMaxBuild)
+		}
+		if tag {
+			out.build = v.Build
+		}
+		if !emit {
+			return nil
+		}
+		(*wrote)++
+		return out.add(parser.Tick, map[string]any{
+			"kind":             "replay_info",
+			"tick":             parser.Tick,
+			"build":            v.Build,
+			"network_protocol": v.Protocol,
+			"patch_version":    v.PatchVersion,
+			"demo_version":     v.DemoVersion,
+			"server":           v.Server,
+		}, false)
+	})
+	return v
+}
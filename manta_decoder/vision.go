@@ -0,0 +1,180 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/dotabuff/manta"
+
+	"manta_decoder/mapcoord"
+)
+
+// visionGridSize is the number of raster cells along each map axis. At 64
+// cells one cell is roughly 260 world units, finer than any vision radius.
+const visionGridSize = 64
+
+// Fallback vision radii (day, night) for units whose entity does not carry
+// m_iDayTimeVisionRange / m_iNightTimeVisionRange.
+var visionFallbacks = []struct {
+	classPrefix string
+	day, night  float64
+}{
+	{"CDOTA_Unit_Hero_", 1800, 800},
+	{"CDOTA_NPC_Observer_Ward_TrueSight", 150, 150},
+	{"CDOTA_NPC_Observer_Ward", 1600, 1600},
+	{"CDOTA_BaseNPC_Tower", 1900, 800},
+	{"CDOTA_BaseNPC_Barracks", 900, 600},
+	{"CDOTA_BaseNPC_Fort", 900, 600},
+	{"CDOTA_BaseNPC_Building", 900, 600},
+}
+
+type visionGrid [visionGridSize][visionGridSize]bool
+
+func (g *visionGrid) reveal(center mapcoord.World, radius float64) {
+	cell := (mapcoord.WorldMax - mapcoord.WorldMin) / visionGridSize
+	minX := int(math.Floor((center.X - radius - mapcoord.WorldMin) / cell))
+	maxX := int(math.Floor((center.X + radius - mapcoord.WorldMin) / cell))
+	minY := int(math.Floor((center.Y - radius - mapcoord.WorldMin) / cell))
+	maxY := int(math.Floor((center.Y + radius - mapcoord.WorldMin) / cell))
+	for y := max(minY, 0); y <= min(maxY, visionGridSize-1); y++ {
+		for x := max(minX, 0); x <= min(maxX, visionGridSize-1); x++ {
+			cx := mapcoord.WorldMin + (float64(x)+0.5)*cell
+			cy := mapcoord.WorldMin + (float64(y)+0.5)*cell
+			if math.Hypot(cx-center.X, cy-center.Y) <= radius {
+				g[y][x] = true
+			}
+		}
+	}
+}
+
+// coverage returns the revealed fraction of the whole map and of each half,
+// split along the river diagonal (x + y = 0, Radiant to the south-west).
+func (g *visionGrid) coverage() (total, radiantHalf, direHalf float64) {
+	var all, rad, dire, radCells, direCells int
+	for y := 0; y < visionGridSize; y++ {
+		for x := 0; x < visionGridSize; x++ {
+			radiantSide := x+y < visionGridSize-1
+			if radiantSide {
+				radCells++
+			} else {
+				direCells++
+			}
+			if !g[y][x] {
+				continue
+			}
+			all++
+			if radiantSide {
+				rad++
+			} else {
+				dire++
+			}
+		}
+	}
+	return float64(all) / float64(visionGridSize*visionGridSize),
+		float64(rad) / float64(radCells),
+		float64(dire) / float64(direCells)
+}
+
+// raster renders the grid as minimap-oriented rows (north first) of '0'/'1'.
+func (g *visionGrid) raster() []string {
+	rows := make([]string, 0, visionGridSize)
+	for y := visionGridSize - 1; y >= 0; y-- {
+		var b strings.Builder
+		for x := 0; x < visionGridSize; x++ {
+			if g[y][x] {
+				b.WriteByte('1')
+			} else {
+				b.WriteByte('0')
+			}
+		}
+		rows = append(rows, b.String())
+	}
+	return rows
+}
+
+func visionRadius(e *manta.Entity, night bool) (float64, bool) {
+	field := "m_iDayTimeVisionRange"
+	if night {
+		field = "m_iNightTimeVisionRange"
+	}
+	if r, ok := e.GetInt32(field); ok && r > 0 {
+		return float64(r), true
+	}
+	name := e.GetClassName()
+	for _, f := range visionFallbacks {
+		if strings.HasPrefix(name, f.classPrefix) {
+			if night {
+				return f.night, true
+			}
+			return f.day, true
+		}
+	}
+	return 0, false
+}
+
+func isVisionSource(e *manta.Entity) bool {
+	name := e.GetClassName()
+	return isHeroEntity(e) ||
+		strings.HasPrefix(name, "CDOTA_NPC_Observer_Ward") ||
+		strings.HasPrefix(name, "CDOTA_BaseNPC_Tower") ||
+		strings.HasPrefix(name, "CDOTA_BaseNPC_Barracks") ||
+		strings.HasPrefix(name, "CDOTA_BaseNPC_Fort") ||
+		strings.HasPrefix(name, "CDOTA_BaseNPC_Building")
+}
+
+func registerVision(h *extractorHooks, out *outputState, interval uint32, withRaster bool, wrote *int) {
+	parser := h.parser()
+	sampler := newTickSampler(interval)
+	h.onEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		night := isNight(findGameRules(parser))
+		grids := map[int32]*visionGrid{teamRadiant: {}, teamDire: {}}
+		sources := map[int32]int{}
+		for _, e := range parser.FilterEntity(isVisionSource) {
+			team, _ := e.GetInt32("m_iTeamNum")
+			grid, ok := grids[team]
+			if !ok || !isAlive(e) {
+				continue
+			}
+			pos, ok := entityWorldPosition(e)
+			if !ok {
+				continue
+			}
+			radius, ok := visionRadius(e, night)
+			if !ok {
+				continue
+			}
+			grid.reveal(pos, radius)
+			sources[team]++
+		}
+		for _, team := range []int32{teamRadiant, teamDire} {
+			grid := grids[team]
+			total, radiantHalf, direHalf := grid.coverage()
+			own, enemy := radiantHalf, direHalf
+			if team == teamDire {
+				own, enemy = direHalf, radiantHalf
+			}
+			record := map[string]any{
+				"kind":       "vision",
+				"tick":       parser.Tick,
+				"team":       team,
+				"night":      night,
+				"sources":    sources[team],
+				"coverage":   total,
+				"own_half":   own,
+				"enemy_half": enemy,
+				"grid_size":  visionGridSize,
+			}
+			if withRaster {
+				record["raster"] = grid.raster()
+			}
+			(*wrote)++
+			if err := out.add(parser.Tick, record, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// youtubeMinChapterGap is the shortest chapter YouTube accepts, in seconds.
+const youtubeMinChapterGap = 10
+
+// vodTeamfightDeaths is the number of deaths that promotes a fight to a
+// chapter; smaller fights are still written as CSV markers.
+const vodTeamfightDeaths = 3
+
+type vodMarker struct {
+	clock   float32
+	kind    string
+	label   string
+	chapter bool
+}
+
+// parseVODOffset accepts seconds or [hh:]mm:ss.
+func parseVODOffset(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid offset %q", s)
+	}
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid offset %q", s)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
+
+func formatVODTime(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	t := int(seconds)
+	if t >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", t/3600, t/60%60, t%60)
+	}
+	return fmt.Sprintf("%d:%02d", t/60, t%60)
+}
+
+func formatGameClock(clock float32) string {
+	sign := ""
+	if clock < 0 {
+		sign = "-"
+		clock = -clock
+	}
+	t := int(clock)
+	return fmt.Sprintf("%s%d:%02d", sign, t/60, t%60)
+}
+
+func buildingLabel(name string) (string, bool) {
+	switch {
+	case strings.Contains(name, "_tower"):
+		return "tower", true
+	case strings.Contains(name, "_rax_"):
+		return "barracks", true
+	case strings.Contains(name, "_fort"):
+		return "ancient", true
+	}
+	return "", false
+}
+
+func teamName(team uint32) string {
+	switch team {
+	case teamRadiant:
+		return "Radiant"
+	case teamDire:
+		return "Dire"
+	}
+	return "Neutral"
+}
+
+type vodCollector struct {
+	parser  *manta.Parser
+	rules   *gameRulesRef
+	markers []vodMarker
+}
+
+func (c *vodCollector) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if m.GetType() != dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_DEATH {
+		return nil
+	}
+	clock := c.rules.clock(m.GetTimestamp())
+	attacker := lookupCombatLogName(c.parser, m.GetAttackerName())
+	target := lookupCombatLogName(c.parser, m.GetTargetName())
+
+	switch {
+	case m.GetIsTargetHero() && !m.GetIsTargetIllusion():
+		c.markers = append(c.markers, vodMarker{
+			clock: clock,
+			kind:  "kill",
+			label: fmt.Sprintf("%s kills %s", attacker, target),
+		})
+	case target == "npc_dota_roshan":
+		c.markers = append(c.markers, vodMarker{
+			clock:   clock,
+			kind:    "roshan",
+			label:   fmt.Sprintf("%s takes Roshan", teamName(m.GetAttackerTeam())),
+			chapter: true,
+		})
+	default:
+		if building, ok := buildingLabel(target); ok {
+			c.markers = append(c.markers, vodMarker{
+				clock:   clock,
+				kind:    building,
+				label:   fmt.Sprintf("%s %s destroyed (%s)", teamName(m.GetTargetTeam()), building, target),
+				chapter: true,
+			})
+		}
+	}
+	return nil
+}
+
+func (c *vodCollector) onFightClose(f *fight, record map[string]any) error {
+	if record == nil {
+		return nil
+	}
+	c.markers = append(c.markers, vodMarker{
+		clock:   f.Start,
+		kind:    "fight",
+		label:   fmt.Sprintf("Fight (%d deaths)", len(f.Deaths)),
+		chapter: len(f.Deaths) >= vodTeamfightDeaths,
+	})
+	return nil
+}
+
+func collectVODMarkers(demPath string) ([]vodMarker, error) {
+	in, err := os.Open(demPath)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	parser, err := manta.NewStreamParser(in)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCombatLog(parser)
+	c := &vodCollector{parser: parser, rules: newGameRulesRef(parser)}
+	fights := newFightDetector(parser, nil, false, new(int))
+	fights.subscribe(c.onFightClose)
+	subscribeCombatLog(parser, c.onCombatLog)
+	if err := parser.Start(); err != nil {
+		return nil, err
+	}
+	if err := fights.finalize(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(c.markers, func(i, j int) bool { return c.markers[i].clock < c.markers[j].clock })
+	return c.markers, nil
+}
+
+// writeYouTubeChapters writes a description-ready chapter list. YouTube
+// requires a 0:00 first chapter and at least 10 seconds per chapter, so
+// markers closer than that to the previous chapter are dropped.
+func writeYouTubeChapters(w io.Writer, markers []vodMarker, offset float64) error {
+	if _, err := fmt.Fprintln(w, "0:00 Pre-game"); err != nil {
+		return err
+	}
+	last := 0.0
+	if offset >= youtubeMinChapterGap {
+		if _, err := fmt.Fprintf(w, "%s Horn\n", formatVODTime(offset)); err != nil {
+			return err
+		}
+		last = offset
+	}
+	for _, m := range markers {
+		if !m.chapter {
+			continue
+		}
+		at := offset + float64(m.clock)
+		if at-last < youtubeMinChapterGap {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", formatVODTime(at), m.label); err != nil {
+			return err
+		}
+		last = at
+	}
+	return nil
+}
+
+func writeVODMarkersCSV(w io.Writer, markers []vodMarker, offset float64) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"vod_time", "vod_seconds", "game_time", "type", "description"})
+	for _, m := range markers {
+		at := offset + float64(m.clock)
+		cw.Write([]string{
+			formatVODTime(at),
+			strconv.FormatFloat(at, 'f', 1, 64),
+			formatGameClock(m.clock),
+			m.kind,
+			m.label,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// runChapters implements `chapters -offset <vod time of the horn> replay.dem`.
+func runChapters(args []string) {
+	fs := flag.NewFlagSet("chapters", flag.ExitOnError)
+	global := addGlobalFlags(fs)
+	offsetFlag := fs.String("offset", "0", "VOD timestamp at which the game clock reads 0:00, as seconds or [hh:]mm:ss")
+	format := fs.String("format", "youtube", "output format: youtube (chapter list) or csv (all markers)")
+	outPath := fs.String("out", "-", "output path, or '-' for stdout")
+	replays := global.parseArgs(fs, args, nil)
+
+	if len(replays) != 1 {
+		fatal(exitUsage, "exactly one replay is required")
+	}
+	offset, err := parseVODOffset(*offsetFlag)
+	if err != nil {
+		fatal(exitUsage, "parse -offset", "err", err)
+	}
+	markers, err := collectVODMarkers(replays[0])
+	if err != nil {
+		fatal(exitInvalidReplay, "parse replay", "err", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "-" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			fatal(exitIO, "create output", "err", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch *format {
+	case "youtube":
+		err = writeYouTubeChapters(out, markers, offset)
+	case "csv":
+		err = writeVODMarkersCSV(out, markers, offset)
+	default:
+		fatal(exitUsage, "unknown -format", "format", *format)
+	}
+	if err != nil {
+		fatal(exitIO, "write output", "err", err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseVODOffset(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		err  bool
+	}{
+		{in: "0", want: 0},
+		{in: "90", want: 90},
+		{in: "12.5", want: 12.5},
+		{in: "1:30", want: 90},
+		{in: "01:02:03", want: 3723},
+		{in: "0:00:07.5", want: 7.5},
+		{in: "", err: true},
+		{in: "1:2:3:4", err: true},
+		{in: "-5", err: true},
+		{in: "1:-5", err: true},
+		{in: "1m30s", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseVODOffset(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseVODOffset(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseVODOffset(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatVODTime(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0:00"},
+		{-3, "0:00"},
+		{59.9, "0:59"},
+		{90, "1:30"},
+		{3599, "59:59"},
+		{3600, "1:00:00"},
+		{3723, "1:02:03"},
+	}
+	for _, tt := range tests {
+		if got := formatVODTime(tt.in); got != tt.want {
+			t.Errorf("formatVODTime(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
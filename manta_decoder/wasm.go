@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+const (
+	// wasmMemoryPages caps a hook's linear memory at 256 MiB.
+	wasmMemoryPages = 4096
+	// wasmCallTimeout bounds one call into a hook, so a module that loops
+	// fails the run instead of hanging it.
+	wasmCallTimeout = 10 * time.Second
+)
+
+// The hook ABI. A module exports its memory and two functions:
+//
+//	faeton_alloc(len i32) -> i32
+//	faeton_record(ptr i32, len i32) -> i64
+//
+// For every record the host calls faeton_alloc for a buffer, writes the
+// record there as JSON and calls faeton_record, which answers with the
+// location of a wasmReply packed as ptr<<32 | len; a zero len keeps the
+// record and adds nothing. A reactor's _initialize runs once at start.
+const (
+	wasmAllocExport  = "faeton_alloc"
+	wasmRecordExport = "faeton_record"
+)
+
+// wasmReply is a hook's answer to one record.
+type wasmReply struct {
+	// Keep defaults to true; false drops the record from the output.
+	Keep    *bool            `json:"keep"`
+	Records []map[string]any `json:"records"`
+}
+
+// wasmHook is one module instantiated in the embedded runtime.
+type wasmHook struct {
+	name   string
+	mod    api.Module
+	alloc  api.Function
+	record api.Function
+}
+
+// wasmEncoder runs hooks synchronously in front of the output encoder, so
+// their filter decisions and derived records land in record order. Modules
+// run in wazero with WASI but no preopened directories, sockets, arguments
+// or environment; stderr goes to the decoder's stderr.
+type wasmEncoder struct {
+	inner   recordEncoder
+	kinds   map[string]bool
+	runtime wazero.Runtime
+	hooks   []*wasmHook
+}
+
+func newWASMEncoder(inner recordEncoder, modules []string, kinds string) (*wasmEncoder, error) {
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().WithMemoryLimitPages(wasmMemoryPages).WithCloseOnContextDone(true)
+	w := &wasmEncoder{inner: inner, kinds: parseKinds(kinds), runtime: wazero.NewRuntimeWithConfig(ctx, config)}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, w.runtime); err != nil {
+		w.close()
+		return nil, err
+	}
+	for i, module := range modules {
+		if err := w.load(ctx, i, module); err != nil {
+			w.close()
+			return nil, fmt.Errorf("wasm %s: %w", filepath.Base(module), err)
+		}
+	}
+	return w, nil
+}
+
+func (w *wasmEncoder) load(ctx context.Context, i int, module string) error {
+	code, err := os.ReadFile(module)
+	if err != nil {
+		return err
+	}
+	compiled, err := w.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(module)
+	config := wazero.NewModuleConfig().
+		WithName(fmt.Sprintf("hook%d:%s", i, name)).
+		WithStderr(os.Stderr).
+		WithStartFunctions("_initialize")
+	mod, err := w.runtime.InstantiateModule(ctx, compiled, config)
+	if err != nil {
+		return err
+	}
+	h := &wasmHook{name: name, mod: mod, alloc: mod.ExportedFunction(wasmAllocExport), record: mod.ExportedFunction(wasmRecordExport)}
+	if h.alloc == nil || h.record == nil || mod.Memory() == nil {
+		return fmt.Errorf("module must export memory, %s and %s", wasmAllocExport, wasmRecordExport)
+	}
+	w.hooks = append(w.hooks, h)
+	return nil
+}
+
+func (h *wasmHook) call(v any) (wasmReply, error) {
+	var reply wasmReply
+	data, err := json.Marshal(v)
+	if err != nil {
+		return reply, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wasmCallTimeout)
+	defer cancel()
+	res, err := h.alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return reply, err
+	}
+	ptr := uint32(res[0])
+	if !h.mod.Memory().Write(ptr, data) {
+		return reply, fmt.Errorf("%s returned %d, outside memory", wasmAllocExport, ptr)
+	}
+	res, err = h.record.Call(ctx, uint64(ptr), uint64(len(data)))
+	if err != nil {
+		return reply, err
+	}
+	at, n := uint32(res[0]>>32), uint32(res[0])
+	if n == 0 {
+		return reply, nil
+	}
+	out, ok := h.mod.Memory().Read(at, n)
+	if !ok {
+		return reply, fmt.Errorf("reply at %d+%d is outside memory", at, n)
+	}
+	if err := json.Unmarshal(out, &reply); err != nil {
+		return reply, fmt.Errorf("bad reply: %w", err)
+	}
+	return reply, nil
+}
+
+func (w *wasmEncoder) Encode(v any) error {
+	if w.kinds != nil && !w.kinds[recordKind(v)] {
+		return w.inner.Encode(v)
+	}
+	for _, h := range w.hooks {
+		reply, err := h.call(v)
+		if err != nil {
+			return fmt.Errorf("wasm %s: %w", h.name, err)
+		}
+		for _, rec := range reply.Records {
+			if _, ok := rec["kind"]; !ok {
+				rec["kind"] = "wasm"
+			}
+			rec["wasm"] = h.name
+			if err := w.inner.Encode(rec); err != nil {
+				return err
+			}
+		}
+		if reply.Keep != nil && !*reply.Keep {
+			return nil
+		}
+	}
+	return w.inner.Encode(v)
+}
+
+func (w *wasmEncoder) close() error {
+	return w.runtime.Close(context.Background())
+}
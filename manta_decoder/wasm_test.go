@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wasmSection is a section id with its already encoded content.
+func wasmSection(id byte, content ...byte) []byte {
+	return append(append([]byte{id}, binary.AppendUvarint(nil, uint64(len(content)))...), content...)
+}
+
+func wasmName(s string) []byte {
+	return append(binary.AppendUvarint(nil, uint64(len(s))), s...)
+}
+
+// hookModule assembles a module that answers every record with reply, which
+// it keeps in a data segment at offset 16. An empty reply keeps each record.
+// With exports false the faeton_* functions are not exported, and with
+// imports a function is imported from outside WASI.
+func hookModule(reply string, exports, imports bool) []byte {
+	m := []byte("\x00asm\x01\x00\x00\x00")
+	// (i32) -> i32 and (i32, i32) -> i64
+	m = append(m, wasmSection(1, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e)...)
+	if imports {
+		imp := append([]byte{0x01}, wasmName("env")...)
+		imp = append(append(imp, wasmName("open")...), 0x00, 0x00)
+		m = append(m, wasmSection(2, imp...)...)
+	}
+	m = append(m, wasmSection(3, 0x02, 0x00, 0x01)...)
+	m = append(m, wasmSection(5, 0x01, 0x00, 0x01)...)
+	exp := []byte{0x01}
+	exp = append(append(exp, wasmName("memory")...), 0x02, 0x00)
+	if exports {
+		exp[0] = 0x03
+		first := byte(0)
+		if imports {
+			first = 1
+		}
+		exp = append(append(exp, wasmName(wasmAllocExport)...), 0x00, first)
+		exp = append(append(exp, wasmName(wasmRecordExport)...), 0x00, first+1)
+	}
+	m = append(m, wasmSection(7, exp...)...)
+	// faeton_alloc returns 1024; faeton_record returns 16<<32 | len(reply).
+	alloc := []byte{0x00, 0x41, 0x80, 0x08, 0x0b}
+	packed := uint64(0)
+	if reply != "" {
+		packed = 16<<32 | uint64(len(reply))
+	}
+	record := append(appendSLEB128([]byte{0x00, 0x42}, int64(packed)), 0x0b)
+	code := []byte{0x02}
+	code = append(append(code, binary.AppendUvarint(nil, uint64(len(alloc)))...), alloc...)
+	code = append(append(code, binary.AppendUvarint(nil, uint64(len(record)))...), record...)
+	m = append(m, wasmSection(10, code...)...)
+	data := append([]byte{0x01, 0x00, 0x41, 0x10, 0x0b}, wasmName(reply)...)
+	return append(m, wasmSection(11, data...)...)
+}
+
+func appendSLEB128(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+type recordList []map[string]any
+
+func (l *recordList) Encode(v any) error {
+	*l = append(*l, v.(map[string]any))
+	return nil
+}
+
+func writeModule(t *testing.T, name string, code []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, code, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWASMHooks(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		kinds string
+		want  []string // kinds written, with wasm-tagged ones as kind@module
+	}{
+		{name: "keep.wasm", want: []string{"cast", "fight"}},
+		{name: "drop.wasm", reply: `{"keep": false, "records": [{"kind": "note"}, {}]}`, want: []string{"note@drop.wasm", "wasm@drop.wasm", "note@drop.wasm", "wasm@drop.wasm"}},
+		{name: "fights.wasm", reply: `{"keep": false}`, kinds: "fight", want: []string{"cast"}},
+	}
+	for _, tt := range tests {
+		var out recordList
+		w, err := newWASMEncoder(&out, []string{writeModule(t, tt.name, hookModule(tt.reply, true, false))}, tt.kinds)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, kind := range []string{"cast", "fight"} {
+			if err := w.Encode(map[string]any{"kind": kind}); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+		if err := w.close(); err != nil {
+			t.Error(err)
+		}
+		var got []string
+		for _, rec := range out {
+			kind := rec["kind"].(string)
+			if m, ok := rec["wasm"].(string); ok {
+				kind += "@" + m
+			}
+			got = append(got, kind)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: wrote %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWASMHookRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		err  string
+	}{
+		{"missing.wasm", nil, "no such file"},
+		{"garbage.wasm", []byte("#!/bin/sh\n"), "invalid magic number"},
+		{"noexports.wasm", hookModule("", false, false), "must export"},
+		{"imports.wasm", hookModule("", true, true), "module[env] not instantiated"},
+		{"badreply.wasm", hookModule("not json", true, false), "bad reply"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.name)
+		if tt.code != nil {
+			path = writeModule(t, tt.name, tt.code)
+		}
+		w, err := newWASMEncoder(&recordList{}, []string{path}, "")
+		if err == nil {
+			err = w.Encode(map[string]any{"kind": "cast"})
+			w.close()
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
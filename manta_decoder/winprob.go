@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dotabuff/manta"
+	"github.com/dotabuff/manta/dota"
+)
+
+// winProbSchemaVersion must be bumped whenever winProbFeatures changes order
+// or meaning, so trained models can refuse vectors they were not built for.
+const winProbSchemaVersion = 2
+
+// winProbFeatures names each column of a winprob_features vector. Diffs are
+// Radiant minus Dire.
+var winProbFeatures = []string{
+	"game_time",
+	"networth_diff",
+	"xp_diff",
+	"radiant_alive",
+	"dire_alive",
+	"radiant_towers",
+	"dire_towers",
+	"radiant_barracks",
+	"dire_barracks",
+	"roshan_alive",
+	"radiant_ults_ready",
+	"dire_ults_ready",
+	"night",
+}
+
+// teamDataSum adds up a per-player field of CDOTA_DataRadiant/Dire.
+func teamDataSum(parser *manta.Parser, team uint32, field string) float64 {
+	data := teamDataEntity(parser, team)
+	if data == nil {
+		return 0
+	}
+	var sum float64
+	for i := 0; i < 5; i++ {
+		if v, ok := data.GetInt32(teamDataField(int32(i), field)); ok {
+			sum += float64(v)
+		}
+	}
+	return sum
+}
+
+func boolFeature(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ultimateSlot is the m_vecAbilities index of Ability6 in the hero
+// definitions, where almost every hero's ultimate sits.
+const ultimateSlot = 5
+
+type winProbExtractor struct {
+	parser    *manta.Parser
+	rules     *gameRulesRef
+	ultimates map[string]bool
+}
+
+// onCombatLog learns which abilities are ultimates as they are cast, for the
+// heroes whose ultimate is not in ultimateSlot; the entity data does not flag
+// them.
+func (w *winProbExtractor) onCombatLog(m *dota.CMsgDOTACombatLogEntry) error {
+	if m.GetType() == dota.DOTA_COMBATLOG_TYPES_DOTA_COMBATLOG_ABILITY && m.GetIsUltimateAbility() {
+		if name := lookupCombatLogName(w.parser, m.GetInflictorName()); name != "" {
+			w.ultimates[name] = true
+		}
+	}
+	return nil
+}
+
+// ultimate returns the hero's ultimate: an ability already cast as one, or
+// else the ability in ultimateSlot, so it is known before its first cast.
+func (w *winProbExtractor) ultimate(hero *manta.Entity) *manta.Entity {
+	var slotted *manta.Entity
+	for i := 0; i < maxAbilitySlots; i++ {
+		h, ok := hero.GetUint32(fmt.Sprintf("m_vecAbilities.%04d", i))
+		if !ok {
+			break
+		}
+		if h == invalidHandle {
+			continue
+		}
+		a := w.parser.FindEntityByHandle(uint64(h))
+		if a == nil {
+			continue
+		}
+		if w.ultimates[entityUnitName(w.parser, a)] {
+			return a
+		}
+		if i == ultimateSlot {
+			slotted = a
+		}
+	}
+	return slotted
+}
+
+func (w *winProbExtractor) ultReady(hero *manta.Entity, gameTime float32) bool {
+	a := w.ultimate(hero)
+	if a == nil {
+		return false
+	}
+	mana, _ := hero.GetFloat32("m_flMana")
+	level, _ := a.GetInt32("m_iLevel")
+	cooldown, _ := a.GetFloat32("m_fCooldown")
+	cost, _ := a.GetInt32("m_iManaCost")
+	return level > 0 && cooldown <= gameTime && mana >= float32(cost)
+}
+
+func (w *winProbExtractor) features() []float64 {
+	rules := w.rules.get()
+	var gameTime float32
+	if rules != nil {
+		gameTime, _ = rules.GetFloat32("m_pGameRules.m_fGameTime")
+	}
+
+	alive := map[uint32]float64{}
+	ults := map[uint32]float64{}
+	for _, h := range realHeroes(w.parser) {
+		if !isAlive(h) {
+			continue
+		}
+		team := entityTeam(h)
+		alive[team]++
+		if w.ultReady(h, gameTime) {
+			ults[team]++
+		}
+	}
+
+	towers := map[uint32]float64{}
+	barracks := map[uint32]float64{}
+	roshan := false
+	for _, e := range w.parser.FilterEntity(func(e *manta.Entity) bool { return isAlive(e) }) {
+		name := e.GetClassName()
+		switch {
+		case strings.HasPrefix(name, "CDOTA_BaseNPC_Tower"):
+			towers[entityTeam(e)]++
+		case strings.HasPrefix(name, "CDOTA_BaseNPC_Barracks"):
+			barracks[entityTeam(e)]++
+		case name == "CDOTA_Unit_Roshan":
+			roshan = true
+		}
+	}
+
+	return []float64{
+		float64(gameClock(rules, gameTime)),
+		teamDataSum(w.parser, teamRadiant, "m_iNetWorth") - teamDataSum(w.parser, teamDire, "m_iNetWorth"),
+		teamDataSum(w.parser, teamRadiant, "m_iTotalEarnedXP") - teamDataSum(w.parser, teamDire, "m_iTotalEarnedXP"),
+		alive[teamRadiant],
+		alive[teamDire],
+		towers[teamRadiant],
+		towers[teamDire],
+		barracks[teamRadiant],
+		barracks[teamDire],
+		boolFeature(roshan),
+		ults[teamRadiant],
+		ults[teamDire],
+		boolFeature(isNight(rules)),
+	}
+}
+
+func newWinProbExtractor(parser *manta.Parser) *winProbExtractor {
+	w := &winProbExtractor{
+		parser:    parser,
+		rules:     newGameRulesRef(parser),
+		ultimates: map[string]bool{},
+	}
+	subscribeCombatLog(parser, w.onCombatLog)
+	return w
+}
+
+func (w *winProbExtractor) winner() int32 {
+	rules := w.rules.get()
+	if rules == nil {
+		return 0
+	}
+	winner, _ := rules.GetInt32("m_pGameRules.m_nGameWinner")
+	return winner
+}
+
+func registerWinProb(parser *manta.Parser, out *outputState, interval uint32, wrote *int) func() error {
+	w := newWinProbExtractor(parser)
+
+	schemaWritten := false
+	sampler := newTickSampler(interval)
+	parser.OnEntity(func(_ *manta.Entity, _ manta.EntityOp) error {
+		if !sampler.due(parser.Tick) {
+			return nil
+		}
+		if !schemaWritten {
+			schemaWritten = true
+			(*wrote)++
+			if err := out.add(parser.Tick, map[string]any{
+				"kind":           "winprob_schema",
+				"tick":           parser.Tick,
+				"schema_version": winProbSchemaVersion,
+				"features":       winProbFeatures,
+			}, false); err != nil {
+				return err
+			}
+		}
+		(*wrote)++
+		return out.add(parser.Tick, map[string]any{
+			"kind":           "winprob_features",
+			"tick":           parser.Tick,
+			"schema_version": winProbSchemaVersion,
+			"values":         w.features(),
+		}, false)
+	})
+
+	return func() error {
+		winner := w.winner()
+		(*wrote)++
+		return out.add(parser.Tick, map[string]any{
+			"kind":           "winprob_label",
+			"tick":           parser.Tick,
+			"schema_version": winProbSchemaVersion,
+			"winner":         winner,
+			"radiant_win":    winner == teamRadiant,
+		}, false)
+	}
+}